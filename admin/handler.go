@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type silenceRequest struct {
+	Unit     string `json:"unit"`
+	User     string `json:"user"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// ListSilencesHandler returns all active silences.
+func ListSilencesHandler() http.HandlerFunc {
+	return listHandler(silences)
+}
+
+// CreateSilenceHandler creates a silence from a unit or user, a reason, and a duration like "4h".
+func CreateSilenceHandler() http.HandlerFunc {
+	return createHandler(silences, "silence")
+}
+
+// DeleteSilenceHandler expires the silence with the id given in the path.
+func DeleteSilenceHandler() http.HandlerFunc {
+	return deleteHandler(silences, "silence")
+}
+
+// ListPausesHandler returns all active pauses of enforcement.
+func ListPausesHandler() http.HandlerFunc {
+	return listHandler(pauses)
+}
+
+// CreatePauseHandler pauses enforcement for a unit or user, given with a reason
+// and a duration like "48h".
+func CreatePauseHandler() http.HandlerFunc {
+	return createHandler(pauses, "pause")
+}
+
+// DeletePauseHandler ends the pause with the id given in the path.
+func DeletePauseHandler() http.HandlerFunc {
+	return deleteHandler(pauses, "pause")
+}
+
+func listHandler(store *silenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.active())
+	}
+}
+
+func createHandler(store *silenceStore, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request silenceRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		silence, err := store.add(Silence{
+			Unit:   request.Unit,
+			User:   request.User,
+			Reason: request.Reason,
+			EndsAt: time.Now().Add(duration),
+		})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		slog.Info("created "+kind, "id", silence.ID, "unit", silence.Unit, "user", silence.User, "until", silence.EndsAt)
+		writeJSON(w, http.StatusCreated, silence)
+	}
+}
+
+func deleteHandler(store *silenceStore, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !store.remove(id) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Info("deleted "+kind, "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListNotesHandler returns all notes, or the notes of the unit given by the 'unit' query parameter.
+func ListNotesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, notes.list(r.URL.Query().Get("unit")))
+	}
+}
+
+// CreateNoteHandler adds a note to a unit.
+func CreateNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request Note
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		note, err := notes.add(request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, note)
+	}
+}
+
+// DeleteNoteHandler removes the note with the id given in the path.
+func DeleteNoteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !notes.remove(r.PathValue("id")) {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type tagRequest struct {
+	Tag string `json:"tag"`
+}
+
+// ListTagsHandler returns the tags of every tagged unit.
+func ListTagsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tags.all())
+	}
+}
+
+// AddTagHandler tags the unit given in the path.
+func AddTagHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request tagRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		unit := r.PathValue("unit")
+		err = tags.add(unit, request.Tag)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		slog.Info("tagged unit", "unit", unit, "tag", request.Tag)
+		writeJSON(w, http.StatusOK, tags.get(unit))
+	}
+}
+
+// RemoveTagHandler removes the tag from the unit given in the path.
+func RemoveTagHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit, tag := r.PathValue("unit"), r.PathValue("tag")
+		if !tags.remove(unit, tag) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Info("untagged unit", "unit", unit, "tag", tag)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
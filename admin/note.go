@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Note is a free form comment left by an administrator on a unit.
+type Note struct {
+	ID        string    `json:"id"`
+	Unit      string    `json:"unit"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type noteStore struct {
+	data  map[string]Note
+	mutex sync.Mutex
+}
+
+func newNoteStore() *noteStore {
+	return &noteStore{
+		data:  make(map[string]Note),
+		mutex: sync.Mutex{},
+	}
+}
+
+func (ns *noteStore) add(n Note) (Note, error) {
+	if n.Unit == "" || n.Text == "" {
+		return n, errors.New("note requires a unit and text")
+	}
+
+	n.ID = newID()
+	n.Unit = path.Base(n.Unit)
+	n.CreatedAt = time.Now()
+
+	defer ns.mutex.Unlock()
+	ns.mutex.Lock()
+	ns.data[n.ID] = n
+	return n, nil
+}
+
+func (ns *noteStore) remove(id string) bool {
+	defer ns.mutex.Unlock()
+	ns.mutex.Lock()
+	_, ok := ns.data[id]
+	delete(ns.data, id)
+	return ok
+}
+
+// list returns the notes for a unit, or all notes if unit is empty
+func (ns *noteStore) list(unit string) []Note {
+	defer ns.mutex.Unlock()
+	ns.mutex.Lock()
+
+	var notes []Note
+	for _, n := range ns.data {
+		if unit == "" || n.Unit == path.Base(unit) {
+			notes = append(notes, n)
+		}
+	}
+
+	slices.SortFunc(notes, func(a, b Note) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return notes
+}
+
+var notes = newNoteStore()
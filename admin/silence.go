@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Silence suppresses notifications and enforcement actions for a unit or user
+// until it expires. Metrics are still collected for silenced units.
+type Silence struct {
+	ID        string    `json:"id"`
+	Unit      string    `json:"unit,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	EndsAt    time.Time `json:"endsAt"`
+}
+
+func (s Silence) matches(unit, user string) bool {
+	if s.Unit != "" && path.Base(s.Unit) != path.Base(unit) {
+		return false
+	}
+	if s.User != "" && s.User != user {
+		return false
+	}
+	return true
+}
+
+func (s Silence) expired(now time.Time) bool {
+	return !now.Before(s.EndsAt)
+}
+
+type silenceStore struct {
+	data  map[string]Silence
+	mutex sync.Mutex
+}
+
+func newSilenceStore() *silenceStore {
+	return &silenceStore{
+		data:  make(map[string]Silence),
+		mutex: sync.Mutex{},
+	}
+}
+
+func (ss *silenceStore) add(s Silence) (Silence, error) {
+	if s.Unit == "" && s.User == "" {
+		return s, errors.New("silence requires a unit or a user")
+	}
+
+	now := time.Now()
+	if s.expired(now) {
+		return s, errors.New("silence must end in the future")
+	}
+
+	s.ID = newID()
+	s.CreatedAt = now
+
+	defer ss.mutex.Unlock()
+	ss.mutex.Lock()
+	ss.data[s.ID] = s
+	return s, nil
+}
+
+func (ss *silenceStore) remove(id string) bool {
+	defer ss.mutex.Unlock()
+	ss.mutex.Lock()
+	_, ok := ss.data[id]
+	delete(ss.data, id)
+	return ok
+}
+
+// active returns the unexpired silences, removing any that have expired
+func (ss *silenceStore) active() []Silence {
+	defer ss.mutex.Unlock()
+	ss.mutex.Lock()
+
+	now := time.Now()
+	var silences []Silence
+	for id, s := range ss.data {
+		if s.expired(now) {
+			delete(ss.data, id)
+			continue
+		}
+		silences = append(silences, s)
+	}
+
+	slices.SortFunc(silences, func(a, b Silence) int {
+		return a.EndsAt.Compare(b.EndsAt)
+	})
+	return silences
+}
+
+var silences = newSilenceStore()
+
+// pauses of enforcement, which match units and users like silences
+var pauses = newSilenceStore()
+
+// Silenced returns the active silence matching the unit or user, if any.
+func Silenced(unit, user string) (Silence, bool) {
+	for _, s := range silences.active() {
+		if s.matches(unit, user) {
+			return s, true
+		}
+	}
+	return Silence{}, false
+}
+
+// Paused returns the active pause of enforcement matching the unit or user, if
+// any. The policy does not penalize a paused unit, while its violations are still
+// reported.
+func Paused(unit, user string) (Silence, bool) {
+	for _, p := range pauses.active() {
+		if p.matches(unit, user) {
+			return p, true
+		}
+	}
+	return Silence{}, false
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"errors"
+	"maps"
+	"path"
+	"slices"
+	"sync"
+)
+
+// tags are kept per unit name, as a set
+type tagStore struct {
+	data  map[string]map[string]bool
+	mutex sync.Mutex
+}
+
+func newTagStore() *tagStore {
+	return &tagStore{
+		data:  make(map[string]map[string]bool),
+		mutex: sync.Mutex{},
+	}
+}
+
+func (ts *tagStore) add(unit string, tag string) error {
+	if unit == "" || tag == "" {
+		return errors.New("tag requires a unit and a tag")
+	}
+
+	defer ts.mutex.Unlock()
+	ts.mutex.Lock()
+
+	unit = path.Base(unit)
+	tags, ok := ts.data[unit]
+	if !ok {
+		tags = make(map[string]bool)
+		ts.data[unit] = tags
+	}
+	tags[tag] = true
+	return nil
+}
+
+func (ts *tagStore) remove(unit string, tag string) bool {
+	defer ts.mutex.Unlock()
+	ts.mutex.Lock()
+
+	unit = path.Base(unit)
+	tags, ok := ts.data[unit]
+	if !ok || !tags[tag] {
+		return false
+	}
+
+	delete(tags, tag)
+	if len(tags) == 0 {
+		delete(ts.data, unit)
+	}
+	return true
+}
+
+func (ts *tagStore) get(unit string) []string {
+	defer ts.mutex.Unlock()
+	ts.mutex.Lock()
+	return slices.Sorted(maps.Keys(ts.data[path.Base(unit)]))
+}
+
+func (ts *tagStore) all() map[string][]string {
+	defer ts.mutex.Unlock()
+	ts.mutex.Lock()
+
+	all := make(map[string][]string, len(ts.data))
+	for unit, tags := range ts.data {
+		all[unit] = slices.Sorted(maps.Keys(tags))
+	}
+	return all
+}
+
+var tags = newTagStore()
+
+// Tags returns the tags of a unit, sorted.
+func Tags(unit string) []string {
+	return tags.get(unit)
+}
+
+// Tagged returns whether the unit has the tag.
+func Tagged(unit string, tag string) bool {
+	return slices.Contains(tags.get(unit), tag)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+)
+
+// allowNetworks only lets requests from the networks through to the handler,
+// before they are authenticated, or every request if no network is given
+func allowNetworks(next http.Handler, networks []netip.Prefix) http.Handler {
+	if len(networks) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !fromNetworks(r.RemoteAddr, networks) {
+			slog.Warn("request from a network not allowed", "address", r.RemoteAddr, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromNetworks reports whether the remote address of a request is in one of the
+// networks, IPv4 addresses mapped to IPv6 included
+func fromNetworks(remote string, networks []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	return slices.ContainsFunc(networks, func(network netip.Prefix) bool {
+		return network.Contains(addr)
+	})
+}
@@ -0,0 +1,334 @@
+// Package audit records every change the warden makes to units, and who asked
+// for it, in a log chained by hashes so that removed or altered entries are
+// detected.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/status"
+	"github.com/coreos/go-systemd/v22/journal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Entry is a single change made to a unit, or an attempt to make one
+type Entry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Address  string    `json:"address,omitempty"`
+	Action   string    `json:"action"`
+	Unit     string    `json:"unit"`
+	Property string    `json:"property,omitempty"`
+	Old      any       `json:"old,omitempty"`
+	New      any       `json:"new,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	DryRun   bool      `json:"dryRun,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// hash of the previous entry, and of this entry including it
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// digest returns the hash of the entry, which covers every field but the hash
+func (e Entry) digest() (string, error) {
+	e.Hash = ""
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type auditLog struct {
+	file    *os.File
+	journal bool
+
+	// last entries recorded, oldest first, for the api
+	entries []Entry
+	seq     uint64
+	hash    string
+	mutex   sync.Mutex
+}
+
+// how many entries are kept in memory for the api
+const maxEntries = 10000
+
+var current *auditLog
+
+// Open starts recording entries, appended to the file if given and sent to the
+// journal if journal is set. The chain of an existing file is verified and
+// continued.
+func Open(file string, toJournal bool) error {
+	l := &auditLog{journal: toJournal}
+	if toJournal && !journal.Enabled() {
+		return errors.New("journal is not available")
+	}
+
+	if file != "" {
+		entries, start, err := readLog(file)
+		if err != nil {
+			return err
+		}
+		if err := verify(entries, start); err != nil {
+			slog.Warn("audit log has been tampered with", "file", file, "err", err)
+		}
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			l.seq, l.hash = last.Seq, last.Hash
+		}
+		l.entries = entries[max(0, len(entries)-maxEntries):]
+
+		if err := os.MkdirAll(path.Dir(file), 0o700); err != nil {
+			return err
+		}
+		l.file, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+
+	current = l
+	return nil
+}
+
+// Enabled reports whether entries are recorded, so that callers can skip reading
+// the values properties had before changing them
+func Enabled() bool {
+	return current != nil
+}
+
+// Actions counts the changes made successfully, whether or not they are logged,
+// to be registered alongside the cgroup metrics.
+var Actions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cgroup_warden",
+	Name:      "enforcement_actions",
+	Help:      "Number of enforcement actions taken by the warden, by action and actor",
+}, []string{"action", "actor"})
+
+// Record appends the entry to the log, with the actor of the context and the
+// error of the change if any
+func Record(ctx context.Context, e Entry, err error) {
+	a := actorOf(ctx)
+	if err == nil && !e.DryRun {
+		Actions.WithLabelValues(e.Action, a.name).Inc()
+	}
+
+	l := current
+	if l == nil {
+		return
+	}
+
+	e.Actor, e.Address = a.name, a.address
+	e.Old, e.New = normalize(e.Old), normalize(e.New)
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	defer l.mutex.Unlock()
+	l.mutex.Lock()
+
+	e.Seq = l.seq + 1
+	e.Time = time.Now()
+	e.Prev = l.hash
+	e.Hash, err = e.digest()
+	if err != nil {
+		slog.Warn("unable to record audit entry", "unit", e.Unit, "action", e.Action, "err", err)
+		status.Report(status.Audit, e.Unit, err)
+		return
+	}
+
+	if l.file != nil {
+		if err := writeEntry(l.file, e); err != nil {
+			slog.Warn("unable to write audit entry", "unit", e.Unit, "action", e.Action, "err", err)
+			status.Report(status.Audit, e.Unit, err)
+			return
+		}
+		if err := l.rotateFull(); err != nil {
+			slog.Warn("unable to rotate audit log", "file", l.fileName(), "err", err)
+			status.Report(status.Audit, e.Unit, err)
+		}
+	}
+	if l.journal {
+		if err := sendEntry(e); err != nil {
+			slog.Warn("unable to send audit entry to the journal", "unit", e.Unit, "action", e.Action, "err", err)
+			status.Report(status.Audit, e.Unit, err)
+		}
+	}
+
+	l.seq, l.hash = e.Seq, e.Hash
+	l.entries = append(l.entries, e)
+	if len(l.entries) > maxEntries {
+		l.entries = l.entries[len(l.entries)-maxEntries:]
+	}
+}
+
+// normalize returns the value as it is read back from the log, so that the hash
+// of an entry does not change once written
+func normalize(value any) any {
+	if value == nil {
+		return nil
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	var v any
+	json.Unmarshal(buf, &v)
+	return v
+}
+
+func writeEntry(f *os.File, e Entry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func sendEntry(e Entry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%s %s of %s", e.Actor, e.Action, e.Unit)
+	if e.Property != "" {
+		message = fmt.Sprintf("%s %s %s of %s", e.Actor, e.Action, e.Property, e.Unit)
+	}
+	return journal.Send(message, journal.PriNotice, map[string]string{
+		"SYSLOG_IDENTIFIER": "cgroup-warden-audit",
+		"AUDIT_SEQ":         strconv.FormatUint(e.Seq, 10),
+		"AUDIT_ACTOR":       e.Actor,
+		"AUDIT_ACTION":      e.Action,
+		"AUDIT_UNIT":        e.Unit,
+		"AUDIT_HASH":        e.Hash,
+		"AUDIT_ENTRY":       string(buf),
+	})
+}
+
+// readFile returns the entries of a log file, none if it does not exist
+func readFile(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readEntries(f, file)
+}
+
+// readEntries returns the entries read from a log file, one per line
+func readEntries(r io.Reader, file string) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("unable to parse line %d of %s: %w", line, file, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// verify checks the chain of a whole log, which starts with the entry numbered start
+func verify(entries []Entry, start uint64) error {
+	if len(entries) > 0 && entries[0].Seq != start {
+		return fmt.Errorf("entries before %d are missing", entries[0].Seq)
+	}
+	return verifyChain(entries)
+}
+
+// verifyChain checks that every entry is unchanged and follows the one before it
+func verifyChain(entries []Entry) error {
+	for i, e := range entries {
+		hash, err := e.digest()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("entry %d has been altered", e.Seq)
+		}
+		if i > 0 && (e.Prev != entries[i-1].Hash || e.Seq != entries[i-1].Seq+1) {
+			return fmt.Errorf("entries between %d and %d are missing", entries[i-1].Seq, e.Seq)
+		}
+	}
+	return nil
+}
+
+// Verify checks the chain of a log file and of its rotated files, returning the
+// number of entries
+func Verify(file string) (int, error) {
+	entries, start, err := readLog(file)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), verify(entries, start)
+}
+
+// fileName returns the name of the file of the log, if it has one
+func (l *auditLog) fileName() string {
+	if l.file == nil {
+		return ""
+	}
+	return l.file.Name()
+}
+
+// actor is who asked for a change, an api client or a task of the warden
+type actor struct {
+	name    string
+	address string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context for changes made by a task of the warden, like
+// the policy engine
+func WithActor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{name: name})
+}
+
+// Identify attributes the changes made by requests to the handler to the named
+// client and the address of the request, or to the common name of the client
+// certificate of the request if it was verified
+func Identify(next http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := actor{name: name, address: r.RemoteAddr}
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && r.TLS.VerifiedChains[0][0].Subject.CommonName != "" {
+			a.name = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		}
+		ctx := context.WithValue(r.Context(), actorKey{}, a)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func actorOf(ctx context.Context) actor {
+	if a, ok := ctx.Value(actorKey{}).(actor); ok {
+		return a
+	}
+	return actor{name: "warden"}
+}
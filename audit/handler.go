@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// how many entries are returned unless a limit is given
+const defaultLimit = 100
+
+// ListHandler returns the most recent entries, oldest first, filtered by the
+// 'unit', 'actor' and 'action' query parameters, and 'since' a time like
+// "2026-10-14T09:00:00Z". At most 'limit' entries are returned, 100 by default.
+func ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := current
+		if l == nil {
+			writeError(w, http.StatusNotFound, errors.New("audit log is disabled"))
+			return
+		}
+
+		query := r.URL.Query()
+		q := Query{Unit: query.Get("unit"), Actor: query.Get("actor"), Action: query.Get("action"), Limit: defaultLimit}
+
+		if s := query.Get("since"); s != "" {
+			var err error
+			q.Since, err = time.Parse(time.RFC3339, s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("invalid since, expected a time like 2026-10-14T09:00:00Z"))
+				return
+			}
+		}
+
+		if s := query.Get("limit"); s != "" {
+			var err error
+			q.Limit, err = strconv.Atoi(s)
+			if err != nil || q.Limit <= 0 {
+				writeError(w, http.StatusBadRequest, errors.New("invalid limit, expected a positive number"))
+				return
+			}
+		}
+
+		l.mutex.Lock()
+		entries := q.filter(l.entries)
+		l.mutex.Unlock()
+
+		if entries == nil {
+			entries = []Entry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+type verifyResponse struct {
+	File    string `json:"file,omitempty"`
+	Entries int    `json:"entries"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+// VerifyHandler checks the chain of the log file, or of the entries in memory if
+// the log is only sent to the journal.
+func VerifyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := current
+		if l == nil {
+			writeError(w, http.StatusNotFound, errors.New("audit log is disabled"))
+			return
+		}
+
+		response := verifyResponse{File: l.fileName()}
+		var err error
+		// held so that the file is not rotated while it is read
+		l.mutex.Lock()
+		if response.File != "" {
+			response.Entries, err = Verify(response.File)
+		} else {
+			response.Entries = len(l.entries)
+			err = verifyChain(l.entries)
+		}
+		l.mutex.Unlock()
+
+		response.Valid = err == nil
+		if err != nil {
+			response.Error = err.Error()
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"path"
+	"slices"
+	"time"
+)
+
+// Query selects entries of the log by unit, actor, action and time. Empty fields
+// select every entry.
+type Query struct {
+	Unit   string
+	Actor  string
+	Action string
+	Since  time.Time
+
+	// the most recent entries selected are kept, all of them if 0
+	Limit int
+}
+
+func (q Query) matches(e Entry) bool {
+	return (q.Unit == "" || e.Unit == path.Base(q.Unit)) && (q.Actor == "" || e.Actor == q.Actor) && (q.Action == "" || e.Action == q.Action)
+}
+
+// filter returns the most recent of the entries, oldest first, selected by the query
+func (q Query) filter(entries []Entry) []Entry {
+	var selected []Entry
+	for _, e := range slices.Backward(entries) {
+		if e.Time.Before(q.Since) || (q.Limit > 0 && len(selected) == q.Limit) {
+			break
+		}
+		if q.matches(e) {
+			selected = append(selected, e)
+		}
+	}
+	slices.Reverse(selected)
+	return selected
+}
+
+// ReadFile returns the entries of a log file and of its rotated files selected by
+// the query, oldest first, to read the log while the warden is not running.
+func ReadFile(file string, q Query) ([]Entry, error) {
+	entries, _, err := readLog(file)
+	if err != nil {
+		return nil, err
+	}
+	return q.filter(entries), nil
+}
@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxSize is the size in bytes past which the log file is rotated, never if 0
+var MaxSize uint64
+
+// Keep is how many rotated files of the log are kept, all of them if 0
+var Keep = 10
+
+// rotatedFile is a compressed file of entries rotated out of the log file, named
+// by the sequence number of its first entry
+type rotatedFile struct {
+	name string
+	seq  uint64
+}
+
+// rotatedFiles returns the rotated files of a log file, oldest first
+func rotatedFiles(file string) ([]rotatedFile, error) {
+	dir, base := path.Dir(file), path.Base(file)
+	dirEntries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rotatedFile
+	for _, d := range dirEntries {
+		seq, ok := strings.CutPrefix(d.Name(), base+".")
+		if !ok {
+			continue
+		}
+		seq, ok = strings.CutSuffix(seq, ".gz")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(seq, 10, 64)
+		if err == nil {
+			files = append(files, rotatedFile{name: path.Join(dir, d.Name()), seq: n})
+		}
+	}
+	slices.SortFunc(files, func(a, b rotatedFile) int { return cmp.Compare(a.seq, b.seq) })
+	return files, nil
+}
+
+// readCompressed returns the entries of a rotated file
+func readCompressed(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", file, err)
+	}
+	defer zr.Close()
+	return readEntries(zr, file)
+}
+
+// readLog returns the entries of a log file and of its rotated files, oldest
+// first, and the sequence number of the first entry kept, which is past 1 once
+// the oldest rotated files have been removed
+func readLog(file string) ([]Entry, uint64, error) {
+	files, err := rotatedFiles(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	start := uint64(1)
+	for i, r := range files {
+		if i == 0 {
+			start = r.seq
+		}
+		rotated, err := readCompressed(r.name)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, rotated...)
+	}
+
+	current, err := readFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	// entries of a file rotated but not yet emptied are read only once
+	for len(entries) > 0 && len(current) > 0 && current[0].Seq <= entries[len(entries)-1].Seq {
+		current = current[1:]
+	}
+	return append(entries, current...), start, nil
+}
+
+// rotateFull rotates the log file once it has grown to MaxSize
+func (l *auditLog) rotateFull() error {
+	if MaxSize == 0 {
+		return nil
+	}
+	info, err := l.file.Stat()
+	if err != nil || uint64(info.Size()) < MaxSize {
+		return err
+	}
+	return rotate(l.file)
+}
+
+// rotate compresses the entries of the log file into a rotated file, empties the
+// log file, and removes the oldest rotated files past Keep. The chain continues
+// into the emptied file.
+func rotate(f *os.File) error {
+	file := f.Name()
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	line, _, _ := bytes.Cut(buf, []byte("\n"))
+	var first Entry
+	if err := json.Unmarshal(line, &first); err != nil {
+		return fmt.Errorf("unable to parse line 1 of %s: %w", file, err)
+	}
+
+	rotated := fmt.Sprintf("%s.%d.gz", file, first.Seq)
+	tmp, err := os.OpenFile(rotated+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(tmp)
+	_, err = zw.Write(buf)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), rotated)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	return prune(file)
+}
+
+// prune removes the oldest rotated files of a log file past Keep
+func prune(file string) error {
+	files, err := rotatedFiles(file)
+	if err != nil || Keep == 0 || len(files) <= Keep {
+		return err
+	}
+	for _, r := range files[:len(files)-Keep] {
+		if err := os.Remove(r.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
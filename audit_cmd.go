@@ -0,0 +1,367 @@
+//go:build !readonly
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/policy"
+	"github.com/chpc-uofu/cgroup-warden/state"
+)
+
+// auditSource is where the audit subcommands read entries from, the api of the
+// warden or, while it is not running, its audit file
+type auditSource struct {
+	url     string
+	token   string
+	file    string
+	offline bool
+}
+
+func (s *auditSource) flags(fs *flag.FlagSet) {
+	fs.StringVar(&s.url, "url", envOr("CGROUP_WARDEN_URL", "http://localhost:2112"), "url of the warden, or of its control api if served separately")
+	fs.StringVar(&s.token, "token", envOr("CGROUP_WARDEN_TOKEN", os.Getenv("CGROUP_WARDEN_BEARER_TOKEN")), "bearer token granted unit:read")
+	fs.StringVar(&s.file, "file", os.Getenv("CGROUP_WARDEN_AUDIT_FILE"), "audit file read when the warden is not running")
+	fs.BoolVar(&s.offline, "offline", false, "read the audit file without querying the warden")
+}
+
+// entries returns the entries selected by the query from the warden, or from the
+// audit file if the warden cannot be reached
+func (s *auditSource) entries(q audit.Query) ([]audit.Entry, error) {
+	if !s.offline {
+		entries, err := s.query(q)
+		var netErr net.Error
+		if !errors.As(err, &netErr) || s.file == "" {
+			return entries, err
+		}
+		fmt.Fprintf(os.Stderr, "unable to reach the warden, reading %s: %v\n", s.file, err)
+	}
+
+	if s.file == "" {
+		return nil, errors.New("an audit file is required to read the audit log offline")
+	}
+	return audit.ReadFile(s.file, q)
+}
+
+// query queries the entries from the audit endpoint of the warden
+func (s *auditSource) query(q audit.Query) ([]audit.Entry, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{"unit": q.Unit, "actor": q.Actor, "action": q.Action} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.UTC().Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(s.url, "/")+"/audit?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		buf, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(buf, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(buf))
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, e.Error)
+	}
+
+	var entries []audit.Entry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, err
+}
+
+// parseSince parses a time like "2026-10-14T09:00:00Z", or how long ago like
+// "24h" or "7d"
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid since '%s', expected a duration like 24h or 7d, or a time like 2026-10-14T09:00:00Z", s)
+	}
+	return now.Add(-d), nil
+}
+
+// userUnit returns the slice of a user, given by name or uid
+func userUnit(name string) (string, error) {
+	uid := name
+	if _, err := strconv.Atoi(name); err != nil {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		uid = u.Uid
+	}
+	return fmt.Sprintf("user-%s.slice", uid), nil
+}
+
+// formatValue formats the old or new value of an entry for a table
+func formatValue(v any) string {
+	switch v.(type) {
+	case nil:
+		return "-"
+	case string, float64, bool:
+		return fmt.Sprint(v)
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printEntries prints the entries as a table, with their unit unless the entries
+// are all of one unit
+func printEntries(entries []audit.Entry, withUnit bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if withUnit {
+		fmt.Fprint(tw, "TIME\tACTOR\tACTION\tUNIT\tPROPERTY\tOLD\tNEW\tRESULT\n")
+	} else {
+		fmt.Fprint(tw, "TIME\tACTOR\tACTION\tPROPERTY\tOLD\tNEW\tRESULT\n")
+	}
+	for _, e := range entries {
+		result := "ok"
+		if e.DryRun {
+			result = "dry run"
+		}
+		if e.Error != "" {
+			result = "error: " + e.Error
+		}
+		property := e.Property
+		if property == "" {
+			property = e.Summary
+		}
+
+		columns := []string{e.Time.Local().Format("2006-01-02 15:04:05"), e.Actor, e.Action}
+		if withUnit {
+			columns = append(columns, e.Unit)
+		}
+		columns = append(columns, property, formatValue(e.Old), formatValue(e.New), result)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	}
+	tw.Flush()
+}
+
+// runAudit implements the audit subcommand, listing the changes made to units
+// and who made them.
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	var source auditSource
+	source.flags(fs)
+	username := fs.String("user", "", "only list the changes of the unit of this user, by name or uid")
+	unit := fs.String("unit", "", "only list the changes of this unit")
+	actor := fs.String("actor", "", "only list the changes made by this actor, like a token name or policy")
+	action := fs.String("action", "", "only list the changes of this action, like set, freeze or kill")
+	since := fs.String("since", "", "only list the changes since this time, or this long ago like 24h or 7d")
+	limit := fs.Int("limit", 100, "list at most this many of the most recent changes")
+	asJSON := fs.Bool("json", false, "print the entries as json")
+	fs.Parse(args)
+
+	q := audit.Query{Unit: *unit, Actor: *actor, Action: *action, Limit: *limit}
+	var err error
+	q.Since, err = parseSince(*since, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *username != "" {
+		if *unit != "" {
+			fmt.Fprintln(os.Stderr, "only one of -user and -unit may be given")
+			return 1
+		}
+		q.Unit, err = userUnit(*username)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	entries, err := source.entries(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+		return printJSON(entries)
+	}
+	printEntries(entries, q.Unit == "")
+	return 0
+}
+
+// unitHistory is the history of a unit, with its enforcement state as last saved
+// by the warden
+type unitHistory struct {
+	Unit      string               `json:"unit"`
+	Penalty   *policy.PenaltyState `json:"penalty,omitempty"`
+	Changes   map[string]any       `json:"runtimeChanges,omitempty"`
+	ThawAt    *time.Time           `json:"thawAt,omitempty"`
+	Pins      []policy.Pin         `json:"pins,omitempty"`
+	Entries   []audit.Entry        `json:"entries"`
+	StateFile string               `json:"stateFile,omitempty"`
+}
+
+// loadUnitState adds the enforcement state of the unit saved in the file to its
+// history
+func (h *unitHistory) loadUnitState(file string) error {
+	var s enforcementState
+	if err := state.ReadJSON(file, &s); err != nil {
+		return err
+	}
+	h.StateFile = file
+
+	for cg, p := range s.Policy.Penalties {
+		if path.Base(cg) == h.Unit {
+			h.Penalty = &p
+		}
+	}
+	for _, property := range s.Control.Changes[h.Unit] {
+		if h.Changes == nil {
+			h.Changes = make(map[string]any)
+		}
+		h.Changes[property.Name] = property.Value
+	}
+	if t, ok := s.Control.Thaws[h.Unit]; ok {
+		h.ThawAt = &t
+	}
+	for _, p := range s.Policy.Pins {
+		if p.Unit == h.Unit {
+			h.Pins = append(h.Pins, p)
+		}
+	}
+	return nil
+}
+
+// runHistory implements the history subcommand, reporting the enforcement state
+// of a unit and every change made to it.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	var source auditSource
+	source.flags(fs)
+	unit := fs.String("unit", "", "report the history of this unit")
+	username := fs.String("user", "", "report the history of the unit of this user, by name or uid")
+	stateFile := fs.String("state", envOr("CGROUP_WARDEN_ENFORCEMENT_FILE", "/var/lib/cgroup-warden/enforcement.json"), "path of the enforcement state file")
+	since := fs.String("since", "", "only report the changes since this time, or this long ago like 24h or 7d")
+	limit := fs.Int("limit", 1000, "report at most this many of the most recent changes")
+	asJSON := fs.Bool("json", false, "print the history as json")
+	fs.Parse(args)
+
+	if (*unit == "") == (*username == "") {
+		fmt.Fprintln(os.Stderr, "one of -unit or -user is required")
+		return 1
+	}
+
+	h := unitHistory{Unit: path.Base(*unit)}
+	var err error
+	if *username != "" {
+		h.Unit, err = userUnit(*username)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	q := audit.Query{Unit: h.Unit, Limit: *limit}
+	q.Since, err = parseSince(*since, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *stateFile != "" {
+		if err := h.loadUnitState(*stateFile); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to read the enforcement state: %v\n", err)
+		}
+	}
+
+	h.Entries, err = source.entries(q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		if h.Entries == nil {
+			h.Entries = []audit.Entry{}
+		}
+		return printJSON(h)
+	}
+
+	fmt.Println(h.Unit)
+	if h.Penalty != nil {
+		fmt.Printf("  penalty tier %d since %s, %d violations\n", h.Penalty.Tier, h.Penalty.Since.Local().Format("2006-01-02 15:04:05"), h.Penalty.Violations)
+	} else {
+		fmt.Println("  not penalized")
+	}
+	for _, name := range slices.Sorted(maps.Keys(h.Changes)) {
+		fmt.Printf("  %s changed at runtime, reset restores %s\n", name, formatValue(h.Changes[name]))
+	}
+	if h.ThawAt != nil {
+		fmt.Printf("  frozen, thawed at %s\n", h.ThawAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	for _, p := range h.Pins {
+		fmt.Printf("  %s pinned to %s\n", p.Property, formatValue(p.Value))
+	}
+	fmt.Println()
+
+	printEntries(h.Entries, false)
+	return 0
+}
@@ -0,0 +1,179 @@
+// Package authorizer consults an optional external service to decide whether a
+// unit may be collected or enforced, allowing centrally managed opt-outs.
+package authorizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+)
+
+type request struct {
+	Unit     string `json:"unit"`
+	Username string `json:"username"`
+}
+
+// Decision is the response of the external authorizer for a unit.
+type Decision struct {
+	Collect bool `json:"collect"`
+	Enforce bool `json:"enforce"`
+}
+
+var allow = Decision{Collect: true, Enforce: true}
+
+// Authorizer decides whether a unit may be collected or enforced.
+type Authorizer interface {
+	Authorize(ctx context.Context, unit string, username string) (Decision, error)
+}
+
+// HTTP posts the unit and username as JSON to a URL, which responds with a Decision.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h *HTTP) Authorize(ctx context.Context, unit string, username string) (Decision, error) {
+	var decision Decision
+
+	body, err := json.Marshal(request{Unit: unit, Username: username})
+	if err != nil {
+		return decision, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return decision, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return decision, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decision, fmt.Errorf("authorizer responded with status %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&decision)
+	return decision, err
+}
+
+// Command runs a program with the unit and username as arguments, which prints a Decision as JSON.
+type Command struct {
+	Path string
+}
+
+func (c *Command) Authorize(ctx context.Context, unit string, username string) (Decision, error) {
+	var decision Decision
+
+	out, err := exec.CommandContext(ctx, c.Path, unit, username).Output()
+	if err != nil {
+		return decision, err
+	}
+
+	err = json.Unmarshal(out, &decision)
+	return decision, err
+}
+
+// how long the fallback decision is used after the authorizer failed, before it
+// is consulted again, unless the cache ttl is shorter
+const failureTTL = 30 * time.Second
+
+type cached struct {
+	decision Decision
+	expires  time.Time
+}
+
+// Cache remembers the decisions of an authorizer so it is not consulted on
+// every scrape. If the authorizer fails, the fallback decision is used for every
+// unit not cached for a short while, so that a scrape does not wait for the
+// authorizer to time out on each unit.
+type Cache struct {
+	authorizer Authorizer
+	ttl        time.Duration
+	timeout    time.Duration
+	fallback   Decision
+	data       map[string]cached
+	failed     time.Time
+	mutex      sync.Mutex
+}
+
+func NewCache(a Authorizer, ttl time.Duration, timeout time.Duration, failOpen bool) *Cache {
+	c := &Cache{
+		authorizer: a,
+		ttl:        ttl,
+		timeout:    timeout,
+		data:       make(map[string]cached),
+		mutex:      sync.Mutex{},
+	}
+	if failOpen {
+		c.fallback = allow
+	}
+	return c
+}
+
+func (c *Cache) decide(unit string, username string) Decision {
+	unit = path.Base(unit)
+	now := time.Now()
+
+	c.mutex.Lock()
+	entry, ok := c.data[unit]
+	failing := now.Before(c.failed.Add(min(c.ttl, failureTTL)))
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.decision
+	}
+	if failing {
+		return c.fallback
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	decision, err := c.authorizer.Authorize(ctx, unit, username)
+	if err != nil {
+		slog.Warn("unable to consult authorizer, using fallback decision", "unit", unit, "err", err, "collect", c.fallback.Collect, "enforce", c.fallback.Enforce, "for", min(c.ttl, failureTTL))
+		c.mutex.Lock()
+		c.failed = time.Now()
+		c.mutex.Unlock()
+		return c.fallback
+	}
+
+	defer c.mutex.Unlock()
+	c.mutex.Lock()
+	for u, e := range c.data {
+		if !now.Before(e.expires) {
+			delete(c.data, u)
+		}
+	}
+	c.data[unit] = cached{decision: decision, expires: now.Add(c.ttl)}
+	return decision
+}
+
+// Default is consulted for every unit, if nil all units are allowed.
+var Default *Cache
+
+// Collect returns whether metrics may be collected for the unit.
+func Collect(unit string, username string) bool {
+	if Default == nil {
+		return true
+	}
+	return Default.decide(unit, username).Collect
+}
+
+// Enforce returns whether limits may be set on the unit.
+func Enforce(unit string, username string) bool {
+	if Default == nil {
+		return true
+	}
+	return Default.decide(unit, username).Enforce
+}
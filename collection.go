@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/chpc-uofu/cgroup-warden/authorizer"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+)
+
+// startCollection sets up the collection of metrics and the authorizer of units
+// from the configuration, and starts following the journal if enabled. Both
+// read-only and enforcing builds collect.
+func startCollection(conf *Config) error {
+	var err error
+
+	metrics.UnitPatterns = conf.UnitPatterns
+	metrics.Formats = conf.ExpositionFormats
+
+	metrics.ErrorHandling, err = metrics.ParseErrorHandling(conf.ErrorHandling)
+	if err != nil {
+		return err
+	}
+	metrics.ContainerNames = conf.ContainerNames
+	metrics.ProcMemoryPSS = conf.ProcMemoryPSS
+	metrics.ProcTop = conf.ProcTop
+	metrics.ProcMinMemory = conf.ProcMinMemory
+	metrics.ProcMinCPU = conf.ProcMinCPU
+	metrics.ProcGroupBy = conf.ProcGroupBy
+	metrics.ProcScriptInterpreters = conf.ProcScripts
+
+	metrics.ProcApps, err = metrics.ParseProcApps(conf.ProcApps)
+	if err != nil {
+		return err
+	}
+
+	if conf.ProcInclude != "" {
+		metrics.ProcInclude = regexp.MustCompile(conf.ProcInclude)
+	}
+	if conf.ProcExclude != "" {
+		metrics.ProcExclude = regexp.MustCompile(conf.ProcExclude)
+	}
+	metrics.NvidiaSMI = conf.NvidiaSMI
+	metrics.Journal = conf.Journal
+
+	if conf.Slurm {
+		metrics.SlurmCGroup = conf.SlurmCGroup
+	}
+
+	if conf.Kubepods {
+		metrics.KubepodsCGroup = conf.KubepodsCGroup
+		if conf.KubeletURL != "" {
+			metrics.Kubelet = metrics.NewKubeletClient(conf.KubeletURL, conf.KubeletTokenFile, conf.KubeletInsecure)
+		}
+	}
+
+	if conf.BaselineWindow > 0 {
+		metrics.Baselines, err = metrics.NewBaselineTracker(conf.BaselineWindow, conf.BaselineWarmup, conf.BaselineFile)
+		if err != nil {
+			return fmt.Errorf("unable to load baselines: %w", err)
+		}
+	}
+
+	if conf.AuthorizerURL != "" {
+		a := &authorizer.HTTP{URL: conf.AuthorizerURL, Client: &http.Client{}}
+		authorizer.Default = authorizer.NewCache(a, conf.AuthorizerCacheTTL, conf.AuthorizerTimeout, conf.AuthorizerFailOpen)
+	}
+
+	if conf.AuthorizerCommand != "" {
+		a := &authorizer.Command{Path: conf.AuthorizerCommand}
+		authorizer.Default = authorizer.NewCache(a, conf.AuthorizerCacheTTL, conf.AuthorizerTimeout, conf.AuthorizerFailOpen)
+	}
+
+	metrics.Collection, err = metrics.NewCollectionConfig(conf.Collect, conf.CollectOverrides)
+	if err != nil {
+		return err
+	}
+
+	if conf.Journal {
+		go metrics.FollowJournal(context.Background())
+	}
+	return nil
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"fmt"
+	"net/netip"
+	"path"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/containerd/cgroups/v3"
 	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 type Config struct {
@@ -17,11 +24,111 @@ type Config struct {
 	PrivateKey    string  `env:"PRIVATE_KEY"`
 	BearerToken   string  `env:"BEARER_TOKEN"`
 	InsecureMode  bool    `env:"INSECURE_MODE" envDefault:"false"`
+	ReadOnly      bool    `env:"READ_ONLY" envDefault:"false"`
 	MetaMetrics   bool    `env:"META_METRICS" envDefault:"true"`
 	LogLevel      string  `env:"LOG_LEVEL" envDefault:"info"`
 	SwapRatio     float64 `env:"SWAP_RATIO" envDefault:"0.1"`
+	StateFile     string  `env:"STATE_FILE" envDefault:"/var/lib/cgroup-warden/desired-state.json"`
+
+	ClientCAFile string   `env:"CLIENT_CA_FILE"`
+	ClientNames  []string `env:"CLIENT_NAMES"`
+	TokenFile    string   `env:"TOKEN_FILE"`
+	MetricsAuth  bool     `env:"METRICS_AUTH" envDefault:"false"`
+
+	WebConfigFile string `env:"WEB_CONFIG_FILE"`
+
+	ControlListenAddress string `env:"CONTROL_LISTEN_ADDRESS"`
+	ControlRuntime       bool   `env:"CONTROL_RUNTIME" envDefault:"false"`
+
+	MetricsAllowedNetworks []netip.Prefix `env:"METRICS_ALLOWED_NETWORKS"`
+	ControlAllowedNetworks []netip.Prefix `env:"CONTROL_ALLOWED_NETWORKS"`
+
+	PProf bool `env:"PPROF" envDefault:"false"`
+
+	JWTKeyFile    string   `env:"JWT_KEY_FILE"`
+	JWKSURL       string   `env:"JWKS_URL"`
+	JWTAudience   string   `env:"JWT_AUDIENCE"`
+	JWTIssuer     string   `env:"JWT_ISSUER"`
+	JWTScopeClaim string   `env:"JWT_SCOPE_CLAIM" envDefault:"scope"`
+	JWTScopeMap   []string `env:"JWT_SCOPE_MAP"`
+
+	ExpositionFormats []string `env:"EXPOSITION_FORMATS" envDefault:"text,protobuf"`
+	ErrorHandling     string   `env:"ERROR_HANDLING" envDefault:"http"`
+
+	UnitPatterns     []string `env:"UNIT_PATTERNS" envDefault:"*"`
+	Collect          []string `env:"COLLECT" envDefault:"unit-props,cgroupfs-stats,proc-cpu,proc-memory"`
+	CollectOverrides string   `env:"COLLECT_OVERRIDES"`
+	ProcMemoryPSS    bool     `env:"PROC_MEMORY_PSS" envDefault:"true"`
+	ProcTop          int      `env:"PROC_TOP" envDefault:"0"`
+	ProcGroupBy      string   `env:"PROC_GROUP_BY" envDefault:"comm"`
+	ProcScripts      []string `env:"PROC_SCRIPTS"`
+	ProcApps         string   `env:"PROC_APPS"`
+	ProcInclude      string   `env:"PROC_INCLUDE"`
+	ProcExclude      string   `env:"PROC_EXCLUDE"`
+	ProcMinMemory    uint64   `env:"PROC_MIN_MEMORY" envDefault:"0"`
+	ProcMinCPU       float64  `env:"PROC_MIN_CPU" envDefault:"0"`
+	ContainerNames   bool     `env:"CONTAINER_NAMES" envDefault:"false"`
+	NvidiaSMI        string   `env:"NVIDIA_SMI" envDefault:"nvidia-smi"`
+	Journal          bool     `env:"JOURNAL" envDefault:"false"`
+	Slurm            bool     `env:"SLURM" envDefault:"false"`
+	SlurmCGroup      string   `env:"SLURM_CGROUP"`
+	Kubepods         bool     `env:"KUBEPODS" envDefault:"false"`
+	KubepodsCGroup   string   `env:"KUBEPODS_CGROUP" envDefault:"/kubepods.slice"`
+	KubeletURL       string   `env:"KUBELET_URL"`
+	KubeletTokenFile string   `env:"KUBELET_TOKEN_FILE"`
+	KubeletInsecure  bool     `env:"KUBELET_INSECURE" envDefault:"false"`
+
+	BaselineWindow time.Duration `env:"BASELINE_WINDOW" envDefault:"0"`
+	BaselineWarmup time.Duration `env:"BASELINE_WARMUP" envDefault:"24h"`
+	BaselineFile   string        `env:"BASELINE_FILE" envDefault:"/var/lib/cgroup-warden/baselines.json"`
+
+	EnableAccounting        []string `env:"ENABLE_ACCOUNTING"`
+	EnableAccountingRuntime bool     `env:"ENABLE_ACCOUNTING_RUNTIME" envDefault:"true"`
+
+	PolicyFile string `env:"POLICY_FILE"`
+	DryRun     bool   `env:"DRY_RUN" envDefault:"false"`
+
+	EnforcementFile string `env:"ENFORCEMENT_FILE" envDefault:"/var/lib/cgroup-warden/enforcement.json"`
+
+	RestoreAfter     time.Duration `env:"RESTORE_AFTER" envDefault:"0"`
+	RestoreThreshold float64       `env:"RESTORE_THRESHOLD" envDefault:"0.5"`
+
+	WebhookURL      string        `env:"WEBHOOK_URL"`
+	WebhookTemplate string        `env:"WEBHOOK_TEMPLATE"`
+	WebhookRetries  int           `env:"WEBHOOK_RETRIES" envDefault:"3"`
+	WebhookTimeout  time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+
+	EmailServer         string `env:"EMAIL_SERVER"`
+	EmailFrom           string `env:"EMAIL_FROM"`
+	EmailAddress        string `env:"EMAIL_ADDRESS"`
+	EmailAddressCommand string `env:"EMAIL_ADDRESS_COMMAND"`
+	EmailUsername       string `env:"EMAIL_USERNAME"`
+	EmailPasswordFile   string `env:"EMAIL_PASSWORD_FILE"`
+
+	ChatWebhookURL string `env:"CHAT_WEBHOOK_URL"`
+	ChatChannels   string `env:"CHAT_CHANNELS"`
+
+	TerminalMessages bool `env:"TERMINAL_MESSAGES" envDefault:"false"`
+
+	AuditFile    string `env:"AUDIT_FILE"`
+	AuditJournal bool   `env:"AUDIT_JOURNAL" envDefault:"false"`
+	AuditMaxSize uint64 `env:"AUDIT_MAX_SIZE" envDefault:"0"`
+	AuditKeep    int    `env:"AUDIT_KEEP" envDefault:"10"`
+
+	AuthorizerURL      string        `env:"AUTHORIZER_URL"`
+	AuthorizerCommand  string        `env:"AUTHORIZER_COMMAND"`
+	AuthorizerCacheTTL time.Duration `env:"AUTHORIZER_CACHE_TTL" envDefault:"5m"`
+	AuthorizerTimeout  time.Duration `env:"AUTHORIZER_TIMEOUT" envDefault:"5s"`
+	AuthorizerFailOpen bool          `env:"AUTHORIZER_FAIL_OPEN" envDefault:"true"`
+}
+
+// jwt reports whether requests may authenticate with JWTs
+func (c *Config) jwt() bool {
+	return c.JWTKeyFile != "" || c.JWKSURL != ""
 }
 
+const defaultStateFile = "/var/lib/cgroup-warden/desired-state.json"
+
 func NewConfig() (*Config, error) {
 	var c Config
 	var err error
@@ -36,7 +143,34 @@ func NewConfig() (*Config, error) {
 		return nil, fmt.Errorf("Invalid cgroup root: '%v'", c.RootCGroup)
 	}
 
-	if !c.InsecureMode {
+	if readOnlyBuild {
+		c.ReadOnly = true
+	}
+
+	if c.WebConfigFile != "" {
+		if c.Certificate != "" || c.ClientCAFile != "" {
+			return nil, fmt.Errorf("Certificates are given in the web config file instead of the environment")
+		}
+
+		err = web.Validate(c.WebConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid web config file: %v", err)
+		}
+
+		wc, err := readWebConfig(c.WebConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid web config file: %v", err)
+		}
+		if wc.TLS.CertFile == "" && !c.InsecureMode {
+			return nil, fmt.Errorf("TLS required in web config file if not running in insecure mode")
+		}
+
+		if c.BearerToken == "" && c.TokenFile == "" && !c.jwt() && len(wc.Users) == 0 && !c.InsecureMode && !c.ReadOnly {
+			return nil, fmt.Errorf("Bearer token, token file, JWT key or basic auth users required if not running in insecure mode")
+		}
+	}
+
+	if !c.InsecureMode && c.WebConfigFile == "" {
 
 		if c.Certificate == "" {
 			return nil, fmt.Errorf("Certificate required if not running in insecure mode")
@@ -46,11 +180,39 @@ func NewConfig() (*Config, error) {
 			return nil, fmt.Errorf("Private key required if not running insecure mode")
 		}
 
-		if c.BearerToken == "" {
-			return nil, fmt.Errorf("Bearer token required if not running in insecure mode")
+		if c.BearerToken == "" && c.TokenFile == "" && !c.jwt() && !c.ReadOnly {
+			return nil, fmt.Errorf("Bearer token, token file or JWT key required if not running in insecure mode")
 		}
 	}
 
+	if c.MetricsAuth && !c.InsecureMode && c.BearerToken == "" && c.TokenFile == "" && !c.jwt() {
+		return nil, fmt.Errorf("Bearer token, token file or JWT key required to authenticate metrics")
+	}
+
+	if c.PProf && c.BearerToken == "" && c.TokenFile == "" && !c.jwt() {
+		return nil, fmt.Errorf("Bearer token, token file or JWT key required to serve pprof")
+	}
+
+	if c.JWTKeyFile != "" && c.JWKSURL != "" {
+		return nil, fmt.Errorf("Only one of JWT key file and JWKS url may be set")
+	}
+
+	if c.jwt() && c.JWTAudience == "" {
+		return nil, fmt.Errorf("JWT audience required to verify JWTs")
+	}
+
+	if (c.Certificate == "") != (c.PrivateKey == "") {
+		return nil, fmt.Errorf("Certificate and private key must be given together")
+	}
+
+	if c.ClientCAFile == "" && len(c.ClientNames) > 0 {
+		return nil, fmt.Errorf("Client CA file required to allow client names")
+	}
+
+	if c.ClientCAFile != "" && c.Certificate == "" {
+		return nil, fmt.Errorf("Certificate required to verify client certificates")
+	}
+
 	levels := []string{"info", "warning", "debug", "error"}
 	c.LogLevel = strings.ToLower(c.LogLevel)
 
@@ -64,6 +226,70 @@ func NewConfig() (*Config, error) {
 
 	hierarchy.SwapRatio = c.SwapRatio
 
+	if c.RestoreAfter < 0 {
+		return nil, fmt.Errorf("Invalid restore period %v. Cannot be negative", c.RestoreAfter)
+	}
+
+	if c.RestoreThreshold <= 0 || c.RestoreThreshold > 1 {
+		return nil, fmt.Errorf("Invalid restore threshold %f. Expected a fraction between 0 and 1", c.RestoreThreshold)
+	}
+
+	for _, pattern := range c.UnitPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid unit pattern '%s': %v", pattern, err)
+		}
+	}
+
+	err = metrics.ValidateFormats(c.ExpositionFormats)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AuditKeep < 0 {
+		return nil, fmt.Errorf("Invalid number of audit files kept %d. Cannot be negative", c.AuditKeep)
+	}
+
+	if c.ProcTop < 0 {
+		return nil, fmt.Errorf("Invalid process limit %d. Cannot be negative", c.ProcTop)
+	}
+
+	if c.ProcMinCPU < 0 {
+		return nil, fmt.Errorf("Invalid process CPU threshold %f. Cannot be negative", c.ProcMinCPU)
+	}
+
+	err = metrics.ValidateProcGroupBy(c.ProcGroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range c.ProcScripts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid interpreter pattern '%s': %v", pattern, err)
+		}
+	}
+
+	if c.ProcInclude != "" {
+		if _, err := regexp.Compile(c.ProcInclude); err != nil {
+			return nil, fmt.Errorf("Invalid process include expression '%s': %v", c.ProcInclude, err)
+		}
+	}
+
+	if c.ProcExclude != "" {
+		if _, err := regexp.Compile(c.ProcExclude); err != nil {
+			return nil, fmt.Errorf("Invalid process exclude expression '%s': %v", c.ProcExclude, err)
+		}
+	}
+
+	if c.Slurm && c.SlurmCGroup == "" {
+		c.SlurmCGroup = "/slurm"
+		if cgroups.Mode() == cgroups.Unified {
+			c.SlurmCGroup = "/system.slice/slurmstepd.scope"
+		}
+	}
+
+	if c.AuthorizerURL != "" && c.AuthorizerCommand != "" {
+		return nil, fmt.Errorf("Only one of authorizer url and authorizer command may be set")
+	}
 
 	return &c, err
 }
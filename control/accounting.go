@@ -0,0 +1,109 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// AccountingProperties are the properties EnsureAccounting is able to enable
+var AccountingProperties = []string{CPUAccounting, MemoryAccounting, TasksAccounting, IOAccounting}
+
+// ValidateAccounting checks that every property is an accounting property
+func ValidateAccounting(properties []string) error {
+	for _, name := range properties {
+		if !slices.Contains(AccountingProperties, name) {
+			return fmt.Errorf("Invalid accounting property '%s'. Options include %v", name, AccountingProperties)
+		}
+	}
+	return nil
+}
+
+// EnsureAccounting enables the accounting properties on every unit directly
+// underneath root matching one of the patterns, repeating every interval until
+// the context is done. Units missing accounting export no data at all.
+func EnsureAccounting(ctx context.Context, root string, patterns []string, properties []string, runtime bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ensureAccounting(ctx, root, patterns, properties, runtime)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func ensureAccounting(ctx context.Context, root string, patterns []string, properties []string, runtime bool) {
+	h := hierarchy.NewHierarchy(root)
+	units, err := h.Children(root)
+	if err != nil {
+		slog.Warn("unable to list units", "root", root, "err", err)
+		status.Report(status.Remediation, root, err)
+		return
+	}
+
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		slog.Warn("unable to connect to systemd", "err", err.Error())
+		status.Report(status.Remediation, "", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, cg := range units {
+		unit := path.Base(cg)
+		if !matchesAny(patterns, unit) {
+			continue
+		}
+
+		var missing []systemd.Property
+		for _, name := range properties {
+			property, err := conn.GetUnitTypePropertyContext(ctx, unit, unitType(unit), name)
+			if err != nil {
+				slog.Debug("unable to get property", "err", err.Error(), "property", name, "unit", unit)
+				status.Report(status.Remediation, unit, err)
+				continue
+			}
+			if enabled, _ := property.Value.Value().(bool); !enabled {
+				missing = append(missing, systemd.Property{Name: name, Value: dbus.MakeVariant(true)})
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		err = conn.SetUnitPropertiesContext(ctx, unit, runtime, missing...)
+		for _, property := range missing {
+			audit.Record(ctx, audit.Entry{Action: "set", Unit: unit, Property: property.Name, Old: false, New: true}, err)
+		}
+		if err != nil {
+			slog.Warn("unable to enable accounting", "err", err.Error(), "unit", unit)
+			status.Report(status.Remediation, unit, err)
+			continue
+		}
+		slog.Info("enabled accounting", "unit", unit, "properties", len(missing), "runtime", runtime)
+	}
+}
+
+func matchesAny(patterns []string, unit string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, unit); ok {
+			return true
+		}
+	}
+	return false
+}
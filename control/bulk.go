@@ -0,0 +1,112 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+type bulkRequest struct {
+	Pattern    string            `json:"pattern"`
+	Properties []controlProperty `json:"properties"`
+	Runtime    bool              `json:"runtime"`
+}
+
+func (r *bulkRequest) UnmarshalJSON(data []byte) error {
+	type plain bulkRequest
+	request := plain{Runtime: DefaultRuntime}
+	err := json.Unmarshal(data, &request)
+	*r = bulkRequest(request)
+	return err
+}
+
+type bulkResponse struct {
+	Matched int               `json:"matched"`
+	Failed  int               `json:"failed"`
+	Results []controlResponse `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// BulkHandler applies a set of properties to every unit directly underneath the
+// root whose name matches a pattern like "user-*.slice", reporting the result of
+// each change. Unlike a transaction, changes that succeed are kept when others fail.
+func BulkHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		response := bulkResponse{Results: []controlResponse{}}
+		status := http.StatusOK
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request bulkRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+
+		if _, err = path.Match(request.Pattern, ""); err != nil || request.Pattern == "" {
+			err = fmt.Errorf("invalid pattern '%s'", request.Pattern)
+			status = http.StatusBadRequest
+			return
+		}
+
+		if len(request.Properties) == 0 {
+			err = errors.New("request contains no properties")
+			status = http.StatusBadRequest
+			return
+		}
+
+		// reject invalid values before changing any unit
+		for _, prop := range request.Properties {
+			if prop.Name == MemoryMax || prop.Name == MemorySwapMax {
+				continue
+			}
+			if _, err = transform(prop); err != nil {
+				status = http.StatusBadRequest
+				return
+			}
+		}
+
+		h := hierarchy.NewHierarchy(cgroupRoot)
+		units, err := h.Children(cgroupRoot)
+		if err != nil {
+			status = http.StatusInternalServerError
+			return
+		}
+
+		for _, cg := range units {
+			unit := path.Base(cg)
+			if ok, _ := path.Match(request.Pattern, unit); !ok {
+				continue
+			}
+			response.Matched++
+
+			for _, prop := range request.Properties {
+				result, _, applyErr := apply(r.Context(), controlRequest{Unit: unit, Property: prop, Runtime: request.Runtime}, cgroupRoot)
+				if applyErr != nil {
+					result.Error = applyErr.Error()
+					response.Failed++
+				}
+				response.Results = append(response.Results, result)
+			}
+		}
+
+		slog.Info("applied properties to units", "pattern", request.Pattern, "matched", response.Matched, "failed", response.Failed)
+	}
+}
@@ -6,9 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
+	"path"
+	"slices"
 
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/authorizer"
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
 	//"github.com/containerd/cgroups/v3"
 	systemd "github.com/coreos/go-systemd/v22/dbus"
 	dbus "github.com/godbus/dbus/v5"
@@ -18,12 +26,30 @@ import (
 var (
 	CPUAccounting      = "CPUAccounting"
 	CPUQuotaPerSecUSec = "CPUQuotaPerSecUSec"
+	CPUWeight          = "CPUWeight"
+	CPUShares          = "CPUShares"
 	MemoryAccounting   = "MemoryAccounting"
 	MemoryHigh         = "MemoryHigh"
 	MemoryMax          = "MemoryMax"
 	MemorySwapMax      = "MemorySwapMax"
 	MemoryLow          = "MemoryLow"
 	MemoryMin          = "MemoryMin"
+	TasksAccounting    = "TasksAccounting"
+	TasksMax           = "TasksMax"
+	IOAccounting       = "IOAccounting"
+
+	IOReadBandwidthMax  = "IOReadBandwidthMax"
+	IOWriteBandwidthMax = "IOWriteBandwidthMax"
+	IOReadIOPSMax       = "IOReadIOPSMax"
+	IOWriteIOPSMax      = "IOWriteIOPSMax"
+
+	AllowedCPUs        = "AllowedCPUs"
+	AllowedMemoryNodes = "AllowedMemoryNodes"
+
+	ManagedOOMSwap                = "ManagedOOMSwap"
+	ManagedOOMMemoryPressure      = "ManagedOOMMemoryPressure"
+	ManagedOOMMemoryPressureLimit = "ManagedOOMMemoryPressureLimit"
+	ManagedOOMPreference          = "ManagedOOMPreference"
 )
 
 type controlProperty struct {
@@ -37,6 +63,20 @@ type controlRequest struct {
 	Runtime  bool            `json:"runtime"`
 }
 
+// DefaultRuntime is whether requests that do not give the runtime flag change
+// properties at runtime, instead of persistently
+var DefaultRuntime = false
+
+// UnmarshalJSON decodes a control request, with the runtime flag defaulting to
+// DefaultRuntime.
+func (r *controlRequest) UnmarshalJSON(data []byte) error {
+	type plain controlRequest
+	request := plain{Runtime: DefaultRuntime}
+	err := json.Unmarshal(data, &request)
+	*r = controlRequest(request)
+	return err
+}
+
 type controlResponse struct {
 	Unit     string          `json:"unit"`
 	Property controlProperty `json:"property"`
@@ -70,35 +110,125 @@ func ControlHandler(cgroupRoot string) http.HandlerFunc {
 			return
 		}
 
-		response.Unit = request.Unit
-		response.Property = request.Property
-
 		slog.Debug("Decoded request", "unit", request.Unit, "property", request.Property.Name, "value", request.Property.Value)
-		
-		var newLimit int64
-		var fallback bool = false
 
-		if request.Property.Name == MemorySwapMax || request.Property.Name == MemoryMax {
-			newLimit, fallback, err = setCGroupMemoryLimits(request, cgroupRoot)
+		response, status, err = apply(r.Context(), request, cgroupRoot)
+	}
+}
 
-			if newLimit == hierarchy.MaxCGroupMemoryLimit{
-				response.Property.Value = -1
-			} else {
-				response.Property.Value = newLimit
-			}
+// apply sets the property of a single control request, returning the response
+// and the http status to report. The change is recorded in the audit log.
+func apply(ctx context.Context, request controlRequest, cgroupRoot string) (controlResponse, int, error) {
+	var err error
+	response := controlResponse{Unit: request.Unit, Property: request.Property}
+	entry := audit.Entry{Action: "set", Unit: request.Unit, Property: request.Property.Name, New: request.Property.Value}
 
-			if fallback {
-				response.Warning = fmt.Sprintf("unable to clamp memory limit down, defaulted to current usage %d", newLimit)
-			}
+	// single, bulk and transaction requests and resets all set properties here
+	err = validUnit(cgroupRoot, request.Unit)
+	if err != nil {
+		audit.Record(ctx, entry, err)
+		return response, http.StatusNotFound, err
+	}
+
+	err = authorize(request.Unit, cgroupRoot)
+	if err != nil {
+		audit.Record(ctx, entry, err)
+		return response, http.StatusForbidden, err
+	}
+
+	// remember the value before the first runtime change, so it can be reset
+	var original *controlProperty
+	track := request.Runtime && !changes.tracked(request.Unit, request.Property.Name)
+	if track || audit.Enabled() {
+		current, readErr := currentProperty(request, cgroupRoot)
+		if readErr != nil {
+			slog.Debug("unable to read property before changing it", "unit", request.Unit, "property", request.Property.Name, "err", readErr)
 		} else {
-			err = setSystemdProperty(request)
+			original = &current
+			entry.Old = current.Value
 		}
+	}
 
-		if err != nil {
-			status = http.StatusBadRequest
-			return
+	var newLimit int64
+	var fallback bool = false
+
+	if request.Property.Name == MemorySwapMax {
+		newLimit, err = setCGroupSwapLimit(request, cgroupRoot)
+
+		if newLimit == hierarchy.MaxCGroupMemoryLimit {
+			response.Property.Value = -1
+		} else {
+			response.Property.Value = newLimit
+		}
+	} else if request.Property.Name == MemoryMax {
+		newLimit, fallback, err = setCGroupMemoryLimits(request, cgroupRoot)
+
+		if newLimit == hierarchy.MaxCGroupMemoryLimit {
+			response.Property.Value = -1
+		} else {
+			response.Property.Value = newLimit
 		}
+
+		if fallback {
+			response.Warning = fmt.Sprintf("unable to clamp memory limit down, defaulted to current usage %d", newLimit)
+		}
+	} else {
+		err = setSystemdProperty(request)
+	}
+
+	// memory limits are written to the cgroup directly, which does not survive the
+	// unit being restarted, so persistent limits are also set in systemd
+	if err == nil && !request.Runtime && (request.Property.Name == MemoryMax || request.Property.Name == MemorySwapMax) {
+		persistErr := persistMemoryLimit(request.Unit, request.Property.Name, newLimit)
+		if persistErr != nil {
+			response.Warning = fmt.Sprintf("limit applied but not persisted: %v", persistErr)
+		}
+	}
+
+	// systemd may clamp the limit, so report the value applied
+	if err == nil && request.Property.Name == TasksMax {
+		applied, readErr := appliedTasksMax(request.Unit)
+		if readErr != nil {
+			response.Warning = fmt.Sprintf("unable to read back TasksMax: %v", readErr)
+		} else {
+			response.Property.Value = applied
+		}
+	}
+
+	if err == nil {
+		entry.New = response.Property.Value
+	}
+	audit.Record(ctx, entry, err)
+
+	if err != nil {
+		status.Report(status.Control, request.Unit, err)
+		return response, http.StatusBadRequest, err
 	}
+
+	if track && original != nil {
+		changes.record(request.Unit, *original)
+	}
+	return response, http.StatusOK, nil
+}
+
+// UnitPatterns restricts the units that can be changed to those whose name
+// matches one of these patterns, like the units that are monitored
+var UnitPatterns = []string{"*"}
+
+// validUnit checks that the unit is a unit directly underneath the root matching
+// the unit patterns
+func validUnit(cgroupRoot string, unit string) error {
+	return hierarchy.ValidUnit(hierarchy.NewHierarchy(cgroupRoot), cgroupRoot, unit, UnitPatterns)
+}
+
+// authorize checks with the authorizer that the unit may be enforced
+func authorize(unit string, cgroupRoot string) error {
+	username, _ := hierarchy.UnitUsername(path.Join(cgroupRoot, unit))
+	if !authorizer.Enforce(unit, username) {
+		slog.Info("enforcement not authorized", "unit", unit)
+		return fmt.Errorf("enforcement of unit %s not authorized", unit)
+	}
+	return nil
 }
 
 func setCGroupMemoryLimits(request controlRequest, cgroupRoot string) (int64, bool, error) {
@@ -120,6 +250,37 @@ func setCGroupMemoryLimits(request controlRequest, cgroupRoot string) (int64, bo
 	return newLimit, fallback, err
 }
 
+// setCGroupSwapLimit sets the swap limit of the unit, leaving its memory limit as is
+func setCGroupSwapLimit(request controlRequest, cgroupRoot string) (int64, error) {
+	val, ok := request.Property.Value.(float64)
+	if !ok {
+		return -1, errors.New("invalid type for property, expected float64")
+	}
+
+	value := int64(val)
+	if value == -1 || val >= hierarchy.MaxCGroupMemoryLimit {
+		value = hierarchy.MaxCGroupMemoryLimit
+	} else if value < 0 {
+		return -1, errors.New("invalid swap limit, expected a number of bytes")
+	}
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	return h.SetSwapLimit(request.Unit, value)
+}
+
+// persistMemoryLimit sets the memory limit applied to the cgroup as a persistent
+// property of the unit
+func persistMemoryLimit(unit string, name string, limit int64) error {
+	value := uint64(limit)
+	if limit == hierarchy.MaxCGroupMemoryLimit {
+		value = math.MaxUint64
+	}
+
+	return withSystemd(unit, func(ctx context.Context, conn *systemd.Conn) error {
+		return conn.SetUnitPropertiesContext(ctx, unit, false, systemd.Property{Name: name, Value: dbus.MakeVariant(value)})
+	})
+}
+
 func setSystemdProperty(request controlRequest) error {
 	property, err := transform(request.Property)
 	if err != nil {
@@ -143,24 +304,110 @@ func setSystemdProperty(request controlRequest) error {
 	return nil
 }
 
+// ValidateProperty checks that a property can be set to the value, given in the
+// same form as the value of a control request.
+func ValidateProperty(name string, value any) error {
+	_, err := transform(controlProperty{Name: name, Value: value})
+	return err
+}
+
+// SetUnitProperties sets properties of a unit through systemd outside of a control
+// request, like the defaults applied by the policy engine, with the values in the
+// same form as the values of control requests. Unlike a control request, memory
+// limits are not clamped to the current usage. Each change is recorded in the
+// audit log, attributed to the actor of the context.
+func SetUnitProperties(ctx context.Context, conn *systemd.Conn, cgroupRoot string, unit string, values map[string]any, runtime bool) error {
+	names := slices.Sorted(maps.Keys(values))
+	entries := make([]audit.Entry, 0, len(names))
+	for _, name := range names {
+		entry := audit.Entry{Action: "set", Unit: unit, Property: name, New: values[name]}
+		if audit.Enabled() {
+			entry.Old, _ = CurrentProperty(cgroupRoot, unit, name, values[name])
+		}
+		entries = append(entries, entry)
+	}
+	record := func(err error) {
+		for _, entry := range entries {
+			audit.Record(ctx, entry, err)
+		}
+	}
+
+	err := authorize(unit, cgroupRoot)
+	if err != nil {
+		record(err)
+		return err
+	}
+
+	properties := make([]systemd.Property, 0, len(values))
+	for _, name := range names {
+		property, err := transform(controlProperty{Name: name, Value: values[name]})
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %w", name, err)
+			record(err)
+			return err
+		}
+		properties = append(properties, property)
+	}
+
+	err = conn.SetUnitPropertiesContext(ctx, unit, runtime, properties...)
+	record(err)
+	return err
+}
+
 func transform(controlProp controlProperty) (systemd.Property, error) {
 	var property systemd.Property
 	property.Name = controlProp.Name
 	switch controlProp.Name {
-	case CPUAccounting, MemoryAccounting:
+	case CPUAccounting, MemoryAccounting, TasksAccounting, IOAccounting:
 		val, ok := controlProp.Value.(bool)
 		if !ok {
 			return property, errors.New("invalid type for property, expected bool")
 		}
 		property.Value = dbus.MakeVariant(val)
-	case CPUQuotaPerSecUSec, MemoryMax, MemoryHigh, MemoryMin, MemoryLow, MemorySwapMax:
-		val, ok := controlProp.Value.(float64) // json type
-		if !ok {
-			return property, errors.New("invalid type for property, expected float64")
+	case CPUQuotaPerSecUSec:
+		val, err := cpuQuota(controlProp.Value)
+		if err != nil {
+			return property, err
 		}
-
-		property.Value = dbus.MakeVariant(uint64(val))
-
+		property.Value = dbus.MakeVariant(val)
+	case CPUWeight, CPUShares:
+		val, err := cpuWeight(controlProp.Name, controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = dbus.MakeVariant(val)
+	case TasksMax:
+		return tasksMax(controlProp.Value)
+	case MemoryHigh, MemoryMin, MemoryLow, MemoryMax, MemorySwapMax:
+		val, err := memoryLimit(controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = dbus.MakeVariant(val)
+	case IOReadBandwidthMax, IOWriteBandwidthMax, IOReadIOPSMax, IOWriteIOPSMax:
+		val, err := ioLimits(controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = val
+	case AllowedCPUs, AllowedMemoryNodes:
+		val, err := cpuSet(controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = dbus.MakeVariant(val)
+	case ManagedOOMSwap, ManagedOOMMemoryPressure, ManagedOOMPreference:
+		val, err := managedOOM(controlProp.Name, controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = dbus.MakeVariant(val)
+	case ManagedOOMMemoryPressureLimit:
+		val, err := memoryPressureLimit(controlProp.Value)
+		if err != nil {
+			return property, err
+		}
+		property.Value = dbus.MakeVariant(val)
 	default:
 		msg := fmt.Sprintf("property not supported: %v", controlProp.Name)
 		return property, errors.New(msg)
@@ -169,3 +416,23 @@ func transform(controlProp controlProperty) (systemd.Property, error) {
 	return property, nil
 
 }
+
+// notifyAction records an enforcement action taken on a unit through the api in
+// the audit log, and notifies of it along with the processes of the unit before
+// the action if any
+func notifyAction(ctx context.Context, cgroupRoot string, unit string, action string, summary string, procs []notify.Process) {
+	audit.Record(ctx, audit.Entry{Action: action, Unit: unit, Summary: summary}, nil)
+
+	cg := path.Join(cgroupRoot, unit)
+	username, _ := hierarchy.UnitUsername(cg)
+	notify.Send(notify.Event{
+		Kind:      notify.Action,
+		Unit:      unit,
+		Cgroup:    cg,
+		Username:  username,
+		Severity:  "warning",
+		Summary:   summary,
+		Action:    action,
+		Processes: procs,
+	})
+}
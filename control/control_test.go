@@ -0,0 +1,39 @@
+package control
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequestRuntime(t *testing.T) {
+	tests := []struct {
+		body           string
+		defaultRuntime bool
+		want           bool
+	}{
+		{body: `{}`, want: false},
+		{body: `{}`, defaultRuntime: true, want: true},
+		{body: `{"runtime": true}`, want: true},
+		{body: `{"runtime": false}`, defaultRuntime: true, want: false},
+	}
+
+	defer func(d bool) { DefaultRuntime = d }(DefaultRuntime)
+	for _, test := range tests {
+		DefaultRuntime = test.defaultRuntime
+
+		var control controlRequest
+		var property propertyRequest
+		var bulk bulkRequest
+		for _, request := range []any{&control, &property, &bulk} {
+			if err := json.Unmarshal([]byte(test.body), request); err != nil {
+				t.Fatalf("json.Unmarshal(%s, %T): %v", test.body, request, err)
+			}
+		}
+
+		for name, got := range map[string]bool{"control": control.Runtime, "property": property.Runtime, "bulk": bulk.Runtime} {
+			if got != test.want {
+				t.Errorf("%s request %s with DefaultRuntime %v has runtime %v, expected %v", name, test.body, test.defaultRuntime, got, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// systemd does not accept quotas below 1% of a CPU
+const minCPUQuota = 10000
+
+// cpuQuota converts the value of a CPUQuotaPerSecUSec request into microseconds of
+// CPU time per second. The value is either the microseconds as a number, or a
+// percentage of a CPU like "150%", with -1 or "infinity" removing the quota. A
+// quota must be between 1% of a CPU and all CPUs of the node.
+func cpuQuota(value any) (uint64, error) {
+	var usec float64
+	switch v := value.(type) {
+	case float64: // json type
+		// the current value of a unit without a quota is read back as the maximum
+		if v == -1 || v >= math.MaxUint64 {
+			return math.MaxUint64, nil
+		}
+		usec = v
+	case string:
+		if v == "infinity" {
+			return math.MaxUint64, nil
+		}
+		percent, ok := strings.CutSuffix(v, "%")
+		if !ok {
+			return 0, fmt.Errorf("invalid CPU quota '%s', expected a percentage like 150%%", v)
+		}
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU quota '%s', expected a percentage like 150%%", v)
+		}
+		usec = p * 10000
+	default:
+		return 0, errors.New("invalid type for property, expected float64 or string")
+	}
+
+	maxQuota := float64(runtime.NumCPU()) * 1000000
+	if usec < minCPUQuota || usec > maxQuota {
+		return 0, fmt.Errorf("CPU quota of %.0f usec/sec is out of range, expected between %d and %.0f", usec, minCPUQuota, maxQuota)
+	}
+	return uint64(usec), nil
+}
+
+// ranges of the relative CPU weights accepted by systemd, CPUShares being the
+// equivalent of CPUWeight on the legacy hierarchy
+var cpuWeightRanges = map[string][2]uint64{
+	CPUWeight: {1, 10000},
+	CPUShares: {2, 262144},
+}
+
+// cpuWeight validates the value of a CPUWeight or CPUShares request, with -1
+// resetting the weight to the default.
+func cpuWeight(name string, value any) (uint64, error) {
+	v, ok := value.(float64) // json type
+	if !ok {
+		return 0, errors.New("invalid type for property, expected float64")
+	}
+
+	// the current value of a unit without a weight is read back as the maximum
+	if v == -1 || v >= math.MaxUint64 {
+		return math.MaxUint64, nil
+	}
+
+	r := cpuWeightRanges[name]
+	if v < float64(r[0]) || v > float64(r[1]) || v != math.Trunc(v) {
+		return 0, fmt.Errorf("%s of %v is out of range, expected an integer between %d and %d", name, v, r[0], r[1])
+	}
+	return uint64(v), nil
+}
@@ -0,0 +1,86 @@
+package control
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+)
+
+func TestCPUQuota(t *testing.T) {
+	maxQuota := float64(runtime.NumCPU()) * 1000000
+	tests := []struct {
+		value any
+		want  uint64
+		err   bool
+	}{
+		{value: float64(10000), want: 10000},
+		{value: float64(9999), err: true},
+		{value: float64(0), err: true},
+		{value: maxQuota, want: uint64(maxQuota)},
+		{value: maxQuota + 1, err: true},
+		{value: "1%", want: 10000},
+		{value: "0.5%", err: true},
+		{value: "100%", want: 1000000},
+		{value: "12.5%", want: 125000},
+		{value: fmt.Sprintf("%d%%", runtime.NumCPU()*100), want: uint64(maxQuota)},
+		{value: fmt.Sprintf("%d%%", runtime.NumCPU()*100+1), err: true},
+		{value: float64(-1), want: math.MaxUint64},
+		{value: float64(math.MaxUint64), want: math.MaxUint64},
+		{value: "infinity", want: math.MaxUint64},
+		{value: "150", err: true},
+		{value: "many%", err: true},
+		{value: "-50%", err: true},
+		{value: true, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := cpuQuota(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("cpuQuota(%#v) = %d, expected an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cpuQuota(%#v) = %d, %v, expected %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestCPUWeight(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  uint64
+		err   bool
+	}{
+		{name: CPUWeight, value: float64(-1), want: math.MaxUint64},
+		{name: CPUWeight, value: float64(math.MaxUint64), want: math.MaxUint64},
+		{name: CPUWeight, value: float64(1), want: 1},
+		{name: CPUWeight, value: float64(100), want: 100},
+		{name: CPUWeight, value: float64(10000), want: 10000},
+		{name: CPUWeight, value: float64(0), err: true},
+		{name: CPUWeight, value: float64(10001), err: true},
+		{name: CPUWeight, value: float64(1.5), err: true},
+		{name: CPUWeight, value: "100", err: true},
+		{name: CPUShares, value: float64(-1), want: math.MaxUint64},
+		{name: CPUShares, value: float64(2), want: 2},
+		{name: CPUShares, value: float64(262144), want: 262144},
+		{name: CPUShares, value: float64(1), err: true},
+		{name: CPUShares, value: float64(262145), err: true},
+	}
+
+	for _, test := range tests {
+		got, err := cpuWeight(test.name, test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("cpuWeight(%s, %#v) = %d, expected an error", test.name, test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cpuWeight(%s, %#v) = %d, %v, expected %d", test.name, test.value, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the largest CPU or NUMA node accepted in a list, as in systemd
+const maxCPUSetIndex = 8191
+
+// cpuSet converts the value of an AllowedCPUs or AllowedMemoryNodes request, a
+// list like "0-3,8" as used by cpuset.cpus, into the bitmask expected by systemd,
+// where bit i of byte i/8 is set for CPU or node i. An empty list removes the
+// restriction.
+func cpuSet(value any) ([]byte, error) {
+	list, ok := value.(string)
+	if !ok {
+		return nil, errors.New("invalid type for property, expected string")
+	}
+
+	mask := []byte{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		low, high, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list '%s'", list)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(high)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list '%s'", list)
+			}
+		}
+
+		if first < 0 || last < first || last > maxCPUSetIndex {
+			return nil, fmt.Errorf("invalid range '%s' in cpu list '%s'", part, list)
+		}
+
+		for i := first; i <= last; i++ {
+			for len(mask) <= i/8 {
+				mask = append(mask, 0)
+			}
+			mask[i/8] |= 1 << (i % 8)
+		}
+	}
+	return mask, nil
+}
+
+// cpuList converts a bitmask read from systemd back into a list like "0-3,8"
+func cpuList(mask []byte) string {
+	var parts []string
+	for i := 0; i < len(mask)*8; i++ {
+		if mask[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+
+		first := i
+		for i+1 < len(mask)*8 && mask[(i+1)/8]&(1<<((i+1)%8)) != 0 {
+			i++
+		}
+
+		if first == i {
+			parts = append(parts, strconv.Itoa(first))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", first, i))
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,75 @@
+package control
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCPUSet(t *testing.T) {
+	tests := []struct {
+		value any
+		want  []byte
+		err   bool
+	}{
+		{value: "", want: []byte{}},
+		{value: " , ", want: []byte{}},
+		{value: "0", want: []byte{0x01}},
+		{value: "0-3,8", want: []byte{0x0f, 0x01}},
+		{value: "8, 0-3", want: []byte{0x0f, 0x01}},
+		{value: "2-2", want: []byte{0x04}},
+		{value: "0-3,1,2-5", want: []byte{0x3f}},
+		{value: "1,1", want: []byte{0x02}},
+		{value: "15", want: []byte{0x00, 0x80}},
+		{value: "8191", want: append(make([]byte, 1023), 0x80)},
+		{value: "8192", err: true},
+		{value: "3-1", err: true},
+		{value: "-1", err: true},
+		{value: "1-", err: true},
+		{value: "1-2-3", err: true},
+		{value: "a", err: true},
+		{value: "0-3;8", err: true},
+		{value: "1 - 2", err: true},
+		{value: float64(3), err: true},
+	}
+
+	for _, test := range tests {
+		got, err := cpuSet(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("cpuSet(%#v) = %v, expected an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("cpuSet(%#v) = %v, %v, expected %v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestCPUList(t *testing.T) {
+	tests := []struct {
+		mask []byte
+		want string
+	}{
+		{mask: nil, want: ""},
+		{mask: []byte{0x00}, want: ""},
+		{mask: []byte{0x01}, want: "0"},
+		{mask: []byte{0x0f, 0x01}, want: "0-3,8"},
+		{mask: []byte{0x80, 0x01}, want: "7-8"},
+		{mask: []byte{0xff, 0xff}, want: "0-15"},
+		{mask: []byte{0x55}, want: "0,2,4,6"},
+	}
+
+	for _, test := range tests {
+		got := cpuList(test.mask)
+		if got != test.want {
+			t.Errorf("cpuList(%v) = %q, expected %q", test.mask, got, test.want)
+		}
+
+		// lists read back from systemd convert into the masks they were read from
+		mask, err := cpuSet(got)
+		if err != nil || cpuList(mask) != got {
+			t.Errorf("cpuSet(%q) = %v, %v, does not convert back into the list", got, mask, err)
+		}
+	}
+}
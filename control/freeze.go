@@ -0,0 +1,217 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+type freezeRequest struct {
+	Duration string `json:"duration"`
+}
+
+type freezeResponse struct {
+	Unit   string     `json:"unit"`
+	State  string     `json:"state,omitempty"`
+	ThawAt *time.Time `json:"thawAt,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// thaw is a pending automatic thaw of a unit
+type thaw struct {
+	timer *time.Timer
+	at    time.Time
+}
+
+// thawTimers holds the pending automatic thaws by unit
+type thawTimers struct {
+	data  map[string]thaw
+	mutex sync.Mutex
+}
+
+var thaws = &thawTimers{data: make(map[string]thaw)}
+
+// schedule thaws the unit after the duration, replacing any thaw already pending
+func (tt *thawTimers) schedule(unit string, duration time.Duration) {
+	defer tt.mutex.Unlock()
+	tt.mutex.Lock()
+
+	if t, ok := tt.data[unit]; ok {
+		t.timer.Stop()
+	}
+	timer := time.AfterFunc(duration, func() {
+		tt.cancel(unit)
+		slog.Info("thawing unit after freeze duration", "unit", unit, "duration", duration)
+		err := thawUnit(unit)
+		audit.Record(context.Background(), audit.Entry{Action: "thaw", Unit: unit, Summary: fmt.Sprintf("thawed %s after %s", unit, duration)}, err)
+	})
+	tt.data[unit] = thaw{timer: timer, at: time.Now().Add(duration)}
+}
+
+func (tt *thawTimers) cancel(unit string) {
+	defer tt.mutex.Unlock()
+	tt.mutex.Lock()
+
+	if t, ok := tt.data[unit]; ok {
+		t.timer.Stop()
+		delete(tt.data, unit)
+	}
+}
+
+// FreezeHandler freezes the unit named in the path, pausing its processes without
+// killing them. If a duration like "30m" is given, the unit is thawed after it.
+func FreezeHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := freezeResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: "freeze", Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request freezeRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+		err = nil
+
+		var duration time.Duration
+		if request.Duration != "" {
+			duration, err = time.ParseDuration(request.Duration)
+			if err != nil || duration <= 0 {
+				err = errors.New("invalid duration, expected a positive duration like 30m")
+				status = http.StatusBadRequest
+				return
+			}
+		}
+
+		err = validUnit(cgroupRoot, unit)
+		if err != nil {
+			status = http.StatusNotFound
+			return
+		}
+
+		err = authorize(unit, cgroupRoot)
+		if err != nil {
+			status = http.StatusForbidden
+			return
+		}
+
+		err = freezeUnit(unit)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		response.State = "frozen"
+
+		if duration > 0 {
+			thaws.schedule(unit, duration)
+			thawAt := time.Now().Add(duration)
+			response.ThawAt = &thawAt
+			notifyAction(r.Context(), cgroupRoot, unit, "freeze", fmt.Sprintf("froze %s for %s", unit, duration), notify.UnitProcesses(cgroupRoot, unit))
+		} else {
+			thaws.cancel(unit)
+			notifyAction(r.Context(), cgroupRoot, unit, "freeze", fmt.Sprintf("froze %s", unit), notify.UnitProcesses(cgroupRoot, unit))
+		}
+	}
+}
+
+// ThawHandler thaws the unit named in the path, cancelling any pending automatic thaw.
+func ThawHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := freezeResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: "thaw", Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		err = validUnit(cgroupRoot, unit)
+		if err != nil {
+			status = http.StatusNotFound
+			return
+		}
+
+		err = authorize(unit, cgroupRoot)
+		if err != nil {
+			status = http.StatusForbidden
+			return
+		}
+
+		err = thawUnit(unit)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		audit.Record(r.Context(), entry, nil)
+		thaws.cancel(unit)
+		response.State = "thawed"
+	}
+}
+
+func freezeUnit(unit string) error {
+	return withSystemd(unit, func(ctx context.Context, conn *systemd.Conn) error {
+		return conn.FreezeUnit(ctx, unit)
+	})
+}
+
+func thawUnit(unit string) error {
+	return withSystemd(unit, func(ctx context.Context, conn *systemd.Conn) error {
+		return conn.ThawUnit(ctx, unit)
+	})
+}
+
+// withSystemd calls fn with a connection to systemd, reporting any error against the unit
+func withSystemd(unit string, fn func(ctx context.Context, conn *systemd.Conn) error) error {
+	ctx := context.Background()
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		slog.Warn("unable to connect to systemd", "err", err.Error())
+		status.Report(status.Control, unit, err)
+		return err
+	}
+	defer conn.Close()
+
+	err = fn(ctx, conn)
+	if err != nil {
+		slog.Warn("unable to control unit", "err", err.Error(), "unit", unit)
+		status.Report(status.Control, unit, err)
+	}
+	return err
+}
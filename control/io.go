@@ -0,0 +1,110 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// deviceLimit is the limit of one device in IO bandwidth and IOPS properties.
+// systemd keeps a limit per device, so a request only changes the devices it names.
+type deviceLimit struct {
+	Device string
+	Limit  uint64
+}
+
+// ioLimits converts the value of an IOReadBandwidthMax, IOWriteBandwidthMax,
+// IOReadIOPSMax or IOWriteIOPSMax request, either a single object like
+// {"device": "/dev/sda", "limit": 1048576} or a list of them, into the a(st) form
+// expected by systemd. A limit of -1 or "infinity" removes the limit of the device.
+func ioLimits(value any) (dbus.Variant, error) {
+	var entries []any
+	switch v := value.(type) {
+	case map[string]any:
+		entries = []any{v}
+	case []any:
+		entries = v
+	default:
+		return dbus.Variant{}, errors.New("invalid type for property, expected an object or a list of objects with a device and limit")
+	}
+
+	limits := make([]deviceLimit, 0, len(entries))
+
+	for _, e := range entries {
+		entry, ok := e.(map[string]any)
+		if !ok {
+			return dbus.Variant{}, errors.New("invalid device limit, expected an object with a device and limit")
+		}
+
+		device, ok := entry["device"].(string)
+		if !ok || !path.IsAbs(device) || path.Clean(device) != device {
+			return dbus.Variant{}, fmt.Errorf("invalid device %v, expected a path like /dev/sda", entry["device"])
+		}
+
+		limit, err := ioLimit(entry["limit"])
+		if err != nil {
+			return dbus.Variant{}, fmt.Errorf("invalid limit for device %s: %w", device, err)
+		}
+
+		limits = append(limits, deviceLimit{Device: device, Limit: limit})
+	}
+
+	return dbus.MakeVariant(limits), nil
+}
+
+func ioLimit(value any) (uint64, error) {
+	switch v := value.(type) {
+	case float64: // json type
+		if v == -1 || v >= math.MaxUint64 {
+			return math.MaxUint64, nil
+		}
+		if v <= 0 || v != math.Trunc(v) {
+			return 0, errors.New("expected a positive integer")
+		}
+		return uint64(v), nil
+	case string:
+		if v == "infinity" {
+			return math.MaxUint64, nil
+		}
+	}
+	return 0, errors.New("expected a positive integer or infinity")
+}
+
+// currentIOLimits converts the a(st) value of an IO limit read from systemd into
+// the form of the value of a request. Devices named in the request without a
+// limit are included as unlimited, so that applying the value reverts the request.
+func currentIOLimits(raw [][]any, requested any) []any {
+	limits := make([]any, 0, len(raw))
+	seen := make(map[string]bool)
+	for _, entry := range raw {
+		if len(entry) != 2 {
+			continue
+		}
+		device, _ := entry[0].(string)
+		limit, _ := entry[1].(uint64)
+		seen[device] = true
+
+		value := float64(limit)
+		if limit == math.MaxUint64 {
+			value = -1
+		}
+		limits = append(limits, map[string]any{"device": device, "limit": value})
+	}
+
+	entries, ok := requested.([]any)
+	if !ok {
+		entries = []any{requested}
+	}
+	for _, e := range entries {
+		entry, _ := e.(map[string]any)
+		device, ok := entry["device"].(string)
+		if ok && !seen[device] {
+			seen[device] = true
+			limits = append(limits, map[string]any{"device": device, "limit": float64(-1)})
+		}
+	}
+	return limits
+}
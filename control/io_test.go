@@ -0,0 +1,84 @@
+package control
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestIOLimits(t *testing.T) {
+	sda := func(limit any) map[string]any {
+		return map[string]any{"device": "/dev/sda", "limit": limit}
+	}
+
+	tests := []struct {
+		value any
+		want  []deviceLimit
+		err   bool
+	}{
+		{value: sda(float64(1048576)), want: []deviceLimit{{"/dev/sda", 1048576}}},
+		{value: sda(float64(-1)), want: []deviceLimit{{"/dev/sda", math.MaxUint64}}},
+		{value: sda("infinity"), want: []deviceLimit{{"/dev/sda", math.MaxUint64}}},
+		{
+			value: []any{sda(float64(100)), map[string]any{"device": "/dev/nvme0n1", "limit": float64(200)}},
+			want:  []deviceLimit{{"/dev/sda", 100}, {"/dev/nvme0n1", 200}},
+		},
+		{value: map[string]any{"device": "/scratch", "limit": float64(100)}, want: []deviceLimit{{"/scratch", 100}}},
+		{value: []any{}, want: []deviceLimit{}},
+		{value: sda(float64(0)), err: true},
+		{value: sda(float64(-2)), err: true},
+		{value: sda(float64(1.5)), err: true},
+		{value: sda("1048576"), err: true},
+		{value: sda(nil), err: true},
+		{value: map[string]any{"device": "sda", "limit": float64(100)}, err: true},
+		{value: map[string]any{"device": "/dev/../etc/passwd", "limit": float64(100)}, err: true},
+		{value: map[string]any{"device": "/dev/sda/", "limit": float64(100)}, err: true},
+		{value: map[string]any{"device": "", "limit": float64(100)}, err: true},
+		{value: map[string]any{"limit": float64(100)}, err: true},
+		{value: []any{sda(float64(100)), "/dev/sdb"}, err: true},
+		{value: float64(100), err: true},
+		{value: "/dev/sda 100", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := ioLimits(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ioLimits(%#v) = %v, expected an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got.Value(), test.want) {
+			t.Errorf("ioLimits(%#v) = %v, %v, expected %v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestCurrentIOLimits(t *testing.T) {
+	raw := [][]any{{"/dev/sda", uint64(1048576)}, {"/dev/sdb", uint64(math.MaxUint64)}}
+	requested := []any{
+		map[string]any{"device": "/dev/sda", "limit": float64(100)},
+		map[string]any{"device": "/dev/sdc", "limit": float64(100)},
+	}
+
+	want := []any{
+		map[string]any{"device": "/dev/sda", "limit": float64(1048576)},
+		map[string]any{"device": "/dev/sdb", "limit": float64(-1)},
+		map[string]any{"device": "/dev/sdc", "limit": float64(-1)},
+	}
+	got := currentIOLimits(raw, requested)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("currentIOLimits = %v, expected %v", got, want)
+	}
+
+	// the current limits convert back into the limits they were read from, with
+	// the devices that had no limit left unlimited
+	limits, err := ioLimits(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLimits := []deviceLimit{{"/dev/sda", 1048576}, {"/dev/sdb", math.MaxUint64}, {"/dev/sdc", math.MaxUint64}}
+	if !reflect.DeepEqual(limits.Value(), wantLimits) {
+		t.Errorf("ioLimits(currentIOLimits) = %v, expected %v", limits, wantLimits)
+	}
+}
@@ -0,0 +1,276 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// signals that can be sent by name
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGSTOP": syscall.SIGSTOP,
+	"SIGXCPU": syscall.SIGXCPU,
+}
+
+// parseSignal converts a signal given by name like "SIGTERM" or "TERM", or by
+// number, defaulting to SIGTERM if none is given.
+func parseSignal(value any) (syscall.Signal, error) {
+	switch v := value.(type) {
+	case nil:
+		return syscall.SIGTERM, nil
+	case float64: // json type
+		if v < 1 || v > 64 || v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid signal %v", v)
+		}
+		return syscall.Signal(int(v)), nil
+	case string:
+		name := strings.ToUpper(v)
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		if signal, ok := signals[name]; ok {
+			return signal, nil
+		}
+		return 0, fmt.Errorf("invalid signal '%s'", v)
+	}
+	return 0, errors.New("invalid type for signal, expected a name or number")
+}
+
+type killRequest struct {
+	Signal any    `json:"signal"`
+	Who    string `json:"who"`
+}
+
+type killResponse struct {
+	Unit   string `json:"unit"`
+	Signal string `json:"signal,omitempty"`
+	Who    string `json:"who,omitempty"`
+	Method string `json:"method,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// KillHandler sends a signal to the processes of the unit named in the path, to all
+// of them by default, or to its main or control process. SIGKILL to all of them
+// goes through cgroup.kill where the kernel supports it, which cannot race with
+// processes forking, and through systemd otherwise.
+func KillHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := killResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: "kill", Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request killRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+		err = nil
+
+		signal, err := parseSignal(request.Signal)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		entry.New = signal.String()
+
+		who := systemd.Who(request.Who)
+		switch who {
+		case "":
+			who = systemd.All
+		case systemd.All, systemd.Main, systemd.Control:
+		default:
+			err = fmt.Errorf("invalid target '%s', expected all, main or control", request.Who)
+			status = http.StatusBadRequest
+			return
+		}
+
+		err = validUnit(cgroupRoot, unit)
+		if err != nil {
+			status = http.StatusNotFound
+			return
+		}
+
+		err = authorize(unit, cgroupRoot)
+		if err != nil {
+			status = http.StatusForbidden
+			return
+		}
+
+		// the processes are gone once the signal is delivered
+		procs := notify.UnitProcesses(cgroupRoot, unit)
+
+		slog.Info("signalling unit", "unit", unit, "signal", signal.String(), "who", who, "remote", r.RemoteAddr)
+		response.Method, err = killUnit(cgroupRoot, unit, who, signal)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+
+		response.Signal = signal.String()
+		response.Who = string(who)
+		notifyAction(r.Context(), cgroupRoot, unit, "kill", fmt.Sprintf("sent %s to %s processes of %s", signal, who, unit), procs)
+	}
+}
+
+// killUnit sends the signal to the processes of the unit, returning whether it
+// went through cgroup.kill or systemd
+func killUnit(cgroupRoot string, unit string, who systemd.Who, signal syscall.Signal) (string, error) {
+	if who == systemd.All && signal == syscall.SIGKILL {
+		err := hierarchy.NewHierarchy(cgroupRoot).Kill(unit)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return "cgroup.kill", err
+		}
+		slog.Debug("falling back to killing unit through systemd", "unit", unit, "err", err)
+	}
+
+	err := withSystemd(unit, func(ctx context.Context, conn *systemd.Conn) error {
+		return conn.KillUnitWithTarget(ctx, unit, who, int32(signal))
+	})
+	return "systemd", err
+}
+
+type signalRequest struct {
+	PID    int `json:"pid"`
+	Signal any `json:"signal"`
+}
+
+type signalResponse struct {
+	Unit   string `json:"unit"`
+	PID    int    `json:"pid"`
+	Signal string `json:"signal,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SignalHandler sends a signal to a single process of the unit named in the path,
+// after checking the process belongs to the unit.
+func SignalHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := signalResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: "signal", Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request signalRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+		response.PID = request.PID
+
+		if request.PID <= 1 {
+			err = errors.New("invalid pid")
+			status = http.StatusBadRequest
+			return
+		}
+
+		signal, err := parseSignal(request.Signal)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		entry.New = signal.String()
+
+		status, err = signalProcess(r.Context(), cgroupRoot, unit, request.PID, signal, r.RemoteAddr)
+		if err != nil {
+			return
+		}
+
+		response.Signal = signal.String()
+	}
+}
+
+// signalProcess sends a signal to a process after checking it belongs to the unit,
+// returning the http status to report.
+func signalProcess(ctx context.Context, cgroupRoot string, unit string, pid int, signal syscall.Signal, remote string) (int, error) {
+	err := validUnit(cgroupRoot, unit)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	err = authorize(unit, cgroupRoot)
+	if err != nil {
+		return http.StatusForbidden, err
+	}
+
+	// the process is found before checking it belongs to the unit, so that the
+	// signal cannot reach another process reusing its pid in between
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+	defer proc.Release()
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	pids, err := h.Procs(path.Join(cgroupRoot, unit))
+	if err != nil {
+		status.Report(status.Control, unit, err)
+		return http.StatusBadRequest, err
+	}
+	if !slices.Contains(pids, uint64(pid)) {
+		return http.StatusForbidden, fmt.Errorf("process %d does not belong to unit %s", pid, unit)
+	}
+
+	procs := notify.UnitProcesses(cgroupRoot, unit)
+	slog.Info("signalling process", "unit", unit, "pid", pid, "signal", signal.String(), "remote", remote)
+	err = proc.Signal(signal)
+	if err != nil {
+		status.Report(status.Control, unit, err)
+		return http.StatusBadRequest, err
+	}
+	notifyAction(ctx, cgroupRoot, unit, "signal", fmt.Sprintf("sent %s to process %d of %s", signal, pid, unit), procs)
+	return http.StatusOK, nil
+}
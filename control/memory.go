@@ -0,0 +1,28 @@
+package control
+
+import (
+	"errors"
+	"math"
+)
+
+// memoryLimit converts the value of a memory limit or protection like MemoryHigh
+// or MemoryLow, either a number of bytes, or -1 or "infinity" for no limit.
+// Protections are removed by setting them to 0.
+func memoryLimit(value any) (uint64, error) {
+	switch v := value.(type) {
+	case float64: // json type
+		if v == -1 || v >= math.MaxUint64 {
+			return math.MaxUint64, nil
+		}
+		if v < 0 || v != math.Trunc(v) {
+			return 0, errors.New("invalid memory limit, expected a number of bytes")
+		}
+		return uint64(v), nil
+	case string:
+		if v == "infinity" {
+			return math.MaxUint64, nil
+		}
+		return 0, errors.New("invalid memory limit, expected a number of bytes or infinity")
+	}
+	return 0, errors.New("invalid type for property, expected float64 or string")
+}
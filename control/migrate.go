@@ -0,0 +1,134 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/state"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// properties imported by Migrate, all of which can be set through the control endpoint
+var migratedProperties = []string{
+	CPUAccounting, CPUQuotaPerSecUSec, CPUWeight, CPUShares, MemoryAccounting,
+	MemoryHigh, MemoryMax, MemorySwapMax, MemoryLow, MemoryMin,
+	TasksMax,
+}
+
+type MigratedProperty struct {
+	Unit     string
+	Property string
+	Value    any
+	Source   string
+}
+
+type MigrationConflict struct {
+	Unit     string
+	Property string
+	Current  any
+	Desired  any
+}
+
+type MigrationReport struct {
+	Imported  []MigratedProperty
+	Conflicts []MigrationConflict
+}
+
+// Migrate reads the properties set on the units underneath cgroupRoot, with
+// systemctl set-property or in unit files, and imports those not at their
+// default into the desired state. Properties already in the desired state with
+// a different value are reported as conflicts, and only overwritten if force is set.
+func Migrate(ctx context.Context, cgroupRoot string, pattern string, desired *state.DesiredState, force bool) (MigrationReport, error) {
+	var report MigrationReport
+
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	groups, err := h.Children(cgroupRoot)
+	if err != nil {
+		return report, err
+	}
+
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return report, fmt.Errorf("unable to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	for _, cg := range groups {
+		unit := path.Base(cg)
+		if ok, _ := path.Match(pattern, unit); !ok {
+			continue
+		}
+
+		props, err := conn.GetUnitTypePropertiesContext(ctx, unit, unitType(unit))
+		if err != nil {
+			return report, fmt.Errorf("unable to get properties of %s: %w", unit, err)
+		}
+
+		var dropIns []string
+		if p, err := conn.GetUnitPropertyContext(ctx, unit, "DropInPaths"); err == nil {
+			dropIns, _ = p.Value.Value().([]string)
+		}
+
+		for _, name := range migratedProperties {
+			value, ok := migratedValue(name, props[name])
+			if !ok {
+				continue
+			}
+
+			source := propertySource(name, dropIns)
+			current := state.Property{Value: value, Runtime: source == "runtime", Source: source}
+
+			if existing, ok := desired.Get(unit, name); ok && fmt.Sprint(existing.Value) != fmt.Sprint(value) {
+				report.Conflicts = append(report.Conflicts, MigrationConflict{Unit: unit, Property: name, Current: value, Desired: existing.Value})
+				if !force {
+					continue
+				}
+			}
+
+			desired.Set(unit, name, current)
+			report.Imported = append(report.Imported, MigratedProperty{Unit: unit, Property: name, Value: value, Source: source})
+		}
+	}
+
+	return report, nil
+}
+
+// migratedValue converts a dbus property into the form of a control request value,
+// returning false if the property is at its default.
+func migratedValue(name string, raw any) (any, bool) {
+	switch v := raw.(type) {
+	case bool:
+		// accounting is enabled by default on current versions of systemd
+		return v, !v
+	case uint64:
+		switch name {
+		case MemoryLow, MemoryMin:
+			return float64(v), v != 0
+		default:
+			return float64(v), v != math.MaxUint64
+		}
+	}
+	return nil, false
+}
+
+// propertySource determines where a property was set from the drop-ins of the
+// unit; systemctl set-property writes a drop-in named after the property, like
+// 50-MemoryMax.conf, into /run for runtime changes and /etc for persistent ones.
+func propertySource(name string, dropIns []string) string {
+	for _, file := range dropIns {
+		if !strings.HasSuffix(path.Base(file), "-"+name+".conf") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(file, "/run/systemd/system.control"):
+			return "runtime"
+		case strings.HasPrefix(file, "/etc/systemd/system.control"):
+			return "persistent"
+		}
+	}
+	return "unit-file"
+}
@@ -0,0 +1,60 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// values accepted by the ManagedOOM properties, which hand the unit to
+// systemd-oomd
+var managedOOMValues = map[string][]string{
+	ManagedOOMSwap:           {"auto", "kill"},
+	ManagedOOMMemoryPressure: {"auto", "kill"},
+	ManagedOOMPreference:     {"none", "avoid", "omit"},
+}
+
+// managedOOM validates the value of a ManagedOOMSwap, ManagedOOMMemoryPressure or
+// ManagedOOMPreference request. With "kill", systemd-oomd kills the cgroups
+// underneath the unit using the most swap or under the most memory pressure.
+func managedOOM(name string, value any) (string, error) {
+	v, ok := value.(string)
+	if !ok {
+		return "", errors.New("invalid type for property, expected string")
+	}
+	if !slices.Contains(managedOOMValues[name], v) {
+		return "", fmt.Errorf("invalid %s '%s', expected one of %s", name, v, strings.Join(managedOOMValues[name], ", "))
+	}
+	return v, nil
+}
+
+// memoryPressureLimit converts the value of a ManagedOOMMemoryPressureLimit
+// request, a percentage like "60%" with up to two decimals, into the fraction of
+// the maximum uint32 systemd expects. A limit of "0%" uses the default limit of
+// systemd-oomd.
+func memoryPressureLimit(value any) (uint32, error) {
+	v, ok := value.(string)
+	if !ok {
+		return 0, errors.New("invalid type for property, expected a percentage like 60%")
+	}
+	percent, ok := strings.CutSuffix(v, "%")
+	if !ok {
+		return 0, fmt.Errorf("invalid memory pressure limit '%s', expected a percentage like 60%%", v)
+	}
+	p, err := strconv.ParseFloat(percent, 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("invalid memory pressure limit '%s', expected a percentage from 0 to 100", v)
+	}
+	permyriad := math.Round(p * 100)
+	return uint32(math.Round(permyriad * math.MaxUint32 / 10000)), nil
+}
+
+// currentMemoryPressureLimit converts a limit read from systemd back into the
+// percentage of a request
+func currentMemoryPressureLimit(limit uint32) string {
+	permyriad := math.Round(float64(limit) * 10000 / math.MaxUint32)
+	return strconv.FormatFloat(permyriad/100, 'f', -1, 64) + "%"
+}
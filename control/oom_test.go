@@ -0,0 +1,102 @@
+package control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestManagedOOM(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		err   bool
+	}{
+		{name: ManagedOOMSwap, value: "auto"},
+		{name: ManagedOOMSwap, value: "kill"},
+		{name: ManagedOOMSwap, value: "avoid", err: true},
+		{name: ManagedOOMMemoryPressure, value: "auto"},
+		{name: ManagedOOMMemoryPressure, value: "kill"},
+		{name: ManagedOOMMemoryPressure, value: "Kill", err: true},
+		{name: ManagedOOMMemoryPressure, value: "", err: true},
+		{name: ManagedOOMPreference, value: "none"},
+		{name: ManagedOOMPreference, value: "avoid"},
+		{name: ManagedOOMPreference, value: "omit"},
+		{name: ManagedOOMPreference, value: "kill", err: true},
+		{name: ManagedOOMSwap, value: true, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := managedOOM(test.name, test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("managedOOM(%s, %#v) = %q, expected an error", test.name, test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.value {
+			t.Errorf("managedOOM(%s, %#v) = %q, %v, expected %q", test.name, test.value, got, err, test.value)
+		}
+	}
+}
+
+func TestMemoryPressureLimit(t *testing.T) {
+	tests := []struct {
+		value any
+		want  uint32
+		err   bool
+	}{
+		{value: "0%", want: 0},
+		{value: "0.01%", want: 429497},
+		{value: "25.5%", want: 1095216660},
+		{value: "60%", want: 2576980377},
+		{value: "100%", want: math.MaxUint32},
+		{value: "100.00%", want: math.MaxUint32},
+		{value: "100.01%", err: true},
+		{value: "150%", err: true},
+		{value: "-1%", err: true},
+		{value: "-0.5%", err: true},
+		{value: "60", err: true},
+		{value: "%", err: true},
+		{value: "sixty%", err: true},
+		{value: float64(60), err: true},
+	}
+
+	for _, test := range tests {
+		got, err := memoryPressureLimit(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("memoryPressureLimit(%#v) = %d, expected an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("memoryPressureLimit(%#v) = %d, %v, expected %d", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestCurrentMemoryPressureLimit(t *testing.T) {
+	tests := []struct {
+		limit uint32
+		want  string
+	}{
+		{limit: 0, want: "0%"},
+		{limit: 429497, want: "0.01%"},
+		{limit: 1095216660, want: "25.5%"},
+		{limit: 2576980377, want: "60%"},
+		{limit: math.MaxUint32, want: "100%"},
+	}
+
+	for _, test := range tests {
+		got := currentMemoryPressureLimit(test.limit)
+		if got != test.want {
+			t.Errorf("currentMemoryPressureLimit(%d) = %q, expected %q", test.limit, got, test.want)
+		}
+
+		// limits read back from systemd convert into the limits they were read from
+		limit, err := memoryPressureLimit(got)
+		if err != nil || limit != test.limit {
+			t.Errorf("memoryPressureLimit(%q) = %d, %v, expected %d", got, limit, err, test.limit)
+		}
+	}
+}
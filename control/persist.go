@@ -0,0 +1,73 @@
+package control
+
+import (
+	"log/slog"
+	"maps"
+	"path"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// State is the enforcement state of the control endpoints kept across restarts:
+// the original values of the properties changed at runtime, and the pending
+// automatic thaws.
+type State struct {
+	Changes map[string]map[string]controlProperty `json:"changes,omitempty"`
+	Thaws   map[string]time.Time                  `json:"thaws,omitempty"`
+}
+
+// SaveState returns the current enforcement state
+func SaveState() State {
+	s := State{Changes: make(map[string]map[string]controlProperty), Thaws: make(map[string]time.Time)}
+
+	changes.mutex.Lock()
+	for unit, props := range changes.data {
+		s.Changes[unit] = maps.Clone(props)
+	}
+	changes.mutex.Unlock()
+
+	thaws.mutex.Lock()
+	for unit, t := range thaws.data {
+		s.Thaws[unit] = t.at
+	}
+	thaws.mutex.Unlock()
+	return s
+}
+
+// RestoreState restores the enforcement state saved before a restart, for the
+// units still underneath the root. Thaws that became due in between are done
+// right away.
+func RestoreState(cgroupRoot string, s State) {
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	children, err := h.Children(cgroupRoot)
+	if err != nil {
+		slog.Warn("unable to list units to restore enforcement state", "root", cgroupRoot, "err", err)
+		return
+	}
+	present := make(map[string]bool, len(children))
+	for _, cg := range children {
+		present[path.Base(cg)] = true
+	}
+
+	changed, thawing := 0, 0
+	for unit, props := range s.Changes {
+		if !present[unit] {
+			slog.Debug("forgetting runtime changes of unit that is gone", "unit", unit)
+			continue
+		}
+		for _, original := range props {
+			changes.record(unit, original)
+		}
+		changed++
+	}
+
+	for unit, at := range s.Thaws {
+		if !present[unit] {
+			continue
+		}
+		thaws.schedule(unit, max(0, time.Until(at)))
+		thawing++
+	}
+	slog.Info("restored enforcement state", "changed", changed, "thaws", thawing)
+}
@@ -0,0 +1,197 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
+)
+
+type priorityRequest struct {
+	Value *int `json:"value"`
+	PID   int  `json:"pid"`
+}
+
+type priorityResponse struct {
+	Unit      string `json:"unit"`
+	Value     int    `json:"value"`
+	Processes int    `json:"processes"`
+	Failed    int    `json:"failed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// priority is a setting of the processes of a unit that deprioritizes them
+// without limiting them, used by priorityHandler
+type priority struct {
+	action string
+	name   string
+	min    int
+	max    int
+	set    func(pid int, value int) error
+}
+
+var (
+	niceness = priority{action: "renice", name: "nice value", min: -20, max: 19, set: renice}
+	oomScore = priority{action: "oom-score-adjust", name: "OOM score adjustment", min: -1000, max: 1000, set: setOOMScoreAdjust}
+)
+
+// ReniceHandler sets the nice value of the processes of the unit named in the
+// path, from -20 to 19, or of a single process of the unit if a pid is given.
+// Processes started afterwards inherit the nice value of their parent.
+func ReniceHandler(cgroupRoot string) http.HandlerFunc {
+	return priorityHandler(cgroupRoot, niceness)
+}
+
+// OOMScoreAdjustHandler sets the OOM score adjustment of the processes of the
+// unit named in the path, from -1000 to 1000, so that the kernel kills them
+// first when the node runs out of memory, or of a single process of the unit
+// if a pid is given.
+func OOMScoreAdjustHandler(cgroupRoot string) http.HandlerFunc {
+	return priorityHandler(cgroupRoot, oomScore)
+}
+
+func priorityHandler(cgroupRoot string, p priority) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := priorityResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: p.action, Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request priorityRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+
+		if request.Value == nil {
+			err = errors.New("value is required")
+			status = http.StatusBadRequest
+			return
+		}
+		value := *request.Value
+		response.Value = value
+		entry.New = value
+		if value < p.min || value > p.max {
+			err = fmt.Errorf("invalid %s %d, expected %d to %d", p.name, value, p.min, p.max)
+			status = http.StatusBadRequest
+			return
+		}
+
+		err = validUnit(cgroupRoot, unit)
+		if err != nil {
+			status = http.StatusNotFound
+			return
+		}
+
+		err = authorize(unit, cgroupRoot)
+		if err != nil {
+			status = http.StatusForbidden
+			return
+		}
+
+		h := hierarchy.NewHierarchy(cgroupRoot)
+		pids, err := h.Procs(path.Join(cgroupRoot, unit))
+		if err != nil {
+			reportError(unit, err)
+			status = http.StatusBadRequest
+			return
+		}
+		if request.PID != 0 {
+			if !slices.Contains(pids, uint64(request.PID)) {
+				err = fmt.Errorf("process %d does not belong to unit %s", request.PID, unit)
+				status = http.StatusForbidden
+				return
+			}
+			pids = []uint64{uint64(request.PID)}
+		}
+
+		procs := notify.UnitProcesses(cgroupRoot, unit)
+		slog.Info("setting "+p.name+" of unit", "unit", unit, "value", value, "processes", len(pids), "remote", r.RemoteAddr)
+
+		var failure error
+		for _, pid := range pids {
+			err := p.set(int(pid), value)
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH) {
+				// the process exited in between
+				continue
+			}
+			if err != nil {
+				slog.Debug("unable to set "+p.name+" of process", "unit", unit, "pid", pid, "err", err)
+				failure = err
+				response.Failed++
+				continue
+			}
+			response.Processes++
+		}
+
+		if response.Processes == 0 && failure != nil {
+			err = failure
+			reportError(unit, err)
+			status = http.StatusBadRequest
+			return
+		}
+		if failure != nil {
+			slog.Warn("unable to set "+p.name+" of some processes", "unit", unit, "failed", response.Failed, "err", failure)
+		}
+
+		notifyAction(r.Context(), cgroupRoot, unit, p.action, fmt.Sprintf("set %s of %d processes of %s to %d", p.name, response.Processes, unit, value), procs)
+	}
+}
+
+// reportError reports an error of the control endpoints, from handlers where the
+// status package is shadowed by the status of the response
+func reportError(unit string, err error) {
+	status.Report(status.Control, unit, err)
+}
+
+// renice sets the nice value of every thread of the process, since a nice value
+// set through the pid only applies to its main thread
+func renice(pid int, value int) error {
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		err = syscall.Setpriority(syscall.PRIO_PROCESS, tid, value)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+func setOOMScoreAdjust(pid int, value int) error {
+	return os.WriteFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(strconv.Itoa(value)), 0)
+}
@@ -0,0 +1,147 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+type reclaimRequest struct {
+	Amount any `json:"amount"`
+}
+
+type reclaimResponse struct {
+	Unit      string `json:"unit"`
+	Requested uint64 `json:"requested"`
+	Reclaimed uint64 `json:"reclaimed"`
+	Usage     uint64 `json:"usage"`
+	Warning   string `json:"warning,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reclaimAmount converts the amount of memory to reclaim, either a number of
+// bytes, or a percentage of the usage of the unit like "25%".
+func reclaimAmount(value any, usage uint64) (uint64, error) {
+	switch v := value.(type) {
+	case float64: // json type
+		if v <= 0 || v != math.Trunc(v) {
+			return 0, errors.New("invalid amount, expected a positive number of bytes")
+		}
+		return uint64(v), nil
+	case string:
+		percent, ok := strings.CutSuffix(v, "%")
+		if !ok {
+			return 0, errors.New("invalid amount, expected a number of bytes or a percentage of usage")
+		}
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid amount '%s', expected a percentage from 0 to 100", v)
+		}
+		return uint64(float64(usage) * p / 100), nil
+	}
+	return 0, errors.New("invalid type for amount, expected float64 or string")
+}
+
+// ReclaimHandler has the kernel reclaim memory from the unit named in the path,
+// through memory.reclaim on cgroup v2, pushing its cold pages to swap before the
+// node runs short of memory. Unlike lowering MemoryMax, the unit keeps its limit.
+func ReclaimHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := reclaimResponse{Unit: unit}
+		status := http.StatusOK
+		entry := audit.Entry{Action: "reclaim", Unit: unit}
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+				audit.Record(r.Context(), entry, err)
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request reclaimRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+
+		err = validUnit(cgroupRoot, unit)
+		if err != nil {
+			status = http.StatusNotFound
+			return
+		}
+
+		err = authorize(unit, cgroupRoot)
+		if err != nil {
+			status = http.StatusForbidden
+			return
+		}
+
+		h := hierarchy.NewHierarchy(cgroupRoot)
+		cg := path.Join(cgroupRoot, unit)
+		before, err := h.CGroupInfo(cg)
+		if err != nil {
+			reportError(unit, err)
+			status = http.StatusBadRequest
+			return
+		}
+
+		response.Requested, err = reclaimAmount(request.Amount, before.MemoryUsage)
+		if err != nil {
+			status = http.StatusBadRequest
+			return
+		}
+		entry.New = response.Requested
+		response.Usage = before.MemoryUsage
+		if response.Requested == 0 {
+			response.Warning = "nothing to reclaim"
+			return
+		}
+
+		slog.Info("reclaiming memory of unit", "unit", unit, "amount", response.Requested, "usage", before.MemoryUsage, "remote", r.RemoteAddr)
+		err = h.Reclaim(unit, response.Requested)
+		if errors.Is(err, errors.ErrUnsupported) {
+			status = http.StatusNotImplemented
+			return
+		}
+		if errors.Is(err, syscall.EAGAIN) {
+			// the kernel reclaimed what it could
+			response.Warning = "reclaimed less memory than requested"
+			err = nil
+		}
+		if err != nil {
+			reportError(unit, err)
+			status = http.StatusBadRequest
+			return
+		}
+
+		after, err := h.CGroupInfo(cg)
+		if err == nil {
+			response.Usage = after.MemoryUsage
+		}
+		err = nil
+		if response.Usage < before.MemoryUsage {
+			response.Reclaimed = before.MemoryUsage - response.Usage
+		}
+
+		notifyAction(r.Context(), cgroupRoot, unit, "reclaim", fmt.Sprintf("reclaimed %d of %d bytes requested from %s", response.Reclaimed, response.Requested, unit), nil)
+	}
+}
@@ -0,0 +1,155 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// changeTracker remembers the value each property had before the warden first
+// changed it at runtime, so that the change can be reverted.
+type changeTracker struct {
+	data  map[string]map[string]controlProperty
+	mutex sync.Mutex
+}
+
+var changes = &changeTracker{data: make(map[string]map[string]controlProperty)}
+
+func (ct *changeTracker) tracked(unit string, name string) bool {
+	defer ct.mutex.Unlock()
+	ct.mutex.Lock()
+	_, ok := ct.data[unit][name]
+	return ok
+}
+
+// record stores the original value of a property, keeping the first recorded
+func (ct *changeTracker) record(unit string, original controlProperty) {
+	defer ct.mutex.Unlock()
+	ct.mutex.Lock()
+	props, ok := ct.data[unit]
+	if !ok {
+		props = make(map[string]controlProperty)
+		ct.data[unit] = props
+	}
+	if _, ok := props[original.Name]; !ok {
+		props[original.Name] = original
+	}
+}
+
+// originals returns the original values of the properties changed on the unit,
+// limited to the names given if any.
+func (ct *changeTracker) originals(unit string, names []string) []controlProperty {
+	defer ct.mutex.Unlock()
+	ct.mutex.Lock()
+	var props []controlProperty
+	for name, prop := range ct.data[unit] {
+		if len(names) == 0 || slices.Contains(names, name) {
+			props = append(props, prop)
+		}
+	}
+	slices.SortFunc(props, func(a, b controlProperty) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return props
+}
+
+// units returns the units with properties changed at runtime
+func (ct *changeTracker) units() []string {
+	defer ct.mutex.Unlock()
+	ct.mutex.Lock()
+	return slices.Sorted(maps.Keys(ct.data))
+}
+
+// RuntimeChanges returns the properties of the unit changed at runtime through the
+// api, which would be restored by a reset
+func RuntimeChanges(unit string) []string {
+	defer changes.mutex.Unlock()
+	changes.mutex.Lock()
+	return slices.Sorted(maps.Keys(changes.data[unit]))
+}
+
+func (ct *changeTracker) forget(unit string, name string) {
+	defer ct.mutex.Unlock()
+	ct.mutex.Lock()
+	delete(ct.data[unit], name)
+	if len(ct.data[unit]) == 0 {
+		delete(ct.data, unit)
+	}
+}
+
+type resetRequest struct {
+	Properties []string `json:"properties"`
+}
+
+type resetResponse struct {
+	Unit    string            `json:"unit"`
+	Results []controlResponse `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// ResetHandler reverts the runtime changes the warden made to the unit named in
+// the path, restoring the values properties had before they were first changed.
+// Only the properties named in the request are reverted, if any are named.
+func ResetHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		unit := r.PathValue("name")
+		response := resetResponse{Unit: unit, Results: []controlResponse{}}
+		status := http.StatusOK
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request resetRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+		err = nil
+
+		var failed int
+		response.Results, status, failed = reset(r.Context(), cgroupRoot, unit, request.Properties)
+		if failed > 0 {
+			err = errors.New("unable to reset some properties")
+		}
+		slog.Info("reset unit", "unit", unit, "properties", len(response.Results), "failed", failed)
+	}
+}
+
+// reset restores the original values of the properties changed on the unit,
+// limited to the names given if any, returning the result for each property,
+// the http status to report and how many could not be restored.
+func reset(ctx context.Context, cgroupRoot string, unit string, names []string) ([]controlResponse, int, int) {
+	results := []controlResponse{}
+	status := http.StatusOK
+	failed := 0
+	for _, original := range changes.originals(unit, names) {
+		result, code, err := apply(ctx, controlRequest{Unit: unit, Property: original, Runtime: true}, cgroupRoot)
+		if err != nil {
+			result.Error = err.Error()
+			status = code
+			failed++
+		} else {
+			changes.forget(unit, original.Name)
+		}
+		results = append(results, result)
+	}
+	return results, status, failed
+}
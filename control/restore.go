@@ -0,0 +1,125 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// restorer watches the usage of the units changed at runtime, only used by the
+// loop of RestoreChanges
+type restorer struct {
+	root      string
+	period    time.Duration
+	threshold float64
+
+	// last cpu usage of each unit in seconds, and since when its usage is normal
+	cpu    map[string]float64
+	sample time.Time
+	normal map[string]time.Time
+}
+
+// RestoreChanges reverts the runtime changes made to units through the api, like
+// a manual throttle, once their usage has stayed below the threshold, a fraction
+// of their CPU quota and memory limit, for the period. Usage is checked every
+// interval until the context is done.
+func RestoreChanges(ctx context.Context, cgroupRoot string, period time.Duration, threshold float64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx = audit.WithActor(ctx, "restore")
+	r := &restorer{
+		root:      cgroupRoot,
+		period:    period,
+		threshold: threshold,
+		cpu:       make(map[string]float64),
+		normal:    make(map[string]time.Time),
+	}
+	for {
+		r.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *restorer) check(ctx context.Context) {
+	now := time.Now()
+	elapsed := now.Sub(r.sample).Seconds()
+	r.sample = now
+
+	h := hierarchy.NewHierarchy(r.root)
+	cpu := make(map[string]float64)
+	for _, unit := range changes.units() {
+		info, err := h.CGroupInfo(path.Join(r.root, unit))
+		if err != nil {
+			slog.Debug("unable to sample changed unit", "unit", unit, "err", err)
+			continue
+		}
+		cpu[unit] = info.CPUUsage
+
+		// the cpu usage is a rate, so nothing is known of the first sample
+		previous, ok := r.cpu[unit]
+		if !ok {
+			continue
+		}
+		cores := (info.CPUUsage - previous) / elapsed
+
+		if !r.isNormal(info, cores) {
+			delete(r.normal, unit)
+			continue
+		}
+		since, ok := r.normal[unit]
+		if !ok {
+			r.normal[unit] = now
+			continue
+		}
+		if now.Sub(since) >= r.period {
+			r.restore(ctx, unit)
+		}
+	}
+
+	r.cpu = cpu
+	for unit := range r.normal {
+		if _, ok := cpu[unit]; !ok {
+			delete(r.normal, unit)
+		}
+	}
+}
+
+// isNormal reports whether the usage of the unit is below the threshold of both
+// its CPU quota and memory limit, either of which it may not have
+func (r *restorer) isNormal(info hierarchy.CGroupInfo, cores float64) bool {
+	if info.CPUQuota > 0 && cores >= r.threshold*float64(info.CPUQuota)/hierarchy.USPerS {
+		return false
+	}
+	if info.MemoryMax > 0 && info.MemoryMax < hierarchy.MaxCGroupMemoryLimit && float64(info.MemoryUsage) >= r.threshold*float64(info.MemoryMax) {
+		return false
+	}
+	return true
+}
+
+func (r *restorer) restore(ctx context.Context, unit string) {
+	results, _, failed := reset(ctx, r.root, unit, nil)
+	if failed > 0 {
+		slog.Warn("unable to restore some properties of unit", "unit", unit, "properties", len(results), "failed", failed)
+		return
+	}
+	delete(r.normal, unit)
+
+	names := make([]string, 0, len(results))
+	for _, result := range results {
+		names = append(names, fmt.Sprintf("%s=%v", result.Property.Name, result.Property.Value))
+	}
+	slog.Info("restored unit after usage returned to normal", "unit", unit, "properties", len(results), "period", r.period)
+	notifyAction(ctx, r.root, unit, "restore", fmt.Sprintf("restored %s of %s after its usage stayed normal for %s", strings.Join(names, " "), unit, r.period), nil)
+}
@@ -0,0 +1,65 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// property systemd sets TasksMax with as a fraction of the tasks the node allows
+const tasksMaxScale = "TasksMaxScale"
+
+// tasksMax converts the value of a TasksMax request, either the maximum number of
+// tasks, a percentage like "10%" of the tasks the node allows, or -1 or "infinity"
+// to remove the limit. A percentage is set as TasksMaxScale, the fraction of the
+// maximum uint32 systemd expects.
+func tasksMax(value any) (systemd.Property, error) {
+	switch v := value.(type) {
+	case float64: // json type
+		if v == -1 || v >= math.MaxUint64 {
+			return systemd.Property{Name: TasksMax, Value: dbus.MakeVariant(uint64(math.MaxUint64))}, nil
+		}
+		if v < 1 || v != math.Trunc(v) {
+			return systemd.Property{}, errors.New("invalid TasksMax, expected a positive integer")
+		}
+		return systemd.Property{Name: TasksMax, Value: dbus.MakeVariant(uint64(v))}, nil
+	case string:
+		if v == "infinity" {
+			return systemd.Property{Name: TasksMax, Value: dbus.MakeVariant(uint64(math.MaxUint64))}, nil
+		}
+		percent, ok := strings.CutSuffix(v, "%")
+		if !ok {
+			return systemd.Property{}, errors.New("invalid TasksMax, expected a positive integer, a percentage or infinity")
+		}
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return systemd.Property{}, fmt.Errorf("invalid TasksMax '%s', expected a percentage above 0 and up to 100", v)
+		}
+		scale := uint32(math.Round(p / 100 * math.MaxUint32))
+		return systemd.Property{Name: tasksMaxScale, Value: dbus.MakeVariant(scale)}, nil
+	}
+	return systemd.Property{}, errors.New("invalid type for property, expected float64 or string")
+}
+
+// appliedTasksMax reads back the TasksMax systemd applied to the unit, in the same
+// form as the value of a request.
+func appliedTasksMax(unit string) (any, error) {
+	value, err := getSystemdProperty(unit, TasksMax)
+	if err != nil {
+		return nil, err
+	}
+
+	max, ok := value.(uint64)
+	if !ok {
+		return nil, errors.New("unexpected type of TasksMax")
+	}
+	if max == math.MaxUint64 {
+		return "infinity", nil
+	}
+	return max, nil
+}
@@ -0,0 +1,45 @@
+package control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTasksMax(t *testing.T) {
+	tests := []struct {
+		value any
+		name  string
+		want  any
+		err   bool
+	}{
+		{value: float64(4096), name: TasksMax, want: uint64(4096)},
+		{value: float64(1), name: TasksMax, want: uint64(1)},
+		{value: float64(-1), name: TasksMax, want: uint64(math.MaxUint64)},
+		{value: float64(math.MaxUint64), name: TasksMax, want: uint64(math.MaxUint64)},
+		{value: "infinity", name: TasksMax, want: uint64(math.MaxUint64)},
+		{value: "10%", name: tasksMaxScale, want: uint32(429496730)},
+		{value: "50%", name: tasksMaxScale, want: uint32(2147483648)},
+		{value: "100%", name: tasksMaxScale, want: uint32(math.MaxUint32)},
+		{value: float64(0), err: true},
+		{value: float64(-2), err: true},
+		{value: float64(1.5), err: true},
+		{value: "0%", err: true},
+		{value: "101%", err: true},
+		{value: "4096", err: true},
+		{value: "unlimited", err: true},
+		{value: true, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := tasksMax(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("tasksMax(%#v) = %v, expected an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got.Name != test.name || got.Value.Value() != test.want {
+			t.Errorf("tasksMax(%#v) = %s %v, %v, expected %s %v", test.value, got.Name, got.Value, err, test.name, test.want)
+		}
+	}
+}
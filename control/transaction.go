@@ -0,0 +1,189 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+type transactionRequest struct {
+	Changes []controlRequest `json:"changes"`
+}
+
+type transactionResponse struct {
+	Committed  bool              `json:"committed"`
+	Results    []controlResponse `json:"results"`
+	RolledBack []controlResponse `json:"rolledBack,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// TransactionHandler applies a set of control requests across units atomically.
+// The current value of every property is recorded before it is changed, and if
+// any change fails, the changes already applied are reverted in reverse order.
+func TransactionHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		var response transactionResponse
+		status := http.StatusOK
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		var request transactionRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+
+		if len(request.Changes) == 0 {
+			err = errors.New("transaction contains no changes")
+			status = http.StatusBadRequest
+			return
+		}
+
+		var previous []controlRequest
+		for _, change := range request.Changes {
+			err = validUnit(cgroupRoot, change.Unit)
+			if err != nil {
+				status = http.StatusNotFound
+				break
+			}
+
+			var prop controlProperty
+			prop, err = currentProperty(change, cgroupRoot)
+			if err != nil {
+				err = fmt.Errorf("unable to read current %s of %s: %w", change.Property.Name, change.Unit, err)
+				status = http.StatusBadRequest
+				break
+			}
+
+			var result controlResponse
+			result, status, err = apply(r.Context(), change, cgroupRoot)
+			if err != nil {
+				result.Error = err.Error()
+				response.Results = append(response.Results, result)
+				err = fmt.Errorf("unable to set %s of %s: %w", change.Property.Name, change.Unit, err)
+				break
+			}
+
+			response.Results = append(response.Results, result)
+			previous = append(previous, controlRequest{Unit: change.Unit, Property: prop, Runtime: change.Runtime})
+		}
+
+		if err == nil {
+			response.Committed = true
+			slog.Info("committed transaction", "changes", len(request.Changes))
+			return
+		}
+
+		slog.Warn("rolling back transaction", "err", err, "applied", len(previous))
+		for i := len(previous) - 1; i >= 0; i-- {
+			result, _, rollbackErr := apply(r.Context(), previous[i], cgroupRoot)
+			if rollbackErr != nil {
+				slog.Error("unable to roll back change", "unit", previous[i].Unit, "property", previous[i].Property.Name, "err", rollbackErr)
+				result.Error = rollbackErr.Error()
+			}
+			response.RolledBack = append(response.RolledBack, result)
+		}
+	}
+}
+
+// currentProperty reads the current value of the property a control request
+// would change, in the same form as the value of a request.
+func currentProperty(request controlRequest, cgroupRoot string) (controlProperty, error) {
+	prop := controlProperty{Name: request.Property.Name}
+
+	if request.Property.Name == MemorySwapMax || request.Property.Name == MemoryMax {
+		h := hierarchy.NewHierarchy(cgroupRoot)
+		info, err := h.CGroupInfo(path.Join(cgroupRoot, request.Unit))
+		if err != nil {
+			return prop, err
+		}
+
+		limit := info.MemoryMax
+		if request.Property.Name == MemorySwapMax {
+			limit = info.SwapMax
+		}
+
+		prop.Value = float64(limit)
+		if limit >= hierarchy.MaxCGroupMemoryLimit {
+			prop.Value = float64(-1)
+		}
+		return prop, nil
+	}
+
+	value, err := getSystemdProperty(request.Unit, request.Property.Name)
+	if err != nil {
+		return prop, err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		prop.Value = v
+	case uint64:
+		prop.Value = float64(v)
+	case uint32: // only ManagedOOMMemoryPressureLimit
+		prop.Value = currentMemoryPressureLimit(v)
+	case string:
+		prop.Value = v
+	case []byte:
+		prop.Value = cpuList(v)
+	case [][]any:
+		prop.Value = currentIOLimits(v, request.Property.Value)
+	default:
+		return prop, fmt.Errorf("unsupported type %T for property %s", value, request.Property.Name)
+	}
+	return prop, nil
+}
+
+// CurrentProperty reads the current value of a property of a unit, in the same
+// form as the value of a control request, so that it can be set back later. The
+// requested value is only used for the IO limits, to include the devices it names.
+func CurrentProperty(cgroupRoot string, unit string, name string, requested any) (any, error) {
+	prop, err := currentProperty(controlRequest{Unit: unit, Property: controlProperty{Name: name, Value: requested}}, cgroupRoot)
+	return prop.Value, err
+}
+
+func getSystemdProperty(unit string, name string) (any, error) {
+	ctx := context.Background()
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		slog.Warn("unable to connect to systemd", "err", err.Error())
+		return nil, err
+	}
+	defer conn.Close()
+
+	property, err := conn.GetUnitTypePropertyContext(ctx, unit, unitType(unit), name)
+	if err != nil {
+		slog.Warn("unable to get property", "err", err.Error(), "property", name, "unit", unit)
+		return nil, err
+	}
+	return property.Value.Value(), nil
+}
+
+// unitType returns the dbus interface type of a unit, like "Slice" for user-1000.slice
+func unitType(unit string) string {
+	ext := strings.TrimPrefix(path.Ext(unit), ".")
+	if ext == "" {
+		return "Slice"
+	}
+	return strings.ToUpper(ext[:1]) + ext[1:]
+}
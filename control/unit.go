@@ -0,0 +1,68 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+type propertyRequest struct {
+	Name    string `json:"name"`
+	Value   any    `json:"value"`
+	Runtime bool   `json:"runtime"`
+}
+
+func (r *propertyRequest) UnmarshalJSON(data []byte) error {
+	type plain propertyRequest
+	request := plain{Runtime: DefaultRuntime}
+	err := json.Unmarshal(data, &request)
+	*r = propertyRequest(request)
+	return err
+}
+
+// UnitPropertyHandler sets a single property of the unit named in the path, in
+// the same way as a request to the control endpoint.
+func UnitPropertyHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var err error
+		var response controlResponse
+		status := http.StatusOK
+
+		defer func() {
+			if err != nil {
+				response.Error = err.Error()
+			}
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(response)
+		}()
+
+		unit := r.PathValue("name")
+		response.Unit = unit
+
+		var request propertyRequest
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			status = http.StatusBadRequest
+			return
+		}
+
+		if request.Name == "" {
+			err = errors.New("property name is required")
+			status = http.StatusBadRequest
+			return
+		}
+
+		slog.Debug("Decoded request", "unit", unit, "property", request.Name, "value", request.Value)
+
+		response, status, err = apply(r.Context(), controlRequest{
+			Unit:     unit,
+			Property: controlProperty{Name: request.Name, Value: request.Value},
+			Runtime:  request.Runtime,
+		}, cgroupRoot)
+	}
+}
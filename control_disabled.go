@@ -0,0 +1,35 @@
+//go:build readonly
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const readOnlyBuild = true
+
+func registerControl(mux *http.ServeMux, conf *Config, tokens *tokenStore) {}
+
+func startRemediation(conf *Config) error { return nil }
+
+func runMigrate(args []string) int {
+	fmt.Fprintln(os.Stderr, "migrate is not available in read-only builds")
+	return 1
+}
+
+func runPolicy(args []string) int {
+	fmt.Fprintln(os.Stderr, "policy is not available in read-only builds")
+	return 1
+}
+
+func runAudit(args []string) int {
+	fmt.Fprintln(os.Stderr, "audit is not available in read-only builds")
+	return 1
+}
+
+func runHistory(args []string) int {
+	fmt.Fprintln(os.Stderr, "history is not available in read-only builds")
+	return 1
+}
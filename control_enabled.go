@@ -0,0 +1,161 @@
+//go:build !readonly
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/policy"
+)
+
+const readOnlyBuild = false
+
+// registerControl adds the endpoints able to modify cgroups or warden state to the mux.
+// Building with the readonly tag compiles these out entirely.
+func registerControl(mux *http.ServeMux, conf *Config, tokens *tokenStore) {
+	secure := func(scope string, handler http.Handler) http.Handler {
+		if conf.InsecureMode {
+			return allowNetworks(audit.Identify(handler, "anonymous"), conf.ControlAllowedNetworks)
+		}
+		return allowNetworks(authorize(handler, tokens, scope), conf.ControlAllowedNetworks)
+	}
+
+	control.UnitPatterns = conf.UnitPatterns
+	control.DefaultRuntime = conf.ControlRuntime
+
+	mux.Handle("/control", secure(scopeUnitWrite, control.ControlHandler(conf.RootCGroup)))
+	mux.Handle("POST /control/transaction", secure(scopeUnitWrite, control.TransactionHandler(conf.RootCGroup)))
+	mux.Handle("PATCH /api/v1/unit/{name}/property", secure(scopeUnitWrite, control.UnitPropertyHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/reset", secure(scopeUnitWrite, control.ResetHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/units/apply", secure(scopeUnitWrite, control.BulkHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/freeze", secure(scopeUnitWrite, control.FreezeHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/thaw", secure(scopeUnitWrite, control.ThawHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/kill", secure(scopeUnitKill, control.KillHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/signal", secure(scopeUnitKill, control.SignalHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/reclaim", secure(scopeUnitWrite, control.ReclaimHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/renice", secure(scopeUnitWrite, control.ReniceHandler(conf.RootCGroup)))
+	mux.Handle("POST /api/v1/unit/{name}/oom-score-adjust", secure(scopeUnitWrite, control.OOMScoreAdjustHandler(conf.RootCGroup)))
+
+	mux.Handle("GET /silences", secure(scopeUnitRead, admin.ListSilencesHandler()))
+	mux.Handle("POST /silences", secure(scopeUnitWrite, admin.CreateSilenceHandler()))
+	mux.Handle("DELETE /silences/{id}", secure(scopeUnitWrite, admin.DeleteSilenceHandler()))
+	mux.Handle("GET /notes", secure(scopeUnitRead, admin.ListNotesHandler()))
+	mux.Handle("POST /notes", secure(scopeUnitWrite, admin.CreateNoteHandler()))
+	mux.Handle("DELETE /notes/{id}", secure(scopeUnitWrite, admin.DeleteNoteHandler()))
+	mux.Handle("GET /tags", secure(scopeUnitRead, admin.ListTagsHandler()))
+	mux.Handle("POST /tags/{unit}", secure(scopeUnitWrite, admin.AddTagHandler()))
+	mux.Handle("DELETE /tags/{unit}/{tag}", secure(scopeUnitWrite, admin.RemoveTagHandler()))
+	mux.Handle("GET /pauses", secure(scopeUnitRead, admin.ListPausesHandler()))
+	mux.Handle("POST /pauses", secure(scopeUnitWrite, admin.CreatePauseHandler()))
+	mux.Handle("DELETE /pauses/{id}", secure(scopeUnitWrite, admin.DeletePauseHandler()))
+	mux.Handle("GET /api/v1/violations", secure(scopeUnitRead, policy.ViolationsHandler()))
+	mux.Handle("POST /api/v1/unit/{name}/violations/{rule}/ack", secure(scopeUnitWrite, policy.AcknowledgeHandler(conf.RootCGroup)))
+	mux.Handle("GET /api/v1/pins", secure(scopeUnitRead, policy.PinsHandler()))
+	mux.Handle("PUT /api/v1/unit/{name}/pins/{property}", secure(scopeUnitWrite, policy.PinHandler(conf.RootCGroup)))
+	mux.Handle("DELETE /api/v1/unit/{name}/pins/{property}", secure(scopeUnitWrite, policy.UnpinHandler()))
+	mux.Handle("GET /audit", secure(scopeUnitRead, audit.ListHandler()))
+	mux.Handle("GET /audit/verify", secure(scopeUnitRead, audit.VerifyHandler()))
+}
+
+// startRemediation opens the audit log and registers the notifiers, exports the
+// state of enforcement with the metrics, restores the enforcement state of the
+// previous process, and starts the background tasks modifying units without a
+// request.
+func startRemediation(conf *Config) error {
+	if conf.AuditFile != "" || conf.AuditJournal {
+		audit.MaxSize = conf.AuditMaxSize
+		audit.Keep = conf.AuditKeep
+		err := audit.Open(conf.AuditFile, conf.AuditJournal)
+		if err != nil {
+			return fmt.Errorf("invalid audit log: %w", err)
+		}
+	}
+
+	err := registerNotifiers(conf)
+	if err != nil {
+		return err
+	}
+
+	metrics.Enforcement = enforcementMetrics{}
+
+	if conf.EnforcementFile != "" {
+		err := restoreEnforcement(conf.EnforcementFile, conf.RootCGroup)
+		if err != nil {
+			return err
+		}
+		go saveEnforcement(context.Background(), conf.EnforcementFile, enforcementSaveInterval)
+	}
+
+	if len(conf.EnableAccounting) > 0 {
+		err := control.ValidateAccounting(conf.EnableAccounting)
+		if err != nil {
+			return err
+		}
+		go control.EnsureAccounting(audit.WithActor(context.Background(), "accounting"), conf.RootCGroup, conf.UnitPatterns,
+			conf.EnableAccounting, conf.EnableAccountingRuntime, time.Minute)
+	}
+
+	if conf.RestoreAfter > 0 {
+		go control.RestoreChanges(context.Background(), conf.RootCGroup, conf.RestoreAfter, conf.RestoreThreshold, time.Minute)
+	}
+
+	if conf.PolicyFile != "" {
+		p, err := policy.Load(conf.PolicyFile)
+		if err != nil {
+			return err
+		}
+		go policy.NewEngine(conf.RootCGroup, p, conf.DryRun).Run(context.Background(), time.Minute)
+	}
+	return nil
+}
+
+// registerNotifiers registers the notifiers of penalties and violations that are
+// configured
+func registerNotifiers(conf *Config) error {
+	if conf.WebhookURL != "" {
+		w, err := notify.NewWebhook(conf.WebhookURL, conf.WebhookTemplate, conf.WebhookRetries, conf.WebhookTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid webhook: %w", err)
+		}
+		notify.Register(w)
+	}
+
+	if conf.EmailServer != "" {
+		var password string
+		if conf.EmailPasswordFile != "" {
+			buf, err := os.ReadFile(conf.EmailPasswordFile)
+			if err != nil {
+				return fmt.Errorf("unable to read email password: %w", err)
+			}
+			password = strings.TrimSpace(string(buf))
+		}
+		e, err := notify.NewEmail(conf.EmailServer, conf.EmailFrom, conf.EmailAddress, conf.EmailAddressCommand, conf.EmailUsername, password)
+		if err != nil {
+			return fmt.Errorf("invalid email configuration: %w", err)
+		}
+		notify.Register(e)
+	}
+
+	if conf.ChatWebhookURL != "" {
+		chat, err := notify.NewChat(conf.ChatWebhookURL, conf.ChatChannels, conf.WebhookRetries, conf.WebhookTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid chat configuration: %w", err)
+		}
+		notify.Register(chat)
+	}
+
+	if conf.TerminalMessages {
+		notify.Register(&notify.Terminal{})
+	}
+	return nil
+}
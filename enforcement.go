@@ -0,0 +1,125 @@
+//go:build !readonly
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/chpc-uofu/cgroup-warden/policy"
+	"github.com/chpc-uofu/cgroup-warden/state"
+)
+
+// how often the enforcement state is written to disk, if it changed
+const enforcementSaveInterval = 15 * time.Second
+
+// enforcementState is the state of enforcement kept across restarts, so that a
+// restart does not lift every throttle, penalty and pending thaw
+type enforcementState struct {
+	Control control.State `json:"control"`
+	Policy  policy.State  `json:"policy"`
+}
+
+// restoreEnforcement restores the enforcement state saved by a previous process
+// for the units still present. It must be called before the policy engine starts.
+func restoreEnforcement(file string, root string) error {
+	var s enforcementState
+	if err := state.ReadJSON(file, &s); err != nil {
+		return err
+	}
+	control.RestoreState(root, s.Control)
+	policy.RestoreState(root, s.Policy)
+	return nil
+}
+
+// saveEnforcement writes the enforcement state to the file every interval if it
+// changed, until the context is done
+func saveEnforcement(ctx context.Context, file string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var saved []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s := enforcementState{Control: control.SaveState(), Policy: policy.SaveState()}
+		buf, err := json.Marshal(s)
+		if err != nil || bytes.Equal(buf, saved) {
+			continue
+		}
+		if err := state.WriteJSON(file, s); err != nil {
+			slog.Warn("unable to save enforcement state", "file", file, "err", err)
+			continue
+		}
+		saved = buf
+	}
+}
+
+// enforcementMetrics reports the silences, pauses and tags of operators, the state
+// of the policy and the changes made through the control api for the metrics of
+// units
+type enforcementMetrics struct{}
+
+func (enforcementMetrics) Silenced(cg string, username string) bool {
+	_, ok := admin.Silenced(cg, username)
+	return ok
+}
+
+func (enforcementMetrics) Paused(cg string, username string) bool {
+	_, ok := admin.Paused(cg, username)
+	return ok
+}
+
+func (enforcementMetrics) Tags(cg string) []string {
+	return admin.Tags(cg)
+}
+
+func (enforcementMetrics) Penalty(cg string) (metrics.Penalty, bool) {
+	penalty, ok := policy.UnitPenalty(cg)
+	return metrics.Penalty{Tier: penalty.Tier, Name: penalty.Name, Remaining: penalty.Remaining}, ok
+}
+
+func (enforcementMetrics) AppliedLimits(cg string) []metrics.AppliedLimit {
+	var limits []metrics.AppliedLimit
+	applied := policy.AppliedLimits(cg)
+	for _, property := range slices.Sorted(maps.Keys(applied)) {
+		limits = append(limits, metrics.AppliedLimit{Property: property, Source: applied[property]})
+	}
+	for _, property := range control.RuntimeChanges(path.Base(cg)) {
+		limits = append(limits, metrics.AppliedLimit{Property: property, Source: "api"})
+	}
+	return limits
+}
+
+func (enforcementMetrics) Violations(cg string) []metrics.Violation {
+	var violations []metrics.Violation
+	for _, rule := range policy.FiringRules(cg) {
+		violations = append(violations, metrics.Violation{
+			Rule:         rule.Name,
+			Severity:     rule.Severity,
+			Acknowledged: policy.Acknowledged(cg, rule.Name),
+		})
+	}
+	return violations
+}
+
+func (enforcementMetrics) Exemptions() []metrics.Exemption {
+	var exemptions []metrics.Exemption
+	for _, x := range policy.Exemptions() {
+		exemptions = append(exemptions, metrics.Exemption{Kind: x.Kind, Value: x.Value})
+	}
+	return exemptions
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// time given to each check of the readiness endpoint
+const readyTimeout = 5 * time.Second
+
+type readyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// healthHandler answers as long as the warden serves requests, for liveness
+// probes cheaper than a scrape
+func healthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// readyHandler answers whether the warden can do its work, which is that systemd
+// answers over dbus and the cgroups underneath the root can be read, with
+// 503 Service Unavailable if either fails
+func readyHandler(cgroupRoot string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := readyResponse{Ready: true, Checks: make(map[string]string)}
+		check := func(name string, err error) {
+			if err != nil {
+				slog.Debug("readiness check failed", "check", name, "err", err)
+				response.Ready = false
+				response.Checks[name] = err.Error()
+				return
+			}
+			response.Checks[name] = "ok"
+		}
+
+		check("dbus", checkSystemd(r.Context(), readyTimeout))
+		check("cgroupfs", checkCGroups(r.Context(), cgroupRoot))
+
+		w.Header().Set("Content-Type", "application/json")
+		if !response.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// checkCGroups lists the cgroups underneath the root within the timeout, since a
+// hung cgroupfs read would not return at all
+func checkCGroups(ctx context.Context, cgroupRoot string) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := hierarchy.NewHierarchy(cgroupRoot).Children(cgroupRoot)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
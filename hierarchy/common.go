@@ -1,9 +1,22 @@
 package hierarchy
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
 	"os/user"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/containerd/cgroups/v3"
 )
@@ -20,6 +33,12 @@ type Hierarchy interface {
 	GetGroupsWithPIDs() (map[string]map[uint64]bool, error)
 	CGroupInfo(cg string) (CGroupInfo, error)
 	SetMemoryLimits(unit string, limit int64) (int64, error)
+	SetSwapLimit(unit string, limit int64) (int64, error)
+	Children(cg string) ([]string, error)
+	Procs(cg string) ([]uint64, error)
+	MemoryEvents(cg string) (map[string]uint64, error)
+	Reclaim(unit string, amount uint64) error
+	Kill(unit string) error
 }
 
 func NewHierarchy(root string) Hierarchy {
@@ -42,11 +61,142 @@ type CGroupInfo struct {
 	MemoryUsage uint64
 	CPUUsage    float64
 	MemoryMax   uint64
+	SwapMax     uint64
+	SwapUsage   uint64
 	CPUQuota    int64
+
+	// total time in seconds some tasks were stalled, only available on the unified hierarchy
+	CPUPressure    float64
+	MemoryPressure float64
+	IOPressure     float64
+
+	// descendant cgroups as reported by cgroup.stat, only available on the unified hierarchy
+	Descendants      uint64
+	DyingDescendants uint64
+
+	// controllers available to the cgroup, and enabled for its children, as reported
+	// by cgroup.controllers and cgroup.subtree_control, only available on the unified hierarchy
+	Controllers    []string
+	SubtreeControl []string
 }
 
 var uidRe = regexp.MustCompile(`user-(\d+)\.slice`)
 
+// unitOf returns the unit directly underneath root that the cgroup cg belongs to.
+// Processes of the root cgroup itself do not belong to any unit.
+func unitOf(root string, cg string) (string, bool) {
+	root = path.Clean(root)
+	rel, ok := strings.CutPrefix(path.Clean(cg), root)
+	if !ok || (root != "/" && !strings.HasPrefix(rel, "/")) {
+		return "", false
+	}
+
+	rel = strings.TrimPrefix(rel, "/")
+	if rel == "" {
+		return "", false
+	}
+
+	name, _, _ := strings.Cut(rel, "/")
+	return path.Join(root, name), true
+}
+
+// UnitUsername returns the username owning a unit. Only user slices are owned by
+// a user, other units such as services and scopes have an empty username.
+func UnitUsername(cg string) (string, error) {
+	if !uidRe.MatchString(cg) {
+		return "", nil
+	}
+	return usernames.get(cg)
+}
+
+// ErrUnknownUnit is the error of a unit that is not one of the units directly
+// underneath the root matching the unit patterns
+var ErrUnknownUnit = errors.New("unknown unit")
+
+// ValidUnit checks that the unit is a unit directly underneath the root whose name
+// matches one of the patterns. The name of a unit in the path of a request arrives
+// unescaped, and must not lead outside the root, nor name a unit elsewhere on the
+// node.
+func ValidUnit(h Hierarchy, root string, unit string, patterns []string) error {
+	if unit == "" || strings.Contains(unit, "/") || strings.Contains(unit, "..") {
+		return fmt.Errorf("invalid unit name '%s': %w", unit, ErrUnknownUnit)
+	}
+	if !slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, unit)
+		return ok
+	}) {
+		return fmt.Errorf("unit %s does not match the unit patterns: %w", unit, ErrUnknownUnit)
+	}
+
+	units, err := h.Children(root)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(units, path.Join(root, unit)) {
+		return fmt.Errorf("unit %s not found underneath %s: %w", unit, root, ErrUnknownUnit)
+	}
+	return nil
+}
+
+// usernameCache keeps the usernames of units between scrapes, as looking them up
+// may query a remote identity service for every unit.
+type usernameCache struct {
+	data  map[string]string
+	hash  uint64
+	mutex sync.Mutex
+}
+
+var usernames = &usernameCache{data: make(map[string]string)}
+
+func (uc *usernameCache) get(cg string) (string, error) {
+	uc.mutex.Lock()
+	username, ok := uc.data[cg]
+	uc.mutex.Unlock()
+	if ok {
+		return username, nil
+	}
+
+	username, err := lookupUsername(cg)
+	if err != nil {
+		return "", err
+	}
+
+	uc.mutex.Lock()
+	uc.data[cg] = username
+	uc.mutex.Unlock()
+	return username, nil
+}
+
+// retain forgets the usernames of units that are not active. The hash of the
+// active units is kept, so nothing is done while the units do not change.
+func (uc *usernameCache) retain(active map[string]bool) {
+	units := slices.Sorted(maps.Keys(active))
+	h := fnv.New64a()
+	for _, unit := range units {
+		h.Write([]byte(unit))
+		h.Write([]byte{0})
+	}
+
+	defer uc.mutex.Unlock()
+	uc.mutex.Lock()
+
+	if h.Sum64() == uc.hash {
+		return
+	}
+	uc.hash = h.Sum64()
+
+	for cg := range uc.data {
+		if !active[cg] {
+			delete(uc.data, cg)
+		}
+	}
+}
+
+// RetainUsernames forgets the cached usernames of all units that are not active.
+func RetainUsernames(active map[string]bool) {
+	usernames.retain(active)
+}
+
 // lookupUsername looks up a username given the systemd user slice name.
 // If compiled with CGO, this function will call the C function getpwuid_r
 // from the standard C library; This is necessary when user identities are
@@ -58,10 +208,182 @@ func lookupUsername(slice string) (string, error) {
 		return "", fmt.Errorf("cannot determine uid from '%s'", slice)
 	}
 
-	user, err := user.LookupId(match[1])
+	return LookupUID(match[1])
+}
+
+// UnitUID returns the uid of a user slice, or false if the unit is not a user slice.
+func UnitUID(cg string) (int, bool) {
+	match := uidRe.FindStringSubmatch(cg)
+	if len(match) < 2 {
+		return 0, false
+	}
+	uid, err := strconv.Atoi(match[1])
+	return uid, err == nil
+}
+
+// LookupUID looks up the username of a uid.
+func LookupUID(uid string) (string, error) {
+	user, err := user.LookupId(uid)
 	if err != nil {
-		return "", fmt.Errorf("unable to lookup user with id '%s'", match[1])
+		return "", fmt.Errorf("unable to lookup user with id '%s'", uid)
 	}
 
 	return user.Username, nil
 }
+
+// children returns the paths of the cgroups directly underneath cg, given the
+// directory the hierarchy containing cg is mounted at.
+func children(mount string, cg string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(mount, cg))
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			groups = append(groups, path.Join(cg, entry.Name()))
+		}
+	}
+	return groups, nil
+}
+
+var outsideNamespace sync.Once
+
+// visiblePID reports whether a pid read from cgroup.procs can be counted. The
+// kernel translates pids into the pid namespace of the reader, so processes of
+// rootless containers are already reported by their host pid, but processes
+// outside the namespace of the warden are reported as 0.
+func visiblePID(pid uint64) bool {
+	if pid == 0 {
+		outsideNamespace.Do(func() {
+			slog.Warn("some processes are outside the pid namespace of the warden and are not counted, run it in the host pid namespace")
+		})
+		return false
+	}
+	return true
+}
+
+// uniquePIDs removes the pids that are not visible, and duplicates, which are
+// reported once for every threaded cgroup a process has threads in.
+func uniquePIDs(pids []uint64) []uint64 {
+	seen := make(map[uint64]bool, len(pids))
+	unique := pids[:0]
+	for _, pid := range pids {
+		if !visiblePID(pid) || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		unique = append(unique, pid)
+	}
+	return unique
+}
+
+// walkProcs reads the processes of cg and all its descendants from their
+// cgroup.procs files, given the directory the hierarchy is mounted at. Unlike
+// the cgroups library it tolerates cgroups removed during the walk, which is
+// common on busy nodes, returning the processes keyed by the path of their cgroup.
+func walkProcs(mount string, cg string) (map[string][]uint64, error) {
+	procs := make(map[string][]uint64)
+	root := path.Join(mount, cg)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p != root {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		pids, err := readProcs(path.Join(p, "cgroup.procs"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(pids) > 0 {
+			procs["/"+strings.TrimPrefix(strings.TrimPrefix(p, mount), "/")] = pids
+		}
+		return nil
+	})
+	return procs, err
+}
+
+func readProcs(file string) ([]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pids []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pid, err := strconv.ParseUint(strings.TrimSpace(scanner.Text()), 10, 64)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, scanner.Err()
+}
+
+// groupProcs groups the processes underneath the root by unit, reading their
+// cgroup.procs files directly.
+func groupProcs(mount string, root string) (map[string]map[uint64]bool, error) {
+	walked, err := walkProcs(mount, root)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make(map[string]map[uint64]bool)
+	for cg, procs := range walked {
+		group, ok := unitOf(root, cg)
+		if !ok {
+			continue
+		}
+		if pids[group] == nil {
+			pids[group] = make(map[uint64]bool)
+		}
+		for _, p := range uniquePIDs(procs) {
+			pids[group][p] = true
+		}
+	}
+	return pids, nil
+}
+
+// flattenProcs returns the processes of all cgroups returned by walkProcs
+func flattenProcs(procs map[string][]uint64) []uint64 {
+	var pids []uint64
+	for _, p := range procs {
+		pids = append(pids, p...)
+	}
+	return uniquePIDs(pids)
+}
+
+// readFlatKeyed reads a file of lines of a key and a counter, like memory.events
+func readFlatKeyed(file string) (map[string]uint64, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(buf), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		values[key] = n
+	}
+	return values, nil
+}
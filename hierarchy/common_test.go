@@ -0,0 +1,66 @@
+package hierarchy
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+// dirHierarchy lists the cgroups of a hierarchy mounted at a directory
+type dirHierarchy struct {
+	Hierarchy
+	mount string
+}
+
+func (d dirHierarchy) Children(cg string) ([]string, error) {
+	return children(d.mount, cg)
+}
+
+func TestValidUnit(t *testing.T) {
+	mount := t.TempDir()
+	for _, dir := range []string{"user.slice/user-1000.slice/session-1.scope", "user.slice/sshd.service", "system.slice"} {
+		if err := os.MkdirAll(path.Join(mount, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path.Join(mount, "user.slice", "cgroup.procs"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := dirHierarchy{mount: mount}
+
+	tests := []struct {
+		unit     string
+		patterns []string
+		valid    bool
+	}{
+		{unit: "user-1000.slice", patterns: []string{"user-*.slice"}, valid: true},
+		{unit: "user-1000.slice", patterns: []string{"*"}, valid: true},
+		{unit: "sshd.service", patterns: []string{"user-*.slice", "*.service"}, valid: true},
+		{unit: "sshd.service", patterns: []string{"user-*.slice"}},
+		{unit: "user-2000.slice", patterns: []string{"*"}},
+		{unit: "session-1.scope", patterns: []string{"*"}},
+		{unit: "cgroup.procs", patterns: []string{"*"}},
+		{unit: "", patterns: []string{"*"}},
+		{unit: "..", patterns: []string{"*"}},
+		{unit: "../system.slice", patterns: []string{"*"}},
+		{unit: "a..b.slice", patterns: []string{"*"}},
+		{unit: "user-1000.slice/session-1.scope", patterns: []string{"*"}},
+		{unit: "user-1000.slice/", patterns: []string{"*"}},
+		{unit: "/system.slice", patterns: []string{"*"}},
+		{unit: "user-1000.slice", patterns: nil},
+	}
+
+	for _, test := range tests {
+		err := ValidUnit(h, "/user.slice", test.unit, test.patterns)
+		if test.valid {
+			if err != nil {
+				t.Errorf("ValidUnit(%q, %q): %v", test.unit, test.patterns, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrUnknownUnit) {
+			t.Errorf("ValidUnit(%q, %q) = %v, expected %v", test.unit, test.patterns, err, ErrUnknownUnit)
+		}
+	}
+}
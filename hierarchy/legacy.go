@@ -1,6 +1,8 @@
 package hierarchy
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"math"
 	"os"
@@ -41,6 +43,64 @@ func (l *Legacy) SetMemoryLimits(unit string, limit int64) (int64, error) {
 	return newLimit, err
 }
 
+// Reclaim is not supported by cgroup v1, which has no memory.reclaim
+func (l *Legacy) Reclaim(unit string, amount uint64) error {
+	return fmt.Errorf("memory.reclaim requires cgroup v2: %w", errors.ErrUnsupported)
+}
+
+// Kill is not supported by cgroup v1, which has no cgroup.kill
+func (l *Legacy) Kill(unit string) error {
+	return fmt.Errorf("cgroup.kill requires cgroup v2: %w", errors.ErrUnsupported)
+}
+
+// SetSwapLimit sets the swap limit of the unit, returning the limit applied. The
+// legacy hierarchy only limits memory and swap together, so the combined limit is
+// set to the memory limit plus the swap limit.
+func (l *Legacy) SetSwapLimit(unit string, limit int64) (int64, error) {
+	cgroup := path.Join(l.Root, unit)
+	manager, err := cgroup1.Load(cgroup1.StaticPath(cgroup), cgroup1.WithHierarchy(subsystem))
+	if err != nil {
+		return -1, err
+	}
+
+	stat, err := manager.Stat(cgroup1.IgnoreNotExist)
+	if err != nil || stat == nil || stat.Memory == nil {
+		return -1, err
+	}
+	if stat.Memory.Swap == nil {
+		return -1, errors.New("swap accounting is not enabled")
+	}
+
+	combined := int64(MaxCGroupMemoryLimit)
+	if memoryMax := int64(stat.Memory.Usage.Limit); memoryMax < MaxCGroupMemoryLimit && limit < MaxCGroupMemoryLimit-memoryMax {
+		combined = memoryMax + limit
+	}
+
+	resources := &specs.LinuxResources{
+		Memory: &specs.LinuxMemory{
+			Swap: &combined,
+		},
+	}
+
+	err = manager.Update(resources)
+	if combined == MaxCGroupMemoryLimit {
+		return MaxCGroupMemoryLimit, err
+	}
+	return limit, err
+}
+
+// swapMaxLegacy returns the swap limit given the memory limit and the combined
+// memory and swap limit
+func swapMaxLegacy(memoryMax uint64, combinedMax uint64) uint64 {
+	if combinedMax >= MaxCGroupMemoryLimit || memoryMax >= MaxCGroupMemoryLimit {
+		return MaxCGroupMemoryLimit
+	}
+	if combinedMax < memoryMax {
+		return 0
+	}
+	return combinedMax - memoryMax
+}
+
 func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 
 	var pids = make(map[string]map[uint64]bool)
@@ -52,12 +112,19 @@ func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 
 	procs, err := manager.Processes(cgroup1.Cpuacct, true)
 	if err != nil {
-		return nil, err
+		slog.Debug("unable to walk cgroups, reading cgroup.procs directly", "cgroup", l.Root, "err", err)
+		return groupProcs(path.Join(cgroupRoot, "cpuacct"), l.Root)
 	}
 
 	for _, p := range procs {
-		dirs := strings.Split(p.Path, "/")
-		group := "/" + strings.Join(dirs[5:7], "/")
+		if !visiblePID(uint64(p.Pid)) {
+			continue
+		}
+
+		group, ok := unitOf(l.Root, strings.TrimPrefix(p.Path, path.Join(cgroupRoot, "cpuacct")))
+		if !ok {
+			continue
+		}
 
 		groupPids, ok := pids[group]
 		if !ok {
@@ -71,6 +138,33 @@ func (l *Legacy) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	return pids, nil
 }
 
+func (l *Legacy) Children(cg string) ([]string, error) {
+	return children(path.Join(cgroupRoot, "cpuacct"), cg)
+}
+
+func (l *Legacy) Procs(cg string) ([]uint64, error) {
+	manager, err := cgroup1.Load(cgroup1.StaticPath(cg), cgroup1.WithHierarchy(subsystem))
+	if err != nil {
+		return nil, err
+	}
+
+	procs, err := manager.Processes(cgroup1.Cpuacct, true)
+	if err != nil {
+		slog.Debug("unable to walk cgroups, reading cgroup.procs directly", "cgroup", cg, "err", err)
+		walked, err := walkProcs(path.Join(cgroupRoot, "cpuacct"), cg)
+		if err != nil {
+			return nil, err
+		}
+		return flattenProcs(walked), nil
+	}
+
+	pids := make([]uint64, 0, len(procs))
+	for _, p := range procs {
+		pids = append(pids, uint64(p.Pid))
+	}
+	return uniquePIDs(pids), nil
+}
+
 func (l *Legacy) CGroupInfo(cg string) (CGroupInfo, error) {
 	var info CGroupInfo
 
@@ -92,9 +186,14 @@ func (l *Legacy) CGroupInfo(cg string) (CGroupInfo, error) {
 	if stat.Memory != nil {
 		info.MemoryUsage = stat.Memory.TotalRSS
 		info.MemoryMax = stat.Memory.Usage.Limit
+		info.SwapMax = swapMaxLegacy(stat.Memory.Usage.Limit, stat.Memory.GetSwap().GetLimit())
+		// the swap counters of the legacy hierarchy include memory
+		if combined := stat.Memory.GetSwap().GetUsage(); combined > stat.Memory.Usage.Usage {
+			info.SwapUsage = combined - stat.Memory.Usage.Usage
+		}
 	}
 
-	username, err := lookupUsername(cg)
+	username, err := UnitUsername(cg)
 	if err != nil {
 		return info, err
 	}
@@ -103,6 +202,23 @@ func (l *Legacy) CGroupInfo(cg string) (CGroupInfo, error) {
 	return info, nil
 }
 
+// MemoryEvents returns the number of processes of the cgroup killed by the OOM
+// killer as "oom_kill", and the number of times its memory usage hit the limit as
+// "max", the closest the legacy hierarchy has to memory.events.
+func (l *Legacy) MemoryEvents(cg string) (map[string]uint64, error) {
+	dir := path.Join(cgroupRoot, "memory", cg)
+	control, err := readFlatKeyed(path.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return nil, err
+	}
+
+	events := map[string]uint64{"oom_kill": control["oom_kill"]}
+	if buf, err := os.ReadFile(path.Join(dir, "memory.failcnt")); err == nil {
+		events["max"], _ = strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	}
+	return events, nil
+}
+
 func subsystem() ([]cgroup1.Subsystem, error) {
 	s := []cgroup1.Subsystem{
 		cgroup1.NewCpuacct(cgroupRoot),
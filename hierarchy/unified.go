@@ -1,14 +1,18 @@
 package hierarchy
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"math"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/containerd/cgroups/v3/cgroup2"
+	"github.com/containerd/cgroups/v3/cgroup2/stats"
 )
 
 type Unified struct {
@@ -26,17 +30,20 @@ func (u *Unified) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 
 	procs, err := manager.Procs(true)
 	if err != nil {
-		return nil, err
+		slog.Debug("unable to walk cgroups, reading cgroup.procs directly", "cgroup", u.Root, "err", err)
+		return groupProcs(cgroupRoot, u.Root)
 	}
 
-	for _, p := range procs {
+	for _, p := range uniquePIDs(procs) {
 		path, err := cgroup2.PidGroupPath(int(p))
 		if err != nil {
 			slog.Info("could not determine cgroup of pid", "pid", p, "err", err)
 			continue
 		}
-		dirs := strings.Split(path, "/")
-		group := strings.Join(dirs[0:3], "/")
+		group, ok := unitOf(u.Root, path)
+		if !ok {
+			continue
+		}
 
 		groupPids, ok := pids[group]
 		if !ok {
@@ -50,6 +57,28 @@ func (u *Unified) GetGroupsWithPIDs() (map[string]map[uint64]bool, error) {
 	return pids, nil
 }
 
+func (u *Unified) Children(cg string) ([]string, error) {
+	return children(cgroupRoot, cg)
+}
+
+func (u *Unified) Procs(cg string) ([]uint64, error) {
+	manager, err := cgroup2.Load(cg)
+	if err != nil {
+		return nil, err
+	}
+
+	procs, err := manager.Procs(true)
+	if err != nil {
+		slog.Debug("unable to walk cgroups, reading cgroup.procs directly", "cgroup", cg, "err", err)
+		walked, err := walkProcs(cgroupRoot, cg)
+		if err != nil {
+			return nil, err
+		}
+		return flattenProcs(walked), nil
+	}
+	return uniquePIDs(procs), nil
+}
+
 func (u *Unified) CGroupInfo(cg string) (CGroupInfo, error) {
 	var info CGroupInfo
 
@@ -66,14 +95,26 @@ func (u *Unified) CGroupInfo(cg string) (CGroupInfo, error) {
 	if stat.CPU != nil {
 		info.CPUUsage = float64(stat.CPU.UsageUsec) / USPerS
 		info.CPUQuota = readCPUQuotaUnified(cg)
+		info.CPUPressure = pressureSeconds(stat.CPU.PSI)
 	}
 
 	if stat.Memory != nil {
 		info.MemoryUsage = stat.Memory.Usage
 		info.MemoryMax = stat.Memory.UsageLimit
+		info.SwapMax = stat.Memory.SwapLimit
+		info.SwapUsage = stat.Memory.SwapUsage
+		info.MemoryPressure = pressureSeconds(stat.Memory.PSI)
 	}
 
-	username, err := lookupUsername(cg)
+	if stat.Io != nil {
+		info.IOPressure = pressureSeconds(stat.Io.PSI)
+	}
+
+	info.Descendants, info.DyingDescendants = readCGroupStat(cg)
+	info.Controllers = readControllers(cg, "cgroup.controllers")
+	info.SubtreeControl = readControllers(cg, "cgroup.subtree_control")
+
+	username, err := UnitUsername(cg)
 	if err != nil {
 		return info, err
 	}
@@ -82,6 +123,60 @@ func (u *Unified) CGroupInfo(cg string) (CGroupInfo, error) {
 	return info, nil
 }
 
+// readCGroupStat reads the number of live and dying descendants from cgroup.stat
+func readCGroupStat(cg string) (uint64, uint64) {
+	buf, err := os.ReadFile(path.Join(cgroupRoot, cg, "cgroup.stat"))
+	if err != nil {
+		slog.Debug("unable to read cgroup.stat", "cgroup", cg, "err", err)
+		return 0, 0
+	}
+
+	var descendants, dying uint64
+	for _, line := range strings.Split(string(buf), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			slog.Debug("unable to parse cgroup.stat", "cgroup", cg, "key", key, "err", err)
+			continue
+		}
+
+		switch key {
+		case "nr_descendants":
+			descendants = n
+		case "nr_dying_descendants":
+			dying = n
+		}
+	}
+	return descendants, dying
+}
+
+// MemoryEvents returns the counters of memory.events of the cgroup, like "high"
+// and "oom_kill"
+func (u *Unified) MemoryEvents(cg string) (map[string]uint64, error) {
+	return readFlatKeyed(path.Join(cgroupRoot, cg, "memory.events"))
+}
+
+// readControllers reads a space separated list of controllers, like cgroup.controllers
+func readControllers(cg string, file string) []string {
+	buf, err := os.ReadFile(path.Join(cgroupRoot, cg, file))
+	if err != nil {
+		slog.Debug("unable to read controllers", "cgroup", cg, "file", file, "err", err)
+		return nil
+	}
+	return strings.Fields(string(buf))
+}
+
+func pressureSeconds(psi *stats.PSIStats) float64 {
+	if psi == nil || psi.Some == nil {
+		return 0
+	}
+	return float64(psi.Some.Total) / USPerS
+}
+
 var SwapRatio float64 = 0.1
 
 func (u *Unified) SetMemoryLimits(unit string, limit int64) (int64, error) {
@@ -109,6 +204,70 @@ func (u *Unified) SetMemoryLimits(unit string, limit int64) (int64, error) {
 	return newMax, err
 }
 
+// Reclaim asks the kernel to reclaim the amount of memory in bytes from the unit,
+// pushing its cold pages to swap. The kernel fails with EAGAIN if less than the
+// amount could be reclaimed.
+func (u *Unified) Reclaim(unit string, amount uint64) error {
+	p := path.Join(cgroupRoot, u.Root, unit, "memory.reclaim")
+	return os.WriteFile(p, []byte(strconv.FormatUint(amount, 10)), 0)
+}
+
+// Kill sends SIGKILL to every process of the unit and its descendants at once
+// through cgroup.kill, which unlike signalling each process cannot miss a process
+// forked in between. Kernels before 5.14 have no cgroup.kill.
+func (u *Unified) Kill(unit string) error {
+	if !cgroupKillSupported(path.Join(cgroupRoot, u.Root)) {
+		return fmt.Errorf("cgroup.kill requires linux 5.14: %w", errors.ErrUnsupported)
+	}
+	return os.WriteFile(path.Join(cgroupRoot, u.Root, unit, "cgroup.kill"), []byte("1"), 0)
+}
+
+var killSupport struct {
+	once      sync.Once
+	supported bool
+}
+
+// cgroupKillSupported reports whether the kernel has cgroup.kill, looking for it
+// once in the root, or in a child of the root if the root is the top cgroup,
+// which has none. A unit missing the file is a unit that is gone.
+func cgroupKillSupported(root string) bool {
+	killSupport.once.Do(func() {
+		dirs := []string{root}
+		entries, _ := os.ReadDir(root)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, path.Join(root, entry.Name()))
+				break
+			}
+		}
+		for _, dir := range dirs {
+			if _, err := os.Stat(path.Join(dir, "cgroup.kill")); err == nil {
+				killSupport.supported = true
+				return
+			}
+		}
+	})
+	return killSupport.supported
+}
+
+// SetSwapLimit sets the swap limit of the unit independently of its memory limit,
+// returning the limit applied.
+func (u *Unified) SetSwapLimit(unit string, limit int64) (int64, error) {
+	manager, err := cgroup2.Load(path.Join(u.Root, unit))
+	if err != nil {
+		return -1, err
+	}
+
+	resources := &cgroup2.Resources{
+		Memory: &cgroup2.Memory{
+			Swap: &limit,
+		},
+	}
+
+	err = manager.Update(resources)
+	return limit, err
+}
+
 func readCPUQuotaUnified(cg string) int64 {
 	cgroupPath := path.Join("/sys/fs/cgroup", cg)
 	p := path.Join(cgroupPath, "cpu.max")
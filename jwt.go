@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway given to the clocks of the issuer and the warden for the expiry and not
+// before times of a token
+const jwtLeeway = time.Minute
+
+// how often the key set is fetched again, and at most how often to look for a
+// key id the key set does not have yet
+const (
+	jwksRefresh    = time.Hour
+	jwksMinRefresh = time.Minute
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwtVerifier verifies the RS256 and ES256 tokens of an issuer, with the public
+// key of a file or the keys of a JWKS URL, granting the scopes of the scope claim
+// of a token
+type jwtVerifier struct {
+	keyFile  string
+	jwksURL  string
+	audience string
+	issuer   string
+	claim    string
+	scopes   map[string][]string
+	client   *http.Client
+
+	keys     map[string]crypto.PublicKey
+	modified time.Time
+	fetched  time.Time
+	mutex    sync.Mutex
+}
+
+// newJWTVerifier returns a verifier of tokens for the audience. Values of the
+// scope claim are granted as the scope of the same name, or the scopes mapped to
+// them by "value=scope" pairs.
+func newJWTVerifier(keyFile string, jwksURL string, audience string, issuer string, claim string, mapping []string) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		keyFile:  keyFile,
+		jwksURL:  jwksURL,
+		audience: audience,
+		issuer:   issuer,
+		claim:    claim,
+		scopes:   make(map[string][]string),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, pair := range mapping {
+		value, scope, ok := strings.Cut(pair, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid scope mapping '%s', expected value=scope", pair)
+		}
+		if !slices.Contains(scopes, scope) {
+			return nil, fmt.Errorf("invalid scope '%s' of mapping %s, expected one of %v", scope, pair, scopes)
+		}
+		v.scopes[value] = append(v.scopes[value], scope)
+	}
+
+	if keyFile != "" {
+		_, err := v.key("")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// verify returns the token of a JWT, named for its subject, if its signature,
+// audience, issuer and times are valid
+func (v *jwtVerifier) verify(raw string) (token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return token{}, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return token{}, fmt.Errorf("malformed header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return token{}, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return token{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = verifySignature(header.Alg, key, digest[:], sig)
+	if err != nil {
+		return token{}, err
+	}
+
+	var claims map[string]any
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return token{}, fmt.Errorf("malformed claims: %w", err)
+	}
+	err = v.validate(claims, time.Now())
+	if err != nil {
+		return token{}, err
+	}
+
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		name = "jwt"
+	}
+	return token{Name: name, Scopes: v.grant(claims[v.claim])}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	buf, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// verifySignature checks the signature with the key, which must be of the type
+// of the algorithm so that a token cannot choose how it is verified
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, sig []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token signed with a key that is not an RSA key")
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("ES256 token signed with a key that is not a P-256 key")
+		}
+		if len(sig) != 64 {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%s', expected RS256 or ES256", alg)
+}
+
+// validate checks the expiry, not before time, audience and issuer of the claims.
+// Tokens without an expiry are refused.
+func (v *jwtVerifier) validate(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !slices.Contains(audiences, v.audience) {
+		return fmt.Errorf("token is not for audience %s", v.audience)
+	}
+
+	if iss, _ := claims["iss"].(string); v.issuer != "" && iss != v.issuer {
+		return fmt.Errorf("token not issued by %s", v.issuer)
+	}
+	return nil
+}
+
+// grant returns the scopes of the values of the scope claim, either a list or a
+// string of values separated by spaces like the OAuth scope claim
+func (v *jwtVerifier) grant(claim any) []string {
+	var values []string
+	switch c := claim.(type) {
+	case string:
+		values = strings.Fields(c)
+	case []any:
+		for _, value := range c {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var granted []string
+	for _, value := range values {
+		if slices.Contains(scopes, value) {
+			granted = append(granted, value)
+		}
+		granted = append(granted, v.scopes[value]...)
+	}
+	slices.Sort(granted)
+	return slices.Compact(granted)
+}
+
+// key returns the public key with the key id, the key of the key file whatever
+// the id. The key file is loaded again once it changes, and the key set fetched
+// again once it is old or lacks the key id, as when the issuer rotated its keys.
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	defer v.mutex.Unlock()
+	v.mutex.Lock()
+
+	if v.keyFile != "" {
+		info, err := os.Stat(v.keyFile)
+		if err == nil && (v.keys == nil || info.ModTime().After(v.modified)) {
+			var key crypto.PublicKey
+			key, err = readPublicKey(v.keyFile)
+			if err == nil {
+				v.keys, v.modified = map[string]crypto.PublicKey{"": key}, info.ModTime()
+			}
+		}
+		if v.keys == nil {
+			return nil, err
+		}
+		if err != nil {
+			slog.Warn("unable to load jwt key, keeping the previous one", "file", v.keyFile, "err", err)
+		}
+		return v.keys[""], nil
+	}
+
+	key, ok := v.keys[kid]
+	since := time.Since(v.fetched)
+	if (!ok && since > jwksMinRefresh) || since > jwksRefresh {
+		keys, err := v.fetch()
+		if err != nil {
+			slog.Warn("unable to fetch jwks, keeping the previous keys", "url", v.jwksURL, "err", err)
+		} else {
+			v.keys = keys
+			key, ok = keys[kid]
+		}
+		v.fetched = time.Now()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	return key, nil
+}
+
+// fetch fetches the RSA and P-256 keys of the key set, by key id
+func (v *jwtVerifier) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Debug("skipping key of jwks", "url", v.jwksURL, "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	slog.Info("fetched jwks", "url", v.jwksURL, "keys", len(keys))
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// readPublicKey reads a PEM encoded public key, or the key of a certificate
+func readPublicKey(file string) (crypto.PublicKey, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unexpected %s in %s, expected a public key or certificate", block.Type, file)
+}
@@ -1,24 +1,48 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 
-	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/audit"
 	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
-func authorize(next http.Handler, secret string) http.Handler {
+// authorize only lets requests with a bearer token or JWT, or a basic auth user
+// of the web configuration file, granted the scope through to the handler,
+// attributing their changes to the name of the token
+func authorize(next http.Handler, tokens *tokenStore, scope string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != "Bearer "+secret {
+		var t token
+		var found bool
+		if user, _, ok := r.BasicAuth(); ok {
+			t, found = tokens.lookupUser(user)
+		} else if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			t, found = tokens.lookup(bearer)
+		}
+		if !found {
 			slog.Warn("unauthorized request", "address", r.RemoteAddr)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if !slices.Contains(t.Scopes, scope) {
+			slog.Warn("forbidden request", "address", r.RemoteAddr, "token", t.Name, "scope", scope)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		audit.Identify(next, t.Name).ServeHTTP(w, r)
 	})
 }
 
@@ -37,29 +61,188 @@ func updateLogLevel(level string) {
 	slog.SetLogLoggerLevel(slogLevel)
 }
 
+// subcommands, run instead of the server when given as the first argument
+var subcommands = map[string]func(args []string) int{
+	"audit":   runAudit,
+	"history": runHistory,
+	"migrate": runMigrate,
+	"policy":  runPolicy,
+}
+
+func envOr(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
 func main() {
 
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	tlsCert := flag.String("web.tls-cert", "", "path to the TLS certificate, instead of CGROUP_WARDEN_CERTIFICATE")
+	tlsKey := flag.String("web.tls-key", "", "path to the TLS private key, instead of CGROUP_WARDEN_PRIVATE_KEY")
+	webConfigFile := flag.String("web.config.file", "", "path to an exporter-toolkit web configuration file, instead of CGROUP_WARDEN_WEB_CONFIG_FILE")
+	enablePProf := flag.Bool("debug.pprof", false, "serve the pprof profiles under /debug/pprof, instead of CGROUP_WARDEN_PPROF")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	// flags take precedence over the environment the configuration is read from
+	if *tlsCert != "" {
+		os.Setenv("CGROUP_WARDEN_CERTIFICATE", *tlsCert)
+	}
+	if *tlsKey != "" {
+		os.Setenv("CGROUP_WARDEN_PRIVATE_KEY", *tlsKey)
+	}
+	if *webConfigFile != "" {
+		os.Setenv("CGROUP_WARDEN_WEB_CONFIG_FILE", *webConfigFile)
+	}
+	if *enablePProf {
+		os.Setenv("CGROUP_WARDEN_PPROF", "true")
+	}
+
 	conf, err := NewConfig()
 	if err != nil {
 		slog.Error("Unable to parse configuration", "err", err)
 		os.Exit(1)
 	}
 	updateLogLevel(conf.LogLevel)
+	metrics.SetBuildInfo(version, buildCommit())
 
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", metrics.MetricsHandler(conf.RootCGroup, conf.MetaMetrics))
-	mux.Handle("/", http.NotFoundHandler())
+	err = startCollection(conf)
+	if err != nil {
+		slog.Error("Unable to start collection", "err", err)
+		os.Exit(1)
+	}
 
-	if conf.InsecureMode {
-		mux.Handle("/control", control.ControlHandler(conf.RootCGroup))
-		slog.Info("Starting server!")
-		slog.Error("server error", "err", http.ListenAndServe(conf.ListenAddress, mux))
+	tokens, err := newTokenStore(conf.TokenFile, conf.BearerToken, conf.WebConfigFile)
+	if err != nil {
+		slog.Error("Unable to load tokens", "file", conf.TokenFile, "err", err)
 		os.Exit(1)
+	}
+	if conf.jwt() {
+		tokens.jwt, err = newJWTVerifier(conf.JWTKeyFile, conf.JWKSURL, conf.JWTAudience, conf.JWTIssuer, conf.JWTScopeClaim, conf.JWTScopeMap)
+		if err != nil {
+			slog.Error("Unable to verify JWTs", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// reading units requires a token whenever tokens are configured, while reading
+	// metrics only does once tokens are scoped, or with CGROUP_WARDEN_METRICS_AUTH
+	read := func(scope string, handler http.Handler) http.Handler {
+		required := tokens.configured()
+		if scope == scopeMetricsRead {
+			required = tokens.scoped() || conf.MetricsAuth
+		}
+		if conf.InsecureMode || !required {
+			return allowNetworks(handler, conf.MetricsAllowedNetworks)
+		}
+		return allowNetworks(authorize(handler, tokens, scope), conf.MetricsAllowedNetworks)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", read(scopeMetricsRead, metrics.MetricsHandler(conf.RootCGroup, conf.MetaMetrics)))
+	mux.Handle("GET /dashboards/{name}", read(scopeMetricsRead, metrics.DashboardHandler()))
+	mux.Handle("GET /api/v1/status", read(scopeMetricsRead, status.Handler(version)))
+	mux.Handle("GET /api/v1/unit/{name}", read(scopeUnitRead, metrics.UnitHandler(conf.RootCGroup)))
+	mux.Handle("GET /healthz", allowNetworks(healthHandler(), conf.MetricsAllowedNetworks))
+	mux.Handle("GET /readyz", allowNetworks(readyHandler(conf.RootCGroup), conf.MetricsAllowedNetworks))
+	if conf.PProf {
+		registerPProf(mux, conf, tokens)
+	}
+	mux.Handle("/", allowNetworks(http.NotFoundHandler(), conf.MetricsAllowedNetworks))
+
+	// the control api is served on the same listener unless it has its own
+	controlMux := mux
+	if conf.ControlListenAddress != "" && !conf.ReadOnly {
+		controlMux = http.NewServeMux()
+	}
 
+	if conf.ReadOnly {
+		slog.Info("Running in read-only mode, control endpoints are disabled")
 	} else {
-		mux.Handle("/control", authorize(control.ControlHandler(conf.RootCGroup), conf.BearerToken))
-		slog.Info("Starting server")
-		slog.Error("server error", "err", http.ListenAndServeTLS(conf.ListenAddress, conf.Certificate, conf.PrivateKey, mux))
+		registerControl(controlMux, conf, tokens)
+
+		err = startRemediation(conf)
+		if err != nil {
+			slog.Error("Unable to start remediation", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	restoreHandover()
+
+	controlAddress := ""
+	if controlMux != mux {
+		controlAddress = conf.ControlListenAddress
+	}
+	ln, controlLn, err := listen(conf.ListenAddress, controlAddress)
+	if err != nil {
+		slog.Error("Unable to listen", "address", conf.ListenAddress, "control_address", controlAddress, "err", err)
 		os.Exit(1)
 	}
+
+	tlsConfig, err := newTLSConfig(conf.Certificate, conf.PrivateKey, conf.ClientCAFile, conf.ClientNames)
+	if err != nil {
+		slog.Error("Unable to load certificate", "certificate", conf.Certificate, "err", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Handler: mux, TLSConfig: tlsConfig}
+	servers, listeners := []*http.Server{server}, []net.Listener{ln}
+	if controlLn != nil {
+		controlServer := &http.Server{Handler: controlMux, TLSConfig: tlsConfig}
+		servers, listeners = append(servers, controlServer), append(listeners, controlLn)
+
+		go func() {
+			slog.Info("Starting control api server", "address", controlLn.Addr().String())
+			err := serve(controlServer, controlLn, conf)
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("control api server error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	drained := upgradeOnSignal(servers, listeners)
+	notifyReady()
+	startWatchdog(context.Background())
+
+	if conf.WebConfigFile != "" {
+		slog.Info("Starting server", "web_config", conf.WebConfigFile)
+	} else if tlsConfig == nil {
+		slog.Info("Starting server!")
+	} else {
+		slog.Info("Starting server", "tls", true)
+	}
+	err = serve(server, ln, conf)
+
+	if errors.Is(err, http.ErrServerClosed) {
+		<-drained
+		slog.Info("Handed over to the upgraded process")
+		os.Exit(0)
+	}
+	slog.Error("server error", "err", err)
+	os.Exit(1)
+}
+
+// serve serves the listener over TLS if the server has a TLS configuration, and
+// with the web configuration file if there is one
+func serve(server *http.Server, ln net.Listener, conf *Config) error {
+	if conf.WebConfigFile != "" {
+		return web.Serve(ln, server, &web.FlagConfig{WebConfigFile: &conf.WebConfigFile}, slog.Default())
+	}
+	if server.TLSConfig == nil {
+		return server.Serve(ln)
+	}
+	return server.ServeTLS(ln, "", "")
 }
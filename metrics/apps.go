@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+// fields of a process classification rules can match against
+const (
+	fieldComm    = "comm"
+	fieldExe     = "exe"
+	fieldCmdline = "cmdline"
+)
+
+// AppRule classifies the processes with a field matching the expression as an app
+type AppRule struct {
+	app   string
+	field string
+	re    *regexp.Regexp
+}
+
+// ProcApps classify processes into apps, like jupyter or matlab, exported in the
+// app label of the process metrics. The first matching rule wins.
+var ProcApps []AppRule
+
+// ParseProcApps parses classification rules of the form 'app=field:regex;...',
+// where the field is one of comm, exe or cmdline.
+func ParseProcApps(spec string) ([]AppRule, error) {
+	var rules []AppRule
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		app, match, ok := strings.Cut(rule, "=")
+		if !ok || app == "" {
+			return nil, fmt.Errorf("invalid app rule '%s', expected 'app=field:regex'", rule)
+		}
+
+		field, expr, ok := strings.Cut(match, ":")
+		if !ok || (field != fieldComm && field != fieldExe && field != fieldCmdline) {
+			return nil, fmt.Errorf("invalid app rule '%s', field must be one of [%s %s %s]", rule, fieldComm, fieldExe, fieldCmdline)
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app rule '%s': %v", rule, err)
+		}
+		rules = append(rules, AppRule{app: strings.TrimSpace(app), field: field, re: re})
+	}
+	return rules, nil
+}
+
+// classify returns the app of the first rule matching the process, reading its
+// executable and command line only if a rule needs them.
+func classify(proc procfs.Proc, comm string) string {
+	var exe, cmdline *string
+
+	for _, rule := range ProcApps {
+		var value string
+		switch rule.field {
+		case fieldComm:
+			value = comm
+		case fieldExe:
+			if exe == nil {
+				e, _ := proc.Executable()
+				exe = &e
+			}
+			value = *exe
+		case fieldCmdline:
+			if cmdline == nil {
+				args, _ := proc.CmdLine()
+				c := strings.Join(args, " ")
+				cmdline = &c
+			}
+			value = *cmdline
+		}
+
+		if rule.re.MatchString(value) {
+			return rule.app
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/state"
+)
+
+// how often baselines are written to disk
+const baselineSaveInterval = 5 * time.Minute
+
+// baseline is the exponentially weighted moving average of a user's usage
+type baseline struct {
+	CPU     float64   `json:"cpu"`
+	Memory  float64   `json:"memory"`
+	Since   time.Time `json:"since"`
+	Updated time.Time `json:"updated"`
+
+	// the last cpu usage counter, to compute the usage rate between scrapes
+	lastCPU  float64
+	lastTime time.Time
+}
+
+// BaselineTracker maintains rolling per user baselines of CPU and memory usage
+// over a window of several days, persisted so they survive restarts.
+type BaselineTracker struct {
+	window time.Duration
+	warmup time.Duration
+	file   string
+	data   map[string]*baseline
+	saved  time.Time
+	mutex  sync.Mutex
+}
+
+// Baselines is updated on every scrape, baselines are not tracked if nil.
+var Baselines *BaselineTracker
+
+func NewBaselineTracker(window time.Duration, warmup time.Duration, file string) (*BaselineTracker, error) {
+	bt := &BaselineTracker{
+		window: window,
+		warmup: warmup,
+		file:   file,
+		data:   make(map[string]*baseline),
+		saved:  time.Now(),
+	}
+	err := state.ReadJSON(file, &bt.data)
+	return bt, err
+}
+
+// update folds the current usage of a user into their baseline, returning the
+// ratio of the current usage to the baseline once the baseline has warmed up.
+func (bt *BaselineTracker) update(username string, cpuSeconds float64, memory float64, now time.Time) (cpuRatio float64, memoryRatio float64, ok bool) {
+	defer bt.mutex.Unlock()
+	bt.mutex.Lock()
+
+	b, exists := bt.data[username]
+	if !exists {
+		b = &baseline{Memory: memory, Since: now, Updated: now}
+		bt.data[username] = b
+	}
+
+	// weight each sample by the time it covers, so the scrape interval does not matter
+	alpha := 1 - math.Exp(-now.Sub(b.Updated).Seconds()/bt.window.Seconds())
+
+	var cpu float64
+	hasRate := !b.lastTime.IsZero() && now.After(b.lastTime) && cpuSeconds >= b.lastCPU
+	if hasRate {
+		cpu = (cpuSeconds - b.lastCPU) / now.Sub(b.lastTime).Seconds()
+		b.CPU += alpha * (cpu - b.CPU)
+	}
+	b.Memory += alpha * (memory - b.Memory)
+	b.Updated = now
+	b.lastCPU, b.lastTime = cpuSeconds, now
+
+	if !hasRate || now.Sub(b.Since) < bt.warmup {
+		return 0, 0, false
+	}
+	return ratio(cpu, b.CPU), ratio(memory, b.Memory), true
+}
+
+func ratio(current float64, base float64) float64 {
+	if base == 0 {
+		return 0
+	}
+	return current / base
+}
+
+// persist writes the baselines to disk if the save interval has elapsed, and
+// forgets users whose baseline has not been updated for a whole window.
+func (bt *BaselineTracker) persist(now time.Time) {
+	bt.mutex.Lock()
+	if now.Sub(bt.saved) < baselineSaveInterval {
+		bt.mutex.Unlock()
+		return
+	}
+	bt.saved = now
+
+	for username, b := range bt.data {
+		if now.Sub(b.Updated) > bt.window {
+			delete(bt.data, username)
+		}
+	}
+
+	snapshot := make(map[string]baseline, len(bt.data))
+	for username, b := range bt.data {
+		snapshot[username] = *b
+	}
+	bt.mutex.Unlock()
+
+	if err := state.WriteJSON(bt.file, snapshot); err != nil {
+		slog.Warn("unable to save baselines", "file", bt.file, "err", err)
+	}
+}
+
+// flush writes the baselines to disk regardless of the save interval
+func (bt *BaselineTracker) flush() {
+	bt.mutex.Lock()
+	bt.saved = time.Time{}
+	bt.mutex.Unlock()
+	bt.persist(time.Now())
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var buildInfo = newBuildInfo("dev", "unknown")
+
+func newBuildInfo(version, commit string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, commit and go version the warden was built with",
+		ConstLabels: prometheus.Labels{
+			"version":   version,
+			"commit":    commit,
+			"goversion": runtime.Version(),
+		},
+	})
+	g.Set(1)
+	return g
+}
+
+// SetBuildInfo sets the labels of the build info metric exported alongside the cgroup metrics.
+func SetBuildInfo(version, commit string) {
+	buildInfo = newBuildInfo(version, commit)
+}
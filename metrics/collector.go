@@ -1,12 +1,22 @@
 package metrics
 
 import (
+	"context"
 	"log/slog"
+	"maps"
 	"math"
 	"net/http"
+	"path"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/authorizer"
 	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	"github.com/containerd/cgroups/v3"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -21,19 +31,38 @@ const (
 var (
 	namespace  = "cgroup_warden"
 	labels     = []string{"cgroup", "username"}
-	procLabels = []string{"cgroup", "username", "proc"}
+	procLabels = []string{"cgroup", "username", "proc", "script", "app"}
+	tagLabels  = []string{"cgroup", "username", "tag"}
+	oomdLabels = []string{"cgroup", "username", "memory_pressure", "swap"}
+
+	wchanLabels = []string{"cgroup", "username", "wchan"}
+
+	exemptLabels  = []string{"kind", "value"}
+	penaltyLabels = []string{"cgroup", "username", "tier"}
+	appliedLabels = []string{"cgroup", "username", "property", "source"}
+	ruleLabels    = []string{"cgroup", "username", "rule", "severity"}
+
+	memoryEventLabels = []string{"cgroup", "username", "event"}
+
+	controllerLabels = []string{"cgroup", "username", "controller"}
+	procModeLabels   = []string{"cgroup", "username", "proc", "script", "app", "mode"}
+	procStateLabels  = []string{"cgroup", "username", "proc", "script", "app", "state"}
+	procSchedLabels  = []string{"cgroup", "username", "proc", "script", "app", "policy", "nice"}
 )
 
 func MetricsHandler(root string, meta bool) http.HandlerFunc {
+	// the collector and its descriptors are shared by all scrapes
+	registry := prometheus.NewRegistry()
+	collector := NewCollector(root)
+	registry.MustRegister(collector, buildInfo, status.Errors, audit.Actions)
+	gatherers := prometheus.Gatherers{registry}
+	if meta {
+		gatherers = append(gatherers, prometheus.DefaultGatherer)
+	}
+	h := promhttp.HandlerFor(gatherers, handlerOpts())
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		registry := prometheus.NewRegistry()
-		collector := NewCollector(root)
-		registry.MustRegister(collector)
-		gatherers := prometheus.Gatherers{registry}
-		if meta {
-			gatherers = append(gatherers, prometheus.DefaultGatherer)
-		}
-		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
+		negotiate(r)
 		h.ServeHTTP(w, r)
 	}
 }
@@ -48,17 +77,136 @@ type Collector struct {
 	procCount   *prometheus.Desc
 	memoryMax   *prometheus.Desc
 	cpuQuota    *prometheus.Desc
+
+	procCPUMode    *prometheus.Desc
+	procCPURate    *prometheus.Desc
+	procReadBytes  *prometheus.Desc
+	procWriteBytes *prometheus.Desc
+	procReadCalls  *prometheus.Desc
+	procWriteCalls *prometheus.Desc
+	procThreads    *prometheus.Desc
+	procFDs        *prometheus.Desc
+	procSwap       *prometheus.Desc
+	procGPUMemory  *prometheus.Desc
+	procMinFaults  *prometheus.Desc
+	procMajFaults  *prometheus.Desc
+	blocked        *prometheus.Desc
+	procStates     *prometheus.Desc
+	procSched      *prometheus.Desc
+
+	procVoluntarySwitches   *prometheus.Desc
+	procInvoluntarySwitches *prometheus.Desc
+	procOldestStart         *prometheus.Desc
+
+	cpuPressure    *prometheus.Desc
+	memoryPressure *prometheus.Desc
+	ioPressure     *prometheus.Desc
+
+	descendants      *prometheus.Desc
+	dyingDescendants *prometheus.Desc
+	memoryEvents     *prometheus.Desc
+
+	silenced *prometheus.Desc
+	paused   *prometheus.Desc
+	tag      *prometheus.Desc
+
+	policyExempt     *prometheus.Desc
+	penaltyTier      *prometheus.Desc
+	penaltyRemaining *prometheus.Desc
+	violation        *prometheus.Desc
+	acknowledged     *prometheus.Desc
+	appliedLimit     *prometheus.Desc
+
+	gpuMemory      *prometheus.Desc
+	gpuUtilization *prometheus.Desc
+
+	readChars  *prometheus.Desc
+	writeChars *prometheus.Desc
+	mountRead  *prometheus.Desc
+	mountWrite *prometheus.Desc
+
+	journalLines *prometheus.Desc
+
+	oomdInfo  *prometheus.Desc
+	oomdLimit *prometheus.Desc
+	oomdKills *prometheus.Desc
+
+	controller        *prometheus.Desc
+	subtreeController *prometheus.Desc
+
+	baselineCPU    *prometheus.Desc
+	baselineMemory *prometheus.Desc
+
+	sessions   *family
+	userUnits  *family
+	containers *family
+	slurmJobs  *family
+	qosClasses *family
+	pods       *family
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.memoryUsage
 	ch <- c.cpuUsage
 	ch <- c.procCPU
+	ch <- c.procCPUMode
+	ch <- c.procCPURate
 	ch <- c.procMemory
 	ch <- c.procCount
 	ch <- c.procPSS
+	ch <- c.procSwap
+	ch <- c.procGPUMemory
+	ch <- c.procReadBytes
+	ch <- c.procWriteBytes
+	ch <- c.procReadCalls
+	ch <- c.procWriteCalls
+	ch <- c.procThreads
+	ch <- c.procFDs
+	ch <- c.procMinFaults
+	ch <- c.procMajFaults
+	ch <- c.procVoluntarySwitches
+	ch <- c.procInvoluntarySwitches
+	ch <- c.blocked
+	ch <- c.procStates
+	ch <- c.procSched
+	ch <- c.procOldestStart
 	ch <- c.memoryMax
 	ch <- c.cpuQuota
+	ch <- c.cpuPressure
+	ch <- c.memoryPressure
+	ch <- c.ioPressure
+	ch <- c.descendants
+	ch <- c.dyingDescendants
+	ch <- c.memoryEvents
+	ch <- c.silenced
+	ch <- c.paused
+	ch <- c.tag
+	ch <- c.policyExempt
+	ch <- c.penaltyTier
+	ch <- c.penaltyRemaining
+	ch <- c.appliedLimit
+	ch <- c.violation
+	ch <- c.acknowledged
+	ch <- c.gpuMemory
+	ch <- c.gpuUtilization
+	ch <- c.readChars
+	ch <- c.writeChars
+	ch <- c.mountRead
+	ch <- c.mountWrite
+	ch <- c.journalLines
+	ch <- c.oomdInfo
+	ch <- c.oomdLimit
+	ch <- c.oomdKills
+	ch <- c.controller
+	ch <- c.subtreeController
+	ch <- c.baselineCPU
+	ch <- c.baselineMemory
+	c.sessions.describe(ch)
+	c.userUnits.describe(ch)
+	c.containers.describe(ch)
+	c.slurmJobs.describe(ch)
+	c.qosClasses.describe(ch)
+	c.pods.describe(ch)
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
@@ -67,12 +215,38 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	groups, err := h.GetGroupsWithPIDs()
 	if err != nil {
 		slog.Error("could not collect cgroups with pids", "err", err)
+		status.Report(status.Collect, "", err)
 		return
 	}
 
+	var gpuProcs map[uint64]gpuProcess
+	if Collection.Enabled(GPU) {
+		gpuProcs, err = gpuProcesses()
+		if err != nil {
+			slog.Warn("unable to collect gpu processes", "err", err)
+			status.Report(status.Collect, "", err)
+		}
+	}
+
+	var conn *systemd.Conn
+	if Collection.Enabled(OOMD) || Collection.Enabled(Controllers) {
+		conn, err = systemd.NewSystemConnectionContext(context.Background())
+		if err != nil {
+			slog.Warn("unable to connect to systemd", "err", err)
+			status.Report(status.Collect, "", err)
+		} else {
+			defer conn.Close()
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	active := make(map[string]bool)
+	containerMutex := sync.Mutex{}
+	activeContainers := make(map[string]bool)
 	for cg, pids := range groups {
+		if !matchesUnitPatterns(cg) {
+			continue
+		}
 		active[cg] = true
 		wg.Add(1)
 		go func() {
@@ -81,41 +255,217 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			info, err := h.CGroupInfo(cg)
 			if err != nil {
 				slog.Warn("unable to collect group info", "cgroup", cg, "err", err)
+				status.Report(status.Collect, cg, err)
 				return
 			}
 
-			procs, err := ProcessInfo(cg, pids)
-			if err != nil {
-				slog.Warn("unable to collect process info", "cgroup", cg, "err", err)
+			if !authorizer.Collect(cg, info.Username) {
+				slog.Debug("collection not authorized", "cgroup", cg)
 				return
 			}
 
-			ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.CounterValue, info.CPUUsage, cg, info.Username)
-			ch <- prometheus.MustNewConstMetric(c.memoryMax, prometheus.GaugeValue, negativeOneIfMax(info.MemoryMax), cg, info.Username)
-			ch <- prometheus.MustNewConstMetric(c.cpuQuota, prometheus.CounterValue, float64(info.CPUQuota), cg, info.Username)
+			toggles := Collection.For(cg)
 
-			procs, err = ProcessInfo(cg, pids)
+			if Enforcement != nil {
+				c.collectEnforcement(ch, cg, info.Username)
+			}
+
+			if toggles[CGroupStats] {
+				ch <- prometheus.MustNewConstMetric(c.cpuUsage, prometheus.CounterValue, info.CPUUsage, cg, info.Username)
+				// the legacy hierarchy has no cgroup.stat to count descendants with
+				if cgroups.Mode() == cgroups.Unified {
+					ch <- prometheus.MustNewConstMetric(c.descendants, prometheus.GaugeValue, float64(info.Descendants), cg, info.Username)
+					ch <- prometheus.MustNewConstMetric(c.dyingDescendants, prometheus.GaugeValue, float64(info.DyingDescendants), cg, info.Username)
+				}
+
+				if events, err := h.MemoryEvents(cg); err == nil {
+					for event, count := range events {
+						ch <- prometheus.MustNewConstMetric(c.memoryEvents, prometheus.CounterValue, float64(count), cg, info.Username, event)
+					}
+				}
+			}
+
+			if toggles[UnitProps] {
+				ch <- prometheus.MustNewConstMetric(c.memoryMax, prometheus.GaugeValue, negativeOneIfMax(info.MemoryMax), cg, info.Username)
+				ch <- prometheus.MustNewConstMetric(c.cpuQuota, prometheus.CounterValue, float64(info.CPUQuota), cg, info.Username)
+			}
+
+			if toggles[Pressure] {
+				ch <- prometheus.MustNewConstMetric(c.cpuPressure, prometheus.CounterValue, info.CPUPressure, cg, info.Username)
+				ch <- prometheus.MustNewConstMetric(c.memoryPressure, prometheus.CounterValue, info.MemoryPressure, cg, info.Username)
+				ch <- prometheus.MustNewConstMetric(c.ioPressure, prometheus.CounterValue, info.IOPressure, cg, info.Username)
+			}
+
+			if toggles[GPU] {
+				var memory, utilization float64
+				for pid := range pids {
+					if p, ok := gpuProcs[pid]; ok {
+						memory += float64(p.memoryBytes)
+						utilization += p.utilization
+					}
+				}
+				ch <- prometheus.MustNewConstMetric(c.gpuMemory, prometheus.GaugeValue, memory, cg, info.Username)
+				ch <- prometheus.MustNewConstMetric(c.gpuUtilization, prometheus.GaugeValue, utilization, cg, info.Username)
+			}
+
+			if toggles[FSIO] {
+				total := unitIO.update(cg, pids)
+				ch <- prometheus.MustNewConstMetric(c.readChars, prometheus.CounterValue, float64(total.rchar), cg, info.Username)
+				ch <- prometheus.MustNewConstMetric(c.writeChars, prometheus.CounterValue, float64(total.wchar), cg, info.Username)
+			}
+
+			if toggles[OOMD] && conn != nil {
+				c.collectOOMD(ch, conn, cg, info.Username)
+			}
+
+			if toggles[Controllers] {
+				c.collectControllers(ch, conn, cg, info)
+			}
+
+			if Journal {
+				if lines, ok := journalLines.get(cg); ok {
+					ch <- prometheus.MustNewConstMetric(c.journalLines, prometheus.CounterValue, float64(lines), cg, info.Username)
+				}
+			}
+
+			if Baselines != nil && info.Username != "" {
+				cpuRatio, memoryRatio, ok := Baselines.update(info.Username, info.CPUUsage, float64(info.MemoryUsage), time.Now())
+				if ok {
+					ch <- prometheus.MustNewConstMetric(c.baselineCPU, prometheus.GaugeValue, cpuRatio, cg, info.Username)
+					ch <- prometheus.MustNewConstMetric(c.baselineMemory, prometheus.GaugeValue, memoryRatio, cg, info.Username)
+				}
+			}
+
+			if toggles[Sessions] {
+				c.collectSessions(ch, h, cg, info.Username)
+			}
+
+			if toggles[UserUnits] {
+				c.collectUserUnits(ch, h, cg, info.Username)
+			}
+
+			if toggles[Containers] {
+				found := make(map[string]bool)
+				c.collectContainers(ch, h, cg, info.Username, found)
+				containerMutex.Lock()
+				maps.Copy(activeContainers, found)
+				containerMutex.Unlock()
+			}
+
+			if !toggles.anyProc() {
+				// without process memory, fall back to the memory usage reported by the cgroup
+				if toggles[CGroupStats] {
+					ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, float64(info.MemoryUsage), cg, info.Username)
+				}
+				return
+			}
+
+			procs, err := ProcessInfo(cg, pids, toggles, gpuProcs)
 			if err != nil {
 				slog.Warn("unable to collect process info", "cgroup", cg, "err", err)
+				status.Report(status.Collect, cg, err)
 				return
 			}
 
-			var totalPSS float64 
+			var totalPSS float64
+			blocked := make(map[string]uint64)
 
-			for name, p := range procs {
+			for key, p := range procs {
 				totalPSS += float64(p.memoryPSSTotal)
-				ch <- prometheus.MustNewConstMetric(c.procCPU, prometheus.CounterValue, float64(p.cpuSecondsTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procMemory, prometheus.GaugeValue, float64(p.memoryBytesTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procPSS, prometheus.GaugeValue, float64(p.memoryPSSTotal), cg, info.Username, name)
-				ch <- prometheus.MustNewConstMetric(c.procCount, prometheus.GaugeValue, float64(p.count), cg, info.Username, name)
+				for wchan, count := range p.blocked {
+					blocked[wchan] += count
+				}
+				if toggles[ProcCPU] {
+					ch <- prometheus.MustNewConstMetric(c.procCPU, prometheus.CounterValue, float64(p.cpuSecondsTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procCPUMode, prometheus.CounterValue, p.userSecondsTotal, key.labels(cg, info.Username, "user")...)
+					ch <- prometheus.MustNewConstMetric(c.procCPUMode, prometheus.CounterValue, p.cpuSecondsTotal-p.userSecondsTotal, key.labels(cg, info.Username, "system")...)
+					ch <- prometheus.MustNewConstMetric(c.procCPURate, prometheus.GaugeValue, p.cpuRate, key.labels(cg, info.Username)...)
+				}
+				if toggles[ProcMemory] {
+					ch <- prometheus.MustNewConstMetric(c.procMemory, prometheus.GaugeValue, float64(p.memoryBytesTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procSwap, prometheus.GaugeValue, float64(p.swapBytesTotal), key.labels(cg, info.Username)...)
+					if ProcMemoryPSS {
+						ch <- prometheus.MustNewConstMetric(c.procPSS, prometheus.GaugeValue, float64(p.memoryPSSTotal), key.labels(cg, info.Username)...)
+					}
+				}
+				if toggles[ProcIO] {
+					ch <- prometheus.MustNewConstMetric(c.procReadBytes, prometheus.CounterValue, float64(p.readBytesTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procWriteBytes, prometheus.CounterValue, float64(p.writeBytesTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procReadCalls, prometheus.CounterValue, float64(p.readCallsTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procWriteCalls, prometheus.CounterValue, float64(p.writeCallsTotal), key.labels(cg, info.Username)...)
+				}
+				if toggles[GPU] {
+					ch <- prometheus.MustNewConstMetric(c.procGPUMemory, prometheus.GaugeValue, float64(p.gpuMemoryTotal), key.labels(cg, info.Username)...)
+				}
+				if toggles[ProcThreads] {
+					ch <- prometheus.MustNewConstMetric(c.procThreads, prometheus.GaugeValue, float64(p.threadsTotal), key.labels(cg, info.Username)...)
+				}
+				if toggles[ProcFDs] {
+					ch <- prometheus.MustNewConstMetric(c.procFDs, prometheus.GaugeValue, float64(p.fdsTotal), key.labels(cg, info.Username)...)
+				}
+				if toggles[ProcFaults] {
+					ch <- prometheus.MustNewConstMetric(c.procMinFaults, prometheus.CounterValue, float64(p.minorFaultsTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procMajFaults, prometheus.CounterValue, float64(p.majorFaultsTotal), key.labels(cg, info.Username)...)
+				}
+				for state, count := range p.states {
+					ch <- prometheus.MustNewConstMetric(c.procStates, prometheus.GaugeValue, float64(count), key.labels(cg, info.Username, state)...)
+				}
+				for sched, count := range p.scheds {
+					ch <- prometheus.MustNewConstMetric(c.procSched, prometheus.GaugeValue, float64(count), key.labels(cg, info.Username, sched.policy, strconv.Itoa(sched.nice))...)
+				}
+				if toggles[ProcAge] && p.count > 0 {
+					ch <- prometheus.MustNewConstMetric(c.procOldestStart, prometheus.GaugeValue, startTime(p.oldestStartTicks), key.labels(cg, info.Username)...)
+				}
+				if toggles[ProcSwitch] {
+					ch <- prometheus.MustNewConstMetric(c.procVoluntarySwitches, prometheus.CounterValue, float64(p.voluntarySwitchesTotal), key.labels(cg, info.Username)...)
+					ch <- prometheus.MustNewConstMetric(c.procInvoluntarySwitches, prometheus.CounterValue, float64(p.involuntarySwitchesTotal), key.labels(cg, info.Username)...)
+				}
+				ch <- prometheus.MustNewConstMetric(c.procCount, prometheus.GaugeValue, float64(p.count), key.labels(cg, info.Username)...)
 			}
 
-			ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, totalPSS, cg, info.Username)
+			for wchan, count := range blocked {
+				ch <- prometheus.MustNewConstMetric(c.blocked, prometheus.GaugeValue, float64(count), cg, info.Username, wchan)
+			}
+
+			if toggles[CGroupStats] {
+				memoryUsage := float64(info.MemoryUsage)
+				// the PSS of filtered processes is unknown
+				if toggles[ProcMemory] && ProcMemoryPSS && ProcInclude == nil && ProcExclude == nil {
+					memoryUsage = totalPSS
+				}
+				ch <- prometheus.MustNewConstMetric(c.memoryUsage, prometheus.GaugeValue, memoryUsage, cg, info.Username)
+			}
 
 		}()
 	}
+	if SlurmCGroup != "" {
+		c.collectSlurm(ch, h)
+	}
+
+	if Collection.Enabled(FSIO) {
+		c.collectNetworkMounts(ch)
+	}
+
+	if KubepodsCGroup != "" {
+		c.collectKubepods(ch, h)
+	}
+
 	wg.Wait()
+
+	if Enforcement != nil {
+		c.collectExemptions(ch)
+	}
+
 	CleanProcessCache(active)
+	hierarchy.RetainUsernames(active)
+	journalLines.clean(active)
+	oomdKills.clean(active)
+	mismatches.clean(active)
+	if Baselines != nil {
+		Baselines.persist(time.Now())
+	}
+	unitIO.clean(active)
+	containerNames.clean(activeContainers)
 }
 
 func NewCollector(root string) *Collector {
@@ -127,17 +477,129 @@ func NewCollector(root string) *Collector {
 			"Total CPU usage in seconds", labels, nil),
 		procCPU: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "cpu_usage_seconds"),
 			"Aggregate CPU usage for this process in seconds", procLabels, nil),
+		procCPURate: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "cpu_usage_cores"),
+			"CPU cores used by the running instances of this process since the previous scrape, tracked per process so it is not skewed by processes starting and exiting", procLabels, nil),
+		procCPUMode: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "cpu_mode_seconds"),
+			"Aggregate CPU usage for this process in seconds, split into time spent in user and system mode", procModeLabels, nil),
 		procMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_usage_bytes"),
 			"Aggregate memory usage for this process", procLabels, nil),
 		procCount: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "count"),
 			"Instance count of this process", procLabels, nil),
 		procPSS: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "memory_pss_bytes"),
 			"Aggregate PSS memory usage of this process", procLabels, nil),
+		procGPUMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "gpu_memory_bytes"),
+			"Aggregate GPU memory used by this process in bytes", procLabels, nil),
+		procSwap: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "swap_bytes"),
+			"Aggregate memory of this process swapped out", procLabels, nil),
+		procReadBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "read_bytes"),
+			"Aggregate bytes this process caused to be fetched from the storage layer", procLabels, nil),
+		procWriteBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "write_bytes"),
+			"Aggregate bytes this process caused to be sent to the storage layer", procLabels, nil),
+		procReadCalls: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "read_syscalls"),
+			"Aggregate read system calls of this process", procLabels, nil),
+		procWriteCalls: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "write_syscalls"),
+			"Aggregate write system calls of this process", procLabels, nil),
+		procThreads: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "threads"),
+			"Aggregate number of threads of this process", procLabels, nil),
+		procFDs: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "open_fds"),
+			"Aggregate number of open file descriptors of this process", procLabels, nil),
+		procMinFaults: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "minor_page_faults"),
+			"Aggregate minor page faults of this process, not requiring a page to be loaded from disk", procLabels, nil),
+		procMajFaults: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "major_page_faults"),
+			"Aggregate major page faults of this process, requiring a page to be loaded from disk", procLabels, nil),
+		procVoluntarySwitches: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "voluntary_context_switches"),
+			"Aggregate context switches of this process because it waited for a resource", procLabels, nil),
+		procInvoluntarySwitches: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "involuntary_context_switches"),
+			"Aggregate context switches of this process because it was preempted, indicating contention for CPU", procLabels, nil),
+		procOldestStart: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "oldest_start_time_seconds"),
+			"Start time of the oldest instance of this process since the epoch in seconds", procLabels, nil),
+		procSched: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "sched_count"),
+			"Instance count of this process with each scheduling policy and nice value", procSchedLabels, nil),
+		procStates: prometheus.NewDesc(prometheus.BuildFQName(namespace, "proc", "state_count"),
+			"Instance count of this process in each state", procStateLabels, nil),
+		blocked: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "blocked_processes"),
+			"Number of processes of this unit in uninterruptible sleep, by the kernel function they are waiting in", wchanLabels, nil),
 		memoryMax: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "max"),
 			"Maximum memory limit of this unit in bytes.", labels, nil),
 		cpuQuota: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cpu", "quota"),
 			"Maximum CPU quota of this unit in micro seconds per second", labels, nil),
+		cpuPressure: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cpu", "pressure_seconds"),
+			"Total time in seconds some tasks of this unit were stalled waiting on CPU", labels, nil),
+		memoryPressure: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "pressure_seconds"),
+			"Total time in seconds some tasks of this unit were stalled waiting on memory", labels, nil),
+		ioPressure: prometheus.NewDesc(prometheus.BuildFQName(namespace, "io", "pressure_seconds"),
+			"Total time in seconds some tasks of this unit were stalled waiting on IO", labels, nil),
+		descendants: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cgroup", "descendants"),
+			"Number of live descendant cgroups of this unit", labels, nil),
+		dyingDescendants: prometheus.NewDesc(prometheus.BuildFQName(namespace, "cgroup", "dying_descendants"),
+			"Number of dying descendant cgroups of this unit, which are removed but still held by the kernel", labels, nil),
+		memoryEvents: prometheus.NewDesc(prometheus.BuildFQName(namespace, "memory", "events_total"),
+			"Number of times each event of memory.events occurred in this unit, like oom_kill", memoryEventLabels, nil),
+		silenced: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "silenced"),
+			"Whether notifications and enforcement are silenced for this unit", labels, nil),
+		paused: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "paused"),
+			"Whether the policy is paused from penalizing this unit", labels, nil),
+		tag: prometheus.NewDesc(prometheus.BuildFQName(namespace, "unit", "tag"),
+			"A metric with a constant '1' value for each tag of this unit", tagLabels, nil),
+		policyExempt: prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy", "exempt"),
+			"A metric with a constant '1' value for each entry of the exempt list of the policy", exemptLabels, nil),
+		penaltyTier: prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy", "penalty_tier"),
+			"Penalty tier of this unit, where 0 is not penalized", penaltyLabels, nil),
+		penaltyRemaining: prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy", "penalty_remaining_seconds"),
+			"Seconds until this unit steps down a penalty tier if its usage stays below the thresholds", penaltyLabels, nil),
+		appliedLimit: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "applied_limit"),
+			"A metric with a constant '1' value for each property of this unit currently set by the warden, by the source of its value", appliedLabels, nil),
+		violation: prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy", "violation"),
+			"A metric with a constant '1' value for each rule of the policy this unit is violating", ruleLabels, nil),
+		acknowledged: prometheus.NewDesc(prometheus.BuildFQName(namespace, "policy", "violation_acknowledged"),
+			"A metric with a constant '1' value for each violation of this unit acknowledged by an operator", ruleLabels, nil),
+		gpuMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "gpu", "memory_bytes"),
+			"GPU memory used by the processes of this unit in bytes", labels, nil),
+		gpuUtilization: prometheus.NewDesc(prometheus.BuildFQName(namespace, "gpu", "utilization"),
+			"GPU streaming multiprocessor utilization of the processes of this unit, summed over GPUs, where 1 is one fully utilized GPU", labels, nil),
+		readChars: prometheus.NewDesc(prometheus.BuildFQName(namespace, "io", "read_chars"),
+			"Characters read by the processes of this unit, including reads from network filesystems", labels, nil),
+		writeChars: prometheus.NewDesc(prometheus.BuildFQName(namespace, "io", "write_chars"),
+			"Characters written by the processes of this unit, including writes to network filesystems", labels, nil),
+		journalLines: prometheus.NewDesc(prometheus.BuildFQName(namespace, "journal", "lines"),
+			"Number of lines logged to the journal by this unit since the warden started", labels, nil),
+		oomdInfo: prometheus.NewDesc(prometheus.BuildFQName(namespace, "oomd", "info"),
+			"A metric with a constant '1' value labeled by the ManagedOOMMemoryPressure and ManagedOOMSwap settings of this unit", oomdLabels, nil),
+		oomdLimit: prometheus.NewDesc(prometheus.BuildFQName(namespace, "oomd", "memory_pressure_limit"),
+			"The ManagedOOMMemoryPressureLimit of this unit as a ratio, 0 if the systemd-oomd default is used", labels, nil),
+		oomdKills: prometheus.NewDesc(prometheus.BuildFQName(namespace, "oomd", "kills"),
+			"Number of cgroups of this unit killed by systemd-oomd since the warden started, requires following the journal", labels, nil),
+		controller: prometheus.NewDesc(prometheus.BuildFQName(namespace, "controller", "enabled"),
+			"Whether the controller is available to this unit, as listed in cgroup.controllers", controllerLabels, nil),
+		subtreeController: prometheus.NewDesc(prometheus.BuildFQName(namespace, "controller", "subtree_enabled"),
+			"Whether the controller is enabled for the children of this unit, as listed in cgroup.subtree_control", controllerLabels, nil),
+		baselineCPU: prometheus.NewDesc(prometheus.BuildFQName(namespace, "baseline", "cpu_ratio"),
+			"Ratio of the current CPU usage of this user to their own rolling baseline", labels, nil),
+		baselineMemory: prometheus.NewDesc(prometheus.BuildFQName(namespace, "baseline", "memory_ratio"),
+			"Ratio of the current memory usage of this user to their own rolling baseline", labels, nil),
+		mountRead: prometheus.NewDesc(prometheus.BuildFQName(namespace, "netfs", "read_bytes"),
+			"Bytes read through this NFS or Lustre mount by all processes of the node", []string{"mount", "fstype"}, nil),
+		mountWrite: prometheus.NewDesc(prometheus.BuildFQName(namespace, "netfs", "write_bytes"),
+			"Bytes written through this NFS or Lustre mount by all processes of the node", []string{"mount", "fstype"}, nil),
+		sessions:   newFamily("session", "session"),
+		userUnits:  newFamily("user_unit", "unit"),
+		containers: newFamily("container", "runtime", "container_id", "container_name"),
+		slurmJobs:  newFamily("slurm_job", "jobid", "stepid"),
+		qosClasses: newFamily("kube_qos", "qos"),
+		pods:       newFamily("kube_pod", "qos", "pod_uid", "pod", "namespace"),
+	}
+}
+
+// UnitPatterns restricts collection to the units whose name matches one of these patterns.
+var UnitPatterns = []string{"*"}
+
+func matchesUnitPatterns(cg string) bool {
+	for _, pattern := range UnitPatterns {
+		if ok, _ := path.Match(pattern, path.Base(cg)); ok {
+			return true
+		}
 	}
+	return false
 }
 
 // max memory value is a maxint64 rounded down to the nearest page number
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maximum depth walked underneath a unit looking for container scopes, enough to
+// reach rootless containers in user@UID.service/user.slice/
+const containerDepth = 4
+
+var containerRe = regexp.MustCompile(`^(docker|libpod|crio|cri-containerd)-([0-9a-f]{12,})\.scope$`)
+
+// container runtime names, as reported in the runtime label
+var runtimes = map[string]string{
+	"docker":         "docker",
+	"libpod":         "podman",
+	"crio":           "cri-o",
+	"cri-containerd": "containerd",
+}
+
+// ContainerNames enables resolving container ids to names by querying the runtime.
+var ContainerNames = false
+
+type containerNameCache struct {
+	data  map[string]string
+	mutex sync.Mutex
+}
+
+var containerNames = &containerNameCache{data: make(map[string]string)}
+
+func (cc *containerNameCache) get(runtime string, id string) string {
+	cc.mutex.Lock()
+	name, ok := cc.data[id]
+	cc.mutex.Unlock()
+	if ok {
+		return name
+	}
+
+	name = inspectContainerName(runtime, id)
+
+	cc.mutex.Lock()
+	cc.data[id] = name
+	cc.mutex.Unlock()
+	return name
+}
+
+func (cc *containerNameCache) clean(active map[string]bool) {
+	defer cc.mutex.Unlock()
+	cc.mutex.Lock()
+	for id := range cc.data {
+		if !active[id] {
+			delete(cc.data, id)
+		}
+	}
+}
+
+// inspectContainerName asks the runtime's command line tool for the name of the
+// container, returning an empty name if the runtime does not know the container.
+func inspectContainerName(runtime string, id string) string {
+	var cmd []string
+	switch runtime {
+	case "docker", "podman":
+		cmd = []string{runtime, "inspect", "--format", "{{.Name}}", id}
+	case "cri-o", "containerd":
+		cmd = []string{"crictl", "inspect", "--output", "go-template", "--template", "{{.status.metadata.name}}", id}
+	default:
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, cmd[0], cmd[1:]...).Output()
+	if err != nil {
+		slog.Debug("unable to resolve container name", "runtime", runtime, "id", id, "err", err)
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "/")
+}
+
+// collectContainers emits metrics for every container scope found underneath the unit
+func (c *Collector) collectContainers(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, cg string, username string, active map[string]bool) {
+	c.walkContainers(ch, h, cg, cg, username, active, 1)
+}
+
+func (c *Collector) walkContainers(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, unit string, cg string, username string, active map[string]bool, depth int) {
+	children, err := h.Children(cg)
+	if err != nil {
+		slog.Debug("unable to list container scopes", "cgroup", cg, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		match := containerRe.FindStringSubmatch(path.Base(child))
+		if match == nil {
+			if depth < containerDepth {
+				c.walkContainers(ch, h, unit, child, username, active, depth+1)
+			}
+			continue
+		}
+
+		runtime, id := runtimes[match[1]], match[2]
+		active[id] = true
+
+		var name string
+		if ContainerNames {
+			name = containerNames.get(runtime, id)
+		}
+		c.containers.collect(ch, h, unit, child, username, runtime, id, name)
+	}
+}
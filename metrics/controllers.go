@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the systemd accounting property of each controller
+var accountingProperties = map[string]string{
+	"cpu":    "CPUAccounting",
+	"memory": "MemoryAccounting",
+	"io":     "IOAccounting",
+	"pids":   "TasksAccounting",
+}
+
+// controllers exported per unit, in order
+var exportedControllers = []string{"cpu", "memory", "io", "pids"}
+
+func (c *Collector) collectControllers(ch chan<- prometheus.Metric, conn *systemd.Conn, cg string, info hierarchy.CGroupInfo) {
+	// the controllers are unknown on the legacy hierarchy
+	if info.Controllers == nil {
+		return
+	}
+
+	for _, controller := range exportedControllers {
+		enabled, subtree := 0.0, 0.0
+		if slices.Contains(info.Controllers, controller) {
+			enabled = 1
+		}
+		if slices.Contains(info.SubtreeControl, controller) {
+			subtree = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.controller, prometheus.GaugeValue, enabled, cg, info.Username, controller)
+		ch <- prometheus.MustNewConstMetric(c.subtreeController, prometheus.GaugeValue, subtree, cg, info.Username, controller)
+	}
+
+	if conn == nil {
+		return
+	}
+
+	props, err := unitProperties(context.Background(), conn, cg)
+	if err != nil {
+		slog.Debug("unable to read accounting properties", "cgroup", cg, "err", err)
+		status.Report(status.Collect, cg, err)
+		return
+	}
+
+	for _, controller := range exportedControllers {
+		accounting, _ := props[accountingProperties[controller]].(bool)
+		if accounting && !slices.Contains(info.Controllers, controller) {
+			mismatches.warn(cg, controller)
+		}
+	}
+}
+
+// mismatchLog remembers the units already warned about, so the warning about a
+// unit with accounting enabled but the controller missing is only logged once.
+type mismatchLog struct {
+	data  map[string]map[string]bool
+	mutex sync.Mutex
+}
+
+var mismatches = &mismatchLog{data: make(map[string]map[string]bool)}
+
+func (ml *mismatchLog) warn(cg string, controller string) {
+	defer ml.mutex.Unlock()
+	ml.mutex.Lock()
+
+	if ml.data[cg] == nil {
+		ml.data[cg] = make(map[string]bool)
+	}
+	if ml.data[cg][controller] {
+		return
+	}
+	ml.data[cg][controller] = true
+
+	slog.Warn("accounting is enabled but the controller is not available to the unit, its metrics will be zero",
+		"cgroup", cg, "controller", controller, "property", accountingProperties[controller])
+}
+
+func (ml *mismatchLog) clean(active map[string]bool) {
+	defer ml.mutex.Unlock()
+	ml.mutex.Lock()
+	for cg := range ml.data {
+		if !active[cg] {
+			delete(ml.data, cg)
+		}
+	}
+}
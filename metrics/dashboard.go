@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type dashboardTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type dashboardPanel struct {
+	ID         int               `json:"id"`
+	Type       string            `json:"type"`
+	Title      string            `json:"title"`
+	Datasource map[string]string `json:"datasource"`
+	GridPos    map[string]int    `json:"gridPos"`
+	FieldConf  map[string]any    `json:"fieldConfig"`
+	Targets    []dashboardTarget `json:"targets"`
+}
+
+type dashboardVariable struct {
+	Name       string            `json:"name"`
+	Label      string            `json:"label"`
+	Type       string            `json:"type"`
+	Query      any               `json:"query"`
+	Datasource map[string]string `json:"datasource,omitempty"`
+	Refresh    int               `json:"refresh,omitempty"`
+}
+
+type dashboard struct {
+	UID           string           `json:"uid"`
+	Title         string           `json:"title"`
+	Tags          []string         `json:"tags"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Time          map[string]any   `json:"time"`
+	Templating    map[string]any   `json:"templating"`
+	Panels        []dashboardPanel `json:"panels"`
+}
+
+// panel is a single graph of a dashboard, shown only if its metric group is collected
+type panel struct {
+	group  string
+	title  string
+	unit   string
+	expr   string
+	legend string
+}
+
+// procMemoryPSS is the group of panels of the PSS of processes, which is collected
+// with the process memory group only if ProcMemoryPSS is enabled
+const procMemoryPSS = ProcMemory + "-pss"
+
+var dashboards = map[string]struct {
+	title  string
+	byUser bool
+	panels []panel
+}{
+	"overview": {
+		title: "Unit Overview",
+		panels: []panel{
+			{CGroupStats, "CPU usage", "short", `sum by (username) (rate({ns}_cpu_usage_seconds[5m]))`, "{{username}}"},
+			{CGroupStats, "Memory usage", "bytes", `sum by (username) ({ns}_memory_usage_bytes)`, "{{username}}"},
+			{UnitProps, "Memory limit", "bytes", `{ns}_memory_max > 0`, "{{username}}"},
+			{UnitProps, "CPU quota", "short", `{ns}_cpu_quota > 0`, "{{username}}"},
+			{Pressure, "CPU pressure", "percentunit", `rate({ns}_cpu_pressure_seconds[5m])`, "{{username}}"},
+			{Pressure, "Memory pressure", "percentunit", `rate({ns}_memory_pressure_seconds[5m])`, "{{username}}"},
+			{Pressure, "IO pressure", "percentunit", `rate({ns}_io_pressure_seconds[5m])`, "{{username}}"},
+			{CGroupStats, "Memory events", "short", `sum by (event) (increase({ns}_memory_events_total[5m]))`, "{{event}}"},
+			{CGroupStats, "Dying descendant cgroups", "short", `{ns}_cgroup_dying_descendants > 0`, "{{username}}"},
+			{GPU, "GPU utilization", "short", `sum by (username) ({ns}_gpu_utilization)`, "{{username}}"},
+			{GPU, "GPU memory", "bytes", `sum by (username) ({ns}_gpu_memory_bytes)`, "{{username}}"},
+			{FSIO, "Bytes read", "Bps", `sum by (username) (rate({ns}_io_read_chars[5m]))`, "{{username}}"},
+			{FSIO, "Bytes written", "Bps", `sum by (username) (rate({ns}_io_write_chars[5m]))`, "{{username}}"},
+			{FSIO, "Network filesystem traffic", "Bps", `rate({ns}_netfs_read_bytes[5m]) or -rate({ns}_netfs_write_bytes[5m])`, "{{mount}}"},
+			{OOMD, "systemd-oomd kills", "short", `sum by (username) (increase({ns}_oomd_kills[1h])) > 0`, "{{username}}"},
+			{"", "Warden errors", "short", `sum by (component, kind) (rate({ns}_errors[5m]))`, "{{component}} {{kind}}"},
+		},
+	},
+	"user": {
+		title:  "User Drilldown",
+		byUser: true,
+		panels: []panel{
+			{CGroupStats, "CPU usage", "short", `rate({ns}_cpu_usage_seconds{username="$username"}[5m])`, "{{cgroup}}"},
+			{CGroupStats, "Memory usage", "bytes", `{ns}_memory_usage_bytes{username="$username"}`, "{{cgroup}}"},
+			{ProcCPU, "CPU usage by process", "short", `sum by (proc) (rate({ns}_proc_cpu_usage_seconds{username="$username"}[5m]))`, "{{proc}}"},
+			{ProcMemory, "Memory usage by process", "bytes", `sum by (proc) ({ns}_proc_memory_usage_bytes{username="$username"})`, "{{proc}}"},
+			{procMemoryPSS, "Proportional memory usage by process", "bytes", `sum by (proc) ({ns}_proc_memory_pss_bytes{username="$username"})`, "{{proc}}"},
+			{ProcMemory, "Swap usage by process", "bytes", `sum by (proc) ({ns}_proc_swap_bytes{username="$username"}) > 0`, "{{proc}}"},
+			{Sessions, "Memory usage by session", "bytes", `{ns}_session_memory_usage_bytes{username="$username"}`, "session {{session}}"},
+			{UserUnits, "Memory usage by user unit", "bytes", `{ns}_user_unit_memory_usage_bytes{username="$username"}`, "{{unit}}"},
+			{Pressure, "Memory pressure", "percentunit", `rate({ns}_memory_pressure_seconds{username="$username"}[5m])`, "{{cgroup}}"},
+			{Pressure, "IO pressure", "percentunit", `rate({ns}_io_pressure_seconds{username="$username"}[5m])`, "{{cgroup}}"},
+			{GPU, "GPU utilization", "short", `{ns}_gpu_utilization{username="$username"}`, "{{cgroup}}"},
+			{FSIO, "Bytes read and written", "Bps", `rate({ns}_io_read_chars{username="$username"}[5m]) or -rate({ns}_io_write_chars{username="$username"}[5m])`, "{{cgroup}}"},
+			{"", "Policy violations", "short", `{ns}_policy_violation{username="$username"}`, "{{rule}} ({{severity}})"},
+			{"", "Penalty tier", "short", `max by (cgroup) ({ns}_policy_penalty_tier{username="$username"})`, "{{cgroup}}"},
+		},
+	},
+	"enforcement": {
+		title: "Enforcement Activity",
+		panels: []panel{
+			{"", "Enforcement actions", "short", `sum by (action, actor) (increase({ns}_enforcement_actions[5m]))`, "{{action}} by {{actor}}"},
+			{"", "Penalized units by tier", "short", `count by (tier) ({ns}_policy_penalty_tier > 0)`, "{{tier}}"},
+			{"", "Policy violations by rule", "short", `count by (rule, severity) ({ns}_policy_violation)`, "{{rule}} ({{severity}})"},
+			{"", "Acknowledged violations", "short", `count by (rule) ({ns}_policy_violation_acknowledged)`, "{{rule}}"},
+			{"", "Limits set by the warden", "short", `count by (source) ({ns}_applied_limit)`, "{{source}}"},
+			{"", "Silenced units", "short", `count({ns}_silenced) or vector(0)`, "silenced"},
+			{"", "Paused units", "short", `count({ns}_paused) or vector(0)`, "paused"},
+			{"", "Policy exemptions", "short", `count by (kind) ({ns}_policy_exempt)`, "{{kind}}"},
+			{UnitProps, "Units with a memory limit", "short", `count({ns}_memory_max > 0) or vector(0)`, "limited"},
+			{UnitProps, "Units with a CPU quota", "short", `count({ns}_cpu_quota > 0) or vector(0)`, "limited"},
+			{"", "Enforcement errors", "short", `sum by (component, kind) (rate({ns}_errors{component=~"control|remediation"}[5m]))`, "{{component}} {{kind}}"},
+		},
+	},
+}
+
+// shown reports whether the panels of the group are collected
+func shown(group string) bool {
+	switch group {
+	case "":
+		return true
+	case procMemoryPSS:
+		return ProcMemoryPSS && Collection.Enabled(ProcMemory)
+	}
+	return Collection.Enabled(group)
+}
+
+func buildDashboard(name string) (dashboard, bool) {
+	def, ok := dashboards[name]
+	if !ok {
+		return dashboard{}, false
+	}
+
+	datasource := map[string]string{"type": "prometheus", "uid": "${datasource}"}
+	variables := []dashboardVariable{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+	}
+	if def.byUser {
+		variables = append(variables, dashboardVariable{
+			Name:       "username",
+			Label:      "User",
+			Type:       "query",
+			Query:      fmt.Sprintf("label_values(%s_cpu_usage_seconds, username)", namespace),
+			Datasource: datasource,
+			Refresh:    2,
+		})
+	}
+
+	d := dashboard{
+		UID:           namespace + "-" + name,
+		Title:         "cgroup-warden / " + def.title,
+		Tags:          []string{"cgroup-warden"},
+		SchemaVersion: 39,
+		Time:          map[string]any{"from": "now-6h", "to": "now"},
+		Templating:    map[string]any{"list": variables},
+	}
+
+	for _, p := range def.panels {
+		if !shown(p.group) {
+			continue
+		}
+
+		n := len(d.Panels)
+		d.Panels = append(d.Panels, dashboardPanel{
+			ID:         n + 1,
+			Type:       "timeseries",
+			Title:      p.title,
+			Datasource: datasource,
+			GridPos:    map[string]int{"x": (n % 2) * 12, "y": (n / 2) * 8, "w": 12, "h": 8},
+			FieldConf:  map[string]any{"defaults": map[string]string{"unit": p.unit}},
+			Targets: []dashboardTarget{{
+				Expr:         strings.ReplaceAll(p.expr, "{ns}", namespace),
+				LegendFormat: p.legend,
+				RefID:        "A",
+			}},
+		})
+	}
+
+	return d, true
+}
+
+// DashboardHandler serves Grafana dashboards matching the metric groups currently
+// collected. The dashboard is chosen by the 'name' path value, one of 'overview',
+// 'user' or 'enforcement'.
+func DashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := buildDashboard(r.PathValue("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Penalty is the penalty tier a unit is held at
+type Penalty struct {
+	Tier int
+	Name string
+
+	// how long until the unit steps down a tier if its usage stays below the
+	// thresholds
+	Remaining time.Duration
+}
+
+// AppliedLimit is a property of a unit set by the warden, by the source of its
+// value
+type AppliedLimit struct {
+	Property string
+	Source   string
+}
+
+// Violation is a rule of the policy a unit is violating
+type Violation struct {
+	Rule         string
+	Severity     string
+	Acknowledged bool
+}
+
+// Exemption is an entry of the exempt list of the policy, like a uid range or a
+// unit
+type Exemption struct {
+	Kind  string
+	Value string
+}
+
+// EnforcementState reports what the warden is currently doing to units, to be
+// exported alongside their usage.
+type EnforcementState interface {
+	// Silenced returns whether notifications and enforcement are silenced for the unit
+	Silenced(cg string, username string) bool
+
+	// Paused returns whether the policy is paused from penalizing the unit
+	Paused(cg string, username string) bool
+
+	// Tags returns the tags of the unit
+	Tags(cg string) []string
+
+	// Penalty returns the penalty of the unit, if the policy penalizes it
+	Penalty(cg string) (Penalty, bool)
+
+	// AppliedLimits returns the properties of the unit set by the policy, by pins
+	// and through the control api
+	AppliedLimits(cg string) []AppliedLimit
+
+	// Violations returns the rules the unit is violating, sorted by name
+	Violations(cg string) []Violation
+
+	// Exemptions returns the exempt list of the policy
+	Exemptions() []Exemption
+}
+
+// Enforcement is the state of enforcement exported with the metrics of units. It
+// is set by builds able to enforce, so that collection depends on neither the
+// policy nor the control api, and read-only builds export no enforcement state.
+var Enforcement EnforcementState
+
+// collectEnforcement exports whether the unit is silenced or paused, its tags, its
+// penalty, the limits set by the warden and its violations
+func (c *Collector) collectEnforcement(ch chan<- prometheus.Metric, cg string, username string) {
+	if Enforcement.Silenced(cg, username) {
+		ch <- prometheus.MustNewConstMetric(c.silenced, prometheus.GaugeValue, 1, cg, username)
+	}
+	if Enforcement.Paused(cg, username) {
+		ch <- prometheus.MustNewConstMetric(c.paused, prometheus.GaugeValue, 1, cg, username)
+	}
+
+	for _, tag := range Enforcement.Tags(cg) {
+		ch <- prometheus.MustNewConstMetric(c.tag, prometheus.GaugeValue, 1, cg, username, tag)
+	}
+
+	if penalty, ok := Enforcement.Penalty(cg); ok {
+		ch <- prometheus.MustNewConstMetric(c.penaltyTier, prometheus.GaugeValue, float64(penalty.Tier), cg, username, penalty.Name)
+		ch <- prometheus.MustNewConstMetric(c.penaltyRemaining, prometheus.GaugeValue, penalty.Remaining.Seconds(), cg, username, penalty.Name)
+	}
+
+	for _, limit := range Enforcement.AppliedLimits(cg) {
+		ch <- prometheus.MustNewConstMetric(c.appliedLimit, prometheus.GaugeValue, 1, cg, username, limit.Property, limit.Source)
+	}
+
+	for _, v := range Enforcement.Violations(cg) {
+		ch <- prometheus.MustNewConstMetric(c.violation, prometheus.GaugeValue, 1, cg, username, v.Rule, v.Severity)
+		if v.Acknowledged {
+			ch <- prometheus.MustNewConstMetric(c.acknowledged, prometheus.GaugeValue, 1, cg, username, v.Rule, v.Severity)
+		}
+	}
+}
+
+// collectExemptions exports the exempt list of the policy
+func (c *Collector) collectExemptions(ch chan<- prometheus.Metric) {
+	for _, x := range Enforcement.Exemptions() {
+		ch <- prometheus.MustNewConstMetric(c.policyExempt, prometheus.GaugeValue, 1, x.Kind, x.Value)
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// exposition formats that can be offered to scrapers
+const (
+	FormatText        = "text"
+	FormatProtobuf    = "protobuf"
+	FormatOpenMetrics = "openmetrics"
+)
+
+var ExpositionFormats = []string{FormatText, FormatProtobuf, FormatOpenMetrics}
+
+// Formats are the exposition formats negotiated with scrapers.
+var Formats = []string{FormatText, FormatProtobuf}
+
+// ErrorHandling determines whether a scrape fails or continues when a metric cannot be gathered.
+var ErrorHandling = promhttp.HTTPErrorOnError
+
+// ParseErrorHandling parses the error handling mode, either 'http' or 'continue'.
+func ParseErrorHandling(mode string) (promhttp.HandlerErrorHandling, error) {
+	switch strings.ToLower(mode) {
+	case "http":
+		return promhttp.HTTPErrorOnError, nil
+	case "continue":
+		return promhttp.ContinueOnError, nil
+	}
+	return promhttp.HTTPErrorOnError, fmt.Errorf("invalid error handling '%s'. Options include [http continue]", mode)
+}
+
+// ValidateFormats checks that the formats are known and text is always offered,
+// as it is the format promhttp falls back to.
+func ValidateFormats(formats []string) error {
+	for _, f := range formats {
+		if !slices.Contains(ExpositionFormats, f) {
+			return fmt.Errorf("invalid exposition format '%s'. Options include %v", f, ExpositionFormats)
+		}
+	}
+	if !slices.Contains(formats, FormatText) {
+		return fmt.Errorf("exposition format '%s' is required", FormatText)
+	}
+	return nil
+}
+
+// negotiate removes the media types of disabled formats from the Accept header,
+// leaving promhttp to negotiate among the remaining ones.
+func negotiate(r *http.Request) {
+	if slices.Contains(Formats, FormatProtobuf) {
+		return
+	}
+
+	accept := r.Header.Values("Accept")
+	var kept []string
+	for _, header := range accept {
+		for _, part := range strings.Split(header, ",") {
+			if !strings.Contains(part, "application/vnd.google.protobuf") {
+				kept = append(kept, strings.TrimSpace(part))
+			}
+		}
+	}
+
+	r.Header.Del("Accept")
+	if len(kept) > 0 {
+		r.Header.Set("Accept", strings.Join(kept, ","))
+	}
+}
+
+func handlerOpts() promhttp.HandlerOpts {
+	return promhttp.HandlerOpts{
+		ErrorHandling:     ErrorHandling,
+		EnableOpenMetrics: slices.Contains(Formats, FormatOpenMetrics),
+	}
+}
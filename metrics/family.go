@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"log/slog"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// family describes the metrics of cgroups nested underneath a monitored unit,
+// which are distinguished from the unit by additional labels.
+type family struct {
+	cpuUsage    *prometheus.Desc
+	memoryUsage *prometheus.Desc
+	memoryMax   *prometheus.Desc
+}
+
+func newFamily(subsystem string, extraLabels ...string) *family {
+	familyLabels := append(append([]string{}, labels...), extraLabels...)
+	return &family{
+		cpuUsage: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "cpu_usage_seconds"),
+			"Total CPU usage in seconds", familyLabels, nil),
+		memoryUsage: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "memory_usage_bytes"),
+			"Total memory usage in bytes", familyLabels, nil),
+		memoryMax: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "memory_max"),
+			"Maximum memory limit in bytes", familyLabels, nil),
+	}
+}
+
+func (f *family) describe(ch chan<- *prometheus.Desc) {
+	ch <- f.cpuUsage
+	ch <- f.memoryUsage
+	ch <- f.memoryMax
+}
+
+// collect emits the metrics of the nested cgroup cg, labeled by the cgroup of the
+// unit it belongs to, the username, and the values of the family's extra labels.
+func (f *family) collect(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, unit string, cg string, username string, extra ...string) {
+	info, err := h.CGroupInfo(cg)
+	if err != nil {
+		slog.Debug("unable to collect nested group info", "cgroup", cg, "err", err)
+		return
+	}
+
+	values := append([]string{unit, username}, extra...)
+	ch <- prometheus.MustNewConstMetric(f.cpuUsage, prometheus.CounterValue, info.CPUUsage, values...)
+	ch <- prometheus.MustNewConstMetric(f.memoryUsage, prometheus.GaugeValue, float64(info.MemoryUsage), values...)
+	ch <- prometheus.MustNewConstMetric(f.memoryMax, prometheus.GaugeValue, negativeOneIfMax(info.MemoryMax), values...)
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// Per unit network filesystem traffic is not reported by Lustre or NFS clients,
+// so it is approximated by the characters read and written by the unit's
+// processes, which include IO to network filesystems, unlike the bytes read and
+// written to block devices reported by the cgroup.
+
+type ioSample struct {
+	rchar uint64
+	wchar uint64
+}
+
+// ioAccumulator keeps the characters read and written by the processes of a
+// unit monotonic, by adding those of exited processes to a base value.
+type ioAccumulator struct {
+	base ioSample
+	last map[uint64]ioSample
+}
+
+type ioAccumulators struct {
+	data  map[string]*ioAccumulator
+	mutex sync.Mutex
+}
+
+var unitIO = &ioAccumulators{data: make(map[string]*ioAccumulator)}
+
+func (ia *ioAccumulators) update(cg string, pids map[uint64]bool) ioSample {
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return ioSample{}
+	}
+
+	current := make(map[uint64]ioSample, len(pids))
+	for pid := range pids {
+		proc, err := fs.Proc(int(pid))
+		if err != nil {
+			continue
+		}
+		pio, err := proc.IO()
+		if err != nil {
+			continue
+		}
+		current[pid] = ioSample{rchar: pio.RChar, wchar: pio.WChar}
+	}
+
+	defer ia.mutex.Unlock()
+	ia.mutex.Lock()
+
+	acc, ok := ia.data[cg]
+	if !ok {
+		acc = &ioAccumulator{last: make(map[uint64]ioSample)}
+		ia.data[cg] = acc
+	}
+
+	for pid, sample := range acc.last {
+		if _, ok := current[pid]; !ok {
+			acc.base.rchar += sample.rchar
+			acc.base.wchar += sample.wchar
+		}
+	}
+	acc.last = current
+
+	total := acc.base
+	for _, sample := range current {
+		total.rchar += sample.rchar
+		total.wchar += sample.wchar
+	}
+	return total
+}
+
+func (ia *ioAccumulators) clean(active map[string]bool) {
+	defer ia.mutex.Unlock()
+	ia.mutex.Lock()
+	for cg := range ia.data {
+		if !active[cg] {
+			delete(ia.data, cg)
+		}
+	}
+}
+
+type mountIO struct {
+	mount  string
+	fstype string
+	read   uint64
+	write  uint64
+}
+
+// networkMountIO returns the bytes read and written through each NFS and Lustre
+// mount of the node.
+func networkMountIO() []mountIO {
+	var mounts []mountIO
+
+	fs, err := procfs.NewDefaultFS()
+	if err == nil {
+		self, err := fs.Self()
+		if err == nil {
+			stats, err := self.MountStats()
+			if err != nil {
+				slog.Debug("unable to read mountstats", "err", err)
+			}
+			for _, m := range stats {
+				if nfs, ok := m.Stats.(*procfs.MountStatsNFS); ok {
+					mounts = append(mounts, mountIO{mount: m.Mount, fstype: m.Type, read: nfs.Bytes.Read, write: nfs.Bytes.Write})
+				}
+			}
+		}
+	}
+
+	// newer lustre clients only expose llite stats in debugfs
+	for _, dir := range []string{"/proc/fs/lustre/llite", "/sys/kernel/debug/lustre/llite"} {
+		files, _ := filepath.Glob(path.Join(dir, "*", "stats"))
+		for _, file := range files {
+			read, write, err := readLliteStats(file)
+			if err != nil {
+				slog.Debug("unable to read lustre stats", "file", file, "err", err)
+				continue
+			}
+			mounts = append(mounts, mountIO{mount: path.Base(path.Dir(file)), fstype: "lustre", read: read, write: write})
+		}
+		if len(files) > 0 {
+			break
+		}
+	}
+
+	return mounts
+}
+
+// readLliteStats parses the sum of the read_bytes and write_bytes lines, like
+// 'read_bytes 1024 samples [bytes] 1 4096 2097152'
+func readLliteStats(file string) (uint64, uint64, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var read, write uint64
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		sum, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "read_bytes":
+			read = sum
+		case "write_bytes":
+			write = sum
+		}
+	}
+	return read, write, nil
+}
+
+func (c *Collector) collectNetworkMounts(ch chan<- prometheus.Metric) {
+	for _, m := range networkMountIO() {
+		ch <- prometheus.MustNewConstMetric(c.mountRead, prometheus.CounterValue, float64(m.read), m.mount, m.fstype)
+		ch <- prometheus.MustNewConstMetric(c.mountWrite, prometheus.CounterValue, float64(m.write), m.mount, m.fstype)
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NvidiaSMI is the path of the nvidia-smi binary used to attribute GPU usage to processes.
+var NvidiaSMI = "nvidia-smi"
+
+const bytesPerMiB = 1024 * 1024
+
+type gpuProcess struct {
+	memoryBytes uint64
+	utilization float64
+}
+
+// gpuProcesses returns the GPU memory and streaming multiprocessor utilization
+// of every process using a GPU, summed over all GPUs.
+func gpuProcesses() (map[uint64]gpuProcess, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	procs := make(map[uint64]gpuProcess)
+
+	out, err := exec.CommandContext(ctx, NvidiaSMI, "--query-compute-apps=pid,used_memory", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 2 {
+			continue
+		}
+
+		pid, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			continue
+		}
+		mib, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		p := procs[pid]
+		p.memoryBytes += mib * bytesPerMiB
+		procs[pid] = p
+	}
+
+	// columns are: gpu pid type sm mem enc dec command
+	out, err = exec.CommandContext(ctx, NvidiaSMI, "pmon", "-c", "1", "-s", "u").Output()
+	if err != nil {
+		return procs, err
+	}
+
+	scanner = bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		pid, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		sm, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			continue
+		}
+
+		p := procs[pid]
+		p.utilization += sm / 100
+		procs[pid] = p
+	}
+
+	return procs, nil
+}
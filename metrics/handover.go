@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"github.com/chpc-uofu/cgroup-warden/state"
+)
+
+// counters kept in memory by the warden, handed over to the new process during
+// an upgrade so they do not reset.
+type counterSnapshot struct {
+	JournalLines map[string]uint64             `json:"journal_lines"`
+	OOMDKills    map[string]uint64             `json:"oomd_kills"`
+	UnitIO       map[string]ioAccumulatorState `json:"unit_io"`
+}
+
+type ioAccumulatorState struct {
+	BaseRead  uint64               `json:"base_read"`
+	BaseWrite uint64               `json:"base_write"`
+	Last      map[uint64][2]uint64 `json:"last"`
+}
+
+// SaveCounters writes the counters kept in memory to a file, and saves the baselines.
+func SaveCounters(file string) error {
+	snapshot := counterSnapshot{
+		JournalLines: make(map[string]uint64),
+		OOMDKills:    make(map[string]uint64),
+		UnitIO:       make(map[string]ioAccumulatorState),
+	}
+
+	journalLines.mutex.Lock()
+	for unit, lines := range journalLines.data {
+		snapshot.JournalLines[unit] = lines
+	}
+	journalLines.mutex.Unlock()
+
+	oomdKills.mutex.Lock()
+	for cg, kills := range oomdKills.data {
+		snapshot.OOMDKills[cg] = kills
+	}
+	oomdKills.mutex.Unlock()
+
+	unitIO.mutex.Lock()
+	for cg, acc := range unitIO.data {
+		s := ioAccumulatorState{BaseRead: acc.base.rchar, BaseWrite: acc.base.wchar, Last: make(map[uint64][2]uint64)}
+		for pid, sample := range acc.last {
+			s.Last[pid] = [2]uint64{sample.rchar, sample.wchar}
+		}
+		snapshot.UnitIO[cg] = s
+	}
+	unitIO.mutex.Unlock()
+
+	if Baselines != nil {
+		Baselines.flush()
+	}
+
+	return state.WriteJSON(file, snapshot)
+}
+
+// RestoreCounters reads the counters written by SaveCounters.
+func RestoreCounters(file string) error {
+	var snapshot counterSnapshot
+	if err := state.ReadJSON(file, &snapshot); err != nil {
+		return err
+	}
+
+	journalLines.mutex.Lock()
+	for unit, lines := range snapshot.JournalLines {
+		journalLines.data[unit] += lines
+	}
+	journalLines.mutex.Unlock()
+
+	oomdKills.mutex.Lock()
+	for cg, kills := range snapshot.OOMDKills {
+		oomdKills.data[cg] += kills
+	}
+	oomdKills.mutex.Unlock()
+
+	unitIO.mutex.Lock()
+	for cg, s := range snapshot.UnitIO {
+		acc := &ioAccumulator{base: ioSample{rchar: s.BaseRead, wchar: s.BaseWrite}, last: make(map[uint64]ioSample)}
+		for pid, sample := range s.Last {
+			acc.last[pid] = ioSample{rchar: sample[0], wchar: sample[1]}
+		}
+		unitIO.data[cg] = acc
+	}
+	unitIO.mutex.Unlock()
+	return nil
+}
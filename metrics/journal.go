@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+)
+
+// journalCounter counts the journal lines logged by each slice and unit, keyed
+// by unit name, so user slices and system services can both be attributed.
+type journalCounter struct {
+	data  map[string]uint64
+	mutex sync.Mutex
+}
+
+var journalLines = &journalCounter{data: make(map[string]uint64)}
+
+// Journal enables counting the lines logged to the journal by each unit.
+var Journal = false
+
+func (jc *journalCounter) add(units ...string) {
+	defer jc.mutex.Unlock()
+	jc.mutex.Lock()
+	for _, unit := range units {
+		if unit != "" {
+			jc.data[unit]++
+		}
+	}
+}
+
+func (jc *journalCounter) get(cg string) (uint64, bool) {
+	defer jc.mutex.Unlock()
+	jc.mutex.Lock()
+	count, ok := jc.data[path.Base(cg)]
+	return count, ok
+}
+
+func (jc *journalCounter) clean(active map[string]bool) {
+	names := make(map[string]bool, len(active))
+	for cg := range active {
+		names[path.Base(cg)] = true
+	}
+
+	defer jc.mutex.Unlock()
+	jc.mutex.Lock()
+	for unit := range jc.data {
+		if !names[unit] {
+			delete(jc.data, unit)
+		}
+	}
+}
+
+type journalEntry struct {
+	Unit    string `json:"_SYSTEMD_UNIT"`
+	Slice   string `json:"_SYSTEMD_SLICE"`
+	Message any    `json:"MESSAGE"`
+}
+
+// FollowJournal counts the entries logged to the journal until the context is
+// cancelled, restarting journalctl if it exits.
+func FollowJournal(ctx context.Context) {
+	for {
+		err := followJournal(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("journal follower exited, restarting", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func followJournal(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "journalctl", "--follow", "--lines=0", "--output=json",
+		"--output-fields=_SYSTEMD_UNIT,_SYSTEMD_SLICE,MESSAGE")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		// messages that are not valid utf-8 are encoded as arrays of bytes
+		if message, ok := entry.Message.(string); ok && entry.Unit == "systemd-oomd.service" {
+			oomdKills.record(message)
+		}
+
+		if entry.Slice == entry.Unit {
+			entry.Slice = ""
+		}
+		journalLines.add(entry.Unit, entry.Slice)
+	}
+
+	return cmd.Wait()
+}
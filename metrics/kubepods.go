@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KubepodsCGroup is the cgroup containing the pods of the kubelet, like
+// /kubepods.slice with the systemd cgroup driver or /kubepods with the cgroupfs
+// driver. Pods are not collected if empty.
+var KubepodsCGroup = ""
+
+// Kubelet is used to resolve pod names and namespaces, if not nil.
+var Kubelet *KubeletClient
+
+var (
+	// systemd driver: kubepods-burstable.slice, cgroupfs driver: burstable
+	qosRe = regexp.MustCompile(`^(?:kubepods-)?(burstable|besteffort)(?:\.slice)?$`)
+	// systemd driver: kubepods-burstable-pod<uid>.slice, cgroupfs driver: pod<uid>
+	podRe = regexp.MustCompile(`^(?:kubepods-(?:burstable-|besteffort-)?)?pod([0-9a-f_-]+)(?:\.slice)?$`)
+)
+
+type podMeta struct {
+	name      string
+	namespace string
+}
+
+// KubeletClient lists the pods running on the node from the kubelet API,
+// caching the result for a minute.
+type KubeletClient struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+	pods      map[string]podMeta
+	updated   time.Time
+	mutex     sync.Mutex
+}
+
+func NewKubeletClient(url string, tokenFile string, insecure bool) *KubeletClient {
+	return &KubeletClient{
+		url:       strings.TrimSuffix(url, "/"),
+		tokenFile: tokenFile,
+		client: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}},
+		},
+	}
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+			UID       string `json:"uid"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+func (k *KubeletClient) refresh() error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, k.url+"/pods", nil)
+	if err != nil {
+		return err
+	}
+
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kubelet responded with status %d", resp.StatusCode)
+	}
+
+	var list podList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return err
+	}
+
+	pods := make(map[string]podMeta, len(list.Items))
+	for _, item := range list.Items {
+		pods[item.Metadata.UID] = podMeta{name: item.Metadata.Name, namespace: item.Metadata.Namespace}
+	}
+	k.pods = pods
+	return nil
+}
+
+func (k *KubeletClient) lookup(uid string) podMeta {
+	defer k.mutex.Unlock()
+	k.mutex.Lock()
+
+	if time.Since(k.updated) > time.Minute {
+		if err := k.refresh(); err != nil {
+			slog.Warn("unable to list pods from kubelet", "err", err)
+		}
+		// avoid querying a failing kubelet on every pod
+		k.updated = time.Now()
+	}
+	return k.pods[uid]
+}
+
+// collectKubepods emits metrics for every QoS class and pod of the kubelet
+func (c *Collector) collectKubepods(ch chan<- prometheus.Metric, h hierarchy.Hierarchy) {
+	children, err := h.Children(KubepodsCGroup)
+	if err != nil {
+		slog.Debug("unable to list kubepods cgroup", "cgroup", KubepodsCGroup, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		name := path.Base(child)
+		if match := qosRe.FindStringSubmatch(name); match != nil {
+			c.qosClasses.collect(ch, h, child, child, "", match[1])
+
+			pods, err := h.Children(child)
+			if err != nil {
+				slog.Debug("unable to list pods", "cgroup", child, "err", err)
+				continue
+			}
+			for _, pod := range pods {
+				c.collectPod(ch, h, pod, match[1])
+			}
+			continue
+		}
+
+		// guaranteed pods are placed directly underneath kubepods
+		c.collectPod(ch, h, child, "guaranteed")
+	}
+}
+
+func (c *Collector) collectPod(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, cg string, qos string) {
+	match := podRe.FindStringSubmatch(path.Base(cg))
+	if match == nil {
+		return
+	}
+	uid := strings.ReplaceAll(match[1], "_", "-")
+
+	var meta podMeta
+	if Kubelet != nil {
+		meta = Kubelet.lookup(uid)
+	}
+	c.pods.collect(ch, h, cg, cg, "", qos, uid, meta.name, meta.namespace)
+}
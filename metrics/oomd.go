@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type oomdConfig struct {
+	memoryPressure      string
+	swap                string
+	memoryPressureLimit float64
+}
+
+// unitOOMDConfig reads the systemd-oomd management properties of a unit
+func unitOOMDConfig(ctx context.Context, conn *systemd.Conn, cg string) (oomdConfig, error) {
+	var config oomdConfig
+
+	props, err := unitProperties(ctx, conn, cg)
+	if err != nil {
+		return config, err
+	}
+
+	config.memoryPressure, _ = props["ManagedOOMMemoryPressure"].(string)
+	config.swap, _ = props["ManagedOOMSwap"].(string)
+
+	// the limit is scaled so that the maximum uint32 is 100%
+	if limit, ok := props["ManagedOOMMemoryPressureLimit"].(uint32); ok {
+		config.memoryPressureLimit = float64(limit) / math.MaxUint32
+	}
+	return config, nil
+}
+
+func (c *Collector) collectOOMD(ch chan<- prometheus.Metric, conn *systemd.Conn, cg string, username string) {
+	config, err := unitOOMDConfig(context.Background(), conn, cg)
+	if err != nil {
+		slog.Debug("unable to read oomd properties", "cgroup", cg, "err", err)
+		status.Report(status.Collect, cg, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.oomdInfo, prometheus.GaugeValue, 1, cg, username, config.memoryPressure, config.swap)
+	ch <- prometheus.MustNewConstMetric(c.oomdLimit, prometheus.GaugeValue, config.memoryPressureLimit, cg, username)
+	ch <- prometheus.MustNewConstMetric(c.oomdKills, prometheus.CounterValue, float64(oomdKills.get(cg)), cg, username)
+}
+
+// oomdKillRe matches the message systemd-oomd logs after killing a cgroup, like
+// 'Killed /user.slice/user-1000.slice/session-3.scope due to memory pressure for ...'
+var oomdKillRe = regexp.MustCompile(`^Killed (/\S+) due to`)
+
+// oomdKillCounter counts the cgroups killed by systemd-oomd, keyed by the path of
+// the killed cgroup, as found in the journal.
+type oomdKillCounter struct {
+	data  map[string]uint64
+	mutex sync.Mutex
+}
+
+var oomdKills = &oomdKillCounter{data: make(map[string]uint64)}
+
+func (oc *oomdKillCounter) record(message string) {
+	match := oomdKillRe.FindStringSubmatch(message)
+	if match == nil {
+		return
+	}
+
+	defer oc.mutex.Unlock()
+	oc.mutex.Lock()
+	oc.data[path.Clean(match[1])]++
+}
+
+// get returns the number of kills of the unit itself or any cgroup underneath it
+func (oc *oomdKillCounter) get(cg string) uint64 {
+	defer oc.mutex.Unlock()
+	oc.mutex.Lock()
+
+	var kills uint64
+	for killed, count := range oc.data {
+		if killed == cg || strings.HasPrefix(killed, cg+"/") {
+			kills += count
+		}
+	}
+	return kills
+}
+
+func (oc *oomdKillCounter) clean(active map[string]bool) {
+	defer oc.mutex.Unlock()
+	oc.mutex.Lock()
+
+	for killed := range oc.data {
+		found := false
+		for cg := range active {
+			if killed == cg || strings.HasPrefix(killed, cg+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			delete(oc.data, killed)
+		}
+	}
+}
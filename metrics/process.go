@@ -1,24 +1,80 @@
 package metrics
 
 import (
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/chpc-uofu/cgroup-warden/status"
 	"github.com/prometheus/procfs"
 )
 
 type process struct {
 	cpuSeconds  float64
+	userSeconds float64
+	cpuDelta    float64
+	startTicks  uint64
 	memoryBytes uint64
 	memoryPSS   uint64
-	command     string
-	current     bool
+	swapBytes   uint64
+	gpuMemory   uint64
+	io          procfs.ProcIO
+	threads     int
+	wchan       string
+	state       string
+	sched       schedClass
+	fds         int
+	minorFaults uint
+	majorFaults uint
+
+	voluntarySwitches   uint64
+	involuntarySwitches uint64
+
+	key     procKey
+	current bool
 }
 
 type ProcessAggregation struct {
 	cpuSecondsTotal  float64
+	userSecondsTotal float64
 	memoryBytesTotal uint64
 	memoryPSSTotal   uint64
-	count            uint64
+	swapBytesTotal   uint64
+	gpuMemoryTotal   uint64
+	readBytesTotal   uint64
+	writeBytesTotal  uint64
+	readCallsTotal   uint64
+	writeCallsTotal  uint64
+	threadsTotal     uint64
+	fdsTotal         uint64
+	minorFaultsTotal uint64
+	majorFaultsTotal uint64
+
+	// CPU cores used since the last scrape
+	cpuRate float64
+
+	// start time of the oldest process in clock ticks after boot
+	oldestStartTicks uint64
+
+	voluntarySwitchesTotal   uint64
+	involuntarySwitchesTotal uint64
+
+	count uint64
+
+	// number of processes in uninterruptible sleep by wait channel
+	blocked map[string]uint64
+
+	// number of processes by state, like running or sleeping
+	states map[string]uint64
+
+	// number of processes by scheduling policy and nice value
+	scheds map[schedClass]uint64
+}
+
+// schedClass is the scheduling policy and nice value of a process
+type schedClass struct {
+	policy string
+	nice   int
 }
 
 type processCache struct {
@@ -66,6 +122,15 @@ func CleanProcessCache(active map[string]bool) {
 type entry struct {
 	data  map[uint64]process
 	mutex sync.Mutex
+
+	// time of the last update, and seconds elapsed between the last two
+	updated time.Time
+	elapsed float64
+
+	// process names at the last scrape, and the counters of the "other" process
+	// accumulated from what the names merged into it used since
+	last  map[procKey]ProcessAggregation
+	other ProcessAggregation
 }
 
 func newEntry() *entry {
@@ -75,37 +140,91 @@ func newEntry() *entry {
 	}
 }
 
-func (e *entry) update(processes map[uint64]process) {
+// update stores the processes, computing the CPU time each used since the last
+// update. A process not seen before started since the last update, unless the
+// cgroup is updated for the first time.
+func (e *entry) update(processes map[uint64]process, now time.Time) {
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
+
+	first := e.updated.IsZero()
 	for pid, process := range processes {
+		previous, ok := e.data[pid]
+		switch {
+		case ok && previous.startTicks == process.startTicks:
+			process.cpuDelta = process.cpuSeconds - previous.cpuSeconds
+		case !first:
+			process.cpuDelta = process.cpuSeconds
+		}
 		e.data[pid] = process
 	}
+
+	if !first {
+		e.elapsed = now.Sub(e.updated).Seconds()
+	}
+	e.updated = now
 }
 
-func (e *entry) clean(active map[string]bool) {
+func (e *entry) clean(active map[procKey]bool) {
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
 	for pid, process := range e.data {
-		if _, ok := active[process.command]; !ok {
+		if _, ok := active[process.key]; !ok {
 			delete(e.data, pid)
 		}
 	}
 }
 
-func (e *entry) aggregate() map[string]ProcessAggregation {
-	results := make(map[string]ProcessAggregation)
+func (e *entry) aggregate() map[procKey]ProcessAggregation {
+	results := make(map[procKey]ProcessAggregation)
 	defer e.mutex.Unlock()
 	e.mutex.Lock()
 	for pid, process := range e.data {
-		r := results[process.command]
+		r := results[process.key]
 		r.cpuSecondsTotal += process.cpuSeconds
+		r.userSecondsTotal += process.userSeconds
+		r.readBytesTotal += process.io.ReadBytes
+		r.writeBytesTotal += process.io.WriteBytes
+		r.readCallsTotal += process.io.SyscR
+		r.writeCallsTotal += process.io.SyscW
+		r.minorFaultsTotal += uint64(process.minorFaults)
+		r.majorFaultsTotal += uint64(process.majorFaults)
+		r.voluntarySwitchesTotal += process.voluntarySwitches
+		r.involuntarySwitchesTotal += process.involuntarySwitches
 		if process.current {
 			r.memoryBytesTotal += process.memoryBytes
 			r.memoryPSSTotal += process.memoryPSS
+			r.swapBytesTotal += process.swapBytes
+			r.gpuMemoryTotal += process.gpuMemory
+			r.threadsTotal += uint64(process.threads)
+			r.fdsTotal += uint64(process.fds)
+			if e.elapsed > 0 {
+				r.cpuRate += process.cpuDelta / e.elapsed
+			}
+			if r.count == 0 || process.startTicks < r.oldestStartTicks {
+				r.oldestStartTicks = process.startTicks
+			}
+			if process.sched.policy != "" {
+				if r.scheds == nil {
+					r.scheds = make(map[schedClass]uint64)
+				}
+				r.scheds[process.sched] += 1
+			}
+			if process.state != "" {
+				if r.states == nil {
+					r.states = make(map[string]uint64)
+				}
+				r.states[process.state] += 1
+			}
+			if process.wchan != "" {
+				if r.blocked == nil {
+					r.blocked = make(map[string]uint64)
+				}
+				r.blocked[process.wchan] += 1
+			}
 			r.count += 1
 		}
-		results[process.command] = r
+		results[process.key] = r
 		process.current = false
 		e.data[pid] = process
 	}
@@ -115,53 +234,195 @@ func (e *entry) aggregate() map[string]ProcessAggregation {
 
 var cache = newProcessCache()
 
-func ProcessInfo(cg string, pids map[uint64]bool) (map[string]ProcessAggregation, error) {
+// names of the scheduling policies in sched.h
+var schedPolicies = map[uint]string{
+	0: "normal",
+	1: "fifo",
+	2: "rr",
+	3: "batch",
+	5: "idle",
+	6: "deadline",
+}
+
+func schedPolicy(policy uint) string {
+	if name, ok := schedPolicies[policy]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(policy), 10)
+}
+
+// the kernel reports process start times in USER_HZ clock ticks after boot,
+// which is 100 on all architectures supported by Linux
+const userHZ = 100
+
+var (
+	bootTime     float64
+	bootTimeOnce sync.Once
+)
+
+// startTime converts a process start time in clock ticks after boot to seconds
+// since the epoch, returning 0 if the boot time is unknown.
+func startTime(ticks uint64) float64 {
+	bootTimeOnce.Do(func() {
+		fs, err := procfs.NewDefaultFS()
+		if err != nil {
+			return
+		}
+		stat, err := fs.Stat()
+		if err != nil {
+			return
+		}
+		bootTime = float64(stat.BootTime)
+	})
+
+	if bootTime == 0 {
+		return 0
+	}
+	return bootTime + float64(ticks)/userHZ
+}
+
+// names of the process states reported in /proc/[pid]/stat, with the rare states
+// like tracing stop and idle kernel threads folded into similar ones
+var processStates = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"I": "sleeping",
+	"D": "disk-sleep",
+	"Z": "zombie",
+	"X": "zombie",
+	"T": "stopped",
+	"t": "stopped",
+}
+
+// ProcMemoryPSS enables reading the PSS of processes from smaps_rollup. Reading it
+// walks the page tables of the process, which is slow for slices with thousands of
+// processes, so it can be disabled to only report the resident set size.
+var ProcMemoryPSS = true
+
+// ProcessInfo aggregates the processes of a cgroup by command, collapsing the names
+// not exported separately into the "other" process. Reading the PSS of
+// a process walks its page tables, so it is skipped unless memory is requested.
+func ProcessInfo(cg string, pids map[uint64]bool, toggles Toggles, gpuProcs map[uint64]gpuProcess) (map[procKey]ProcessAggregation, error) {
 	fs, err := procfs.NewDefaultFS()
 	if err != nil {
 		return nil, err
 	}
 
-	active := make(map[string]bool)
+	active := make(map[procKey]bool)
 	processes := make(map[uint64]process)
+	sessions := newSessionNames(fs)
 
 	for pid := range pids {
 
+		// processes exiting while being read are reported as gone
 		proc, err := fs.Proc(int(pid))
 		if err != nil {
+			status.Report(status.Collect, cg, err)
 			continue
 		}
 
-		command, err := proc.Comm()
+		comm, err := proc.Comm()
 		if err != nil {
 			continue
 		}
+		if !collectProcess(proc, comm) {
+			continue
+		}
 
 		stat, err := proc.Stat()
 		if err != nil {
 			continue
 		}
+		key := processKey(proc, comm, sessions.get(stat.Session))
 
-		rollup, err := proc.ProcSMapsRollup()
-		if err != nil {
-			continue
+		// the kernel reports user and system time in clock ticks, split the
+		// total CPU time by their ratio to avoid assuming the tick rate
+		var userSeconds float64
+		if ticks := stat.UTime + stat.STime; ticks > 0 {
+			userSeconds = stat.CPUTime() * float64(stat.UTime) / float64(ticks)
 		}
 
 		process := process{
 			cpuSeconds:  stat.CPUTime(),
+			userSeconds: userSeconds,
+			startTicks:  stat.Starttime,
 			memoryBytes: uint64(stat.ResidentMemory()),
-			memoryPSS:   rollup.Pss,
-			command:     command,
+			threads:     stat.NumThreads,
+			minorFaults: stat.MinFlt,
+			majorFaults: stat.MajFlt,
+			key:         key,
 			current:     true,
 		}
 
-		active[command] = true
+		if toggles[ProcMemory] && ProcMemoryPSS {
+			rollup, err := proc.ProcSMapsRollup()
+			if err != nil {
+				continue
+			}
+			process.memoryPSS = rollup.Pss
+			process.swapBytes = rollup.Swap
+		}
+
+		if (toggles[ProcMemory] && !ProcMemoryPSS) || toggles[ProcSwitch] {
+			procStatus, err := proc.NewStatus()
+			if err != nil {
+				continue
+			}
+			if !ProcMemoryPSS {
+				process.swapBytes = procStatus.VmSwap
+			}
+			process.voluntarySwitches = procStatus.VoluntaryCtxtSwitches
+			process.involuntarySwitches = procStatus.NonVoluntaryCtxtSwitches
+		}
+
+		if toggles[ProcStates] {
+			process.state = processStates[stat.State]
+		}
+
+		if toggles[ProcSched] {
+			process.sched = schedClass{policy: schedPolicy(stat.Policy), nice: stat.Nice}
+		}
+
+		// the wait channel of a process is only of interest while it is stuck in
+		// uninterruptible sleep, usually on a filesystem or driver
+		if toggles[ProcWchan] && stat.State == "D" {
+			wchan, err := proc.Wchan()
+			if err == nil && wchan != "" {
+				process.wchan = wchan
+			}
+		}
+
+		if toggles[GPU] {
+			process.gpuMemory = gpuProcs[pid].memoryBytes
+		}
+
+		if toggles[ProcFDs] {
+			fds, err := proc.FileDescriptorsLen()
+			if err != nil {
+				continue
+			}
+			process.fds = fds
+		}
+
+		if toggles[ProcIO] {
+			io, err := proc.IO()
+			if err != nil {
+				continue
+			}
+			process.io = io
+		}
+
+		active[key] = true
 		processes[pid] = process
 	}
 
 	e := cache.get(cg)
-	e.update(processes)
+	e.update(processes, time.Now())
 	e.clean(active)
 	results := e.aggregate()
+	if keep := keptProcesses(results); keep != nil {
+		results = e.collapse(results, keep)
+	}
 	cache.put(cg, e)
 	return results, nil
 }
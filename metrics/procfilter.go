@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/prometheus/procfs"
+)
+
+// keys processes can be aggregated by
+const (
+	GroupByComm    = "comm"
+	GroupByExe     = "exe"
+	GroupByExePath = "exe-path"
+	GroupBySession = "session"
+)
+
+var ProcGroupKeys = []string{GroupByComm, GroupByExe, GroupByExePath, GroupBySession}
+
+// ProcGroupBy is the key processes are aggregated by. The command name is
+// truncated to 15 characters, and names different interpreted programs alike,
+// which using the name or path of the executable avoids. Aggregating by session
+// names processes after the leader of their session, like 'sshd[1234]', which
+// tells apart the logins of a user.
+var ProcGroupBy = GroupByComm
+
+// ValidateProcGroupBy checks that processes can be aggregated by the key
+func ValidateProcGroupBy(key string) error {
+	if !slices.Contains(ProcGroupKeys, key) {
+		return fmt.Errorf("invalid process aggregation '%s'. Options include %v", key, ProcGroupKeys)
+	}
+	return nil
+}
+
+// ProcInclude and ProcExclude restrict the processes collected to those with a
+// command name or executable path matching the include expression, and not
+// matching the exclude expression. Either may be nil.
+var (
+	ProcInclude *regexp.Regexp
+	ProcExclude *regexp.Regexp
+)
+
+// collectProcess reports whether a process passes the include and exclude filters,
+// reading its executable only if the command name does not decide it.
+func collectProcess(proc procfs.Proc, comm string) bool {
+	if ProcInclude == nil && ProcExclude == nil {
+		return true
+	}
+
+	var exe *string
+	matches := func(re *regexp.Regexp) bool {
+		if re.MatchString(comm) {
+			return true
+		}
+		if exe == nil {
+			e, _ := proc.Executable()
+			exe = &e
+		}
+		return *exe != "" && re.MatchString(*exe)
+	}
+
+	if ProcInclude != nil && !matches(ProcInclude) {
+		return false
+	}
+	return ProcExclude == nil || !matches(ProcExclude)
+}
+
+// procKey identifies the processes aggregated together
+type procKey struct {
+	name   string
+	script string
+	app    string
+}
+
+// labels returns the values of the process labels, followed by any extra values
+func (k procKey) labels(cg string, username string, extra ...string) []string {
+	return append([]string{cg, username, k.name, k.script, k.app}, extra...)
+}
+
+// processKey returns the key a process is aggregated by, given the name of its
+// session. Processes without an executable, like kernel threads, or whose
+// executable cannot be read, are aggregated by their command name.
+func processKey(proc procfs.Proc, comm string, session string) procKey {
+	key := procKey{name: comm}
+
+	if ProcGroupBy == GroupBySession && session != "" {
+		key.name = session
+	} else if ProcGroupBy == GroupByExe || ProcGroupBy == GroupByExePath {
+		exe, err := proc.Executable()
+		if err == nil && exe != "" {
+			key.name = exe
+			if ProcGroupBy == GroupByExe {
+				key.name = path.Base(exe)
+			}
+		}
+	}
+
+	if isInterpreter(comm) {
+		cmdline, err := proc.CmdLine()
+		if err == nil {
+			key.script = scriptName(cmdline)
+		}
+	}
+
+	key.app = classify(proc, comm)
+	return key
+}
+
+// sessionNames names sessions after their leader, remembering the names looked up
+type sessionNames struct {
+	fs    procfs.FS
+	names map[int]string
+}
+
+func newSessionNames(fs procfs.FS) *sessionNames {
+	return &sessionNames{fs: fs, names: make(map[int]string)}
+}
+
+// get returns the name of a session, or an empty name for processes without a
+// session like kernel threads, or when aggregating by something else.
+func (sn *sessionNames) get(sid int) string {
+	if ProcGroupBy != GroupBySession || sid <= 0 {
+		return ""
+	}
+
+	name, ok := sn.names[sid]
+	if ok {
+		return name
+	}
+
+	// the leader may have exited while the session lives on
+	leader := "session"
+	if proc, err := sn.fs.Proc(sid); err == nil {
+		if comm, err := proc.Comm(); err == nil {
+			leader = comm
+		}
+	}
+
+	name = fmt.Sprintf("%s[%d]", leader, sid)
+	sn.names[sid] = name
+	return name
+}
+
+// ProcTop limits the process names exported per unit to the top by CPU usage and
+// the top by memory usage, collapsing the others into a single process named
+// "other". There is no limit if it is 0.
+var ProcTop = 0
+
+// ProcMinMemory and ProcMinCPU are the memory in bytes and the CPU cores a process
+// name must use for it to be exported, otherwise it is collapsed into the "other"
+// process. A process name is exported if it reaches either threshold that is set.
+var (
+	ProcMinMemory uint64  = 0
+	ProcMinCPU    float64 = 0
+)
+
+var otherProcess = procKey{name: "other"}
+
+// merge adds the usage of another aggregation to this one
+func (a *ProcessAggregation) merge(b ProcessAggregation) {
+	a.cpuSecondsTotal += b.cpuSecondsTotal
+	a.userSecondsTotal += b.userSecondsTotal
+	a.memoryBytesTotal += b.memoryBytesTotal
+	a.memoryPSSTotal += b.memoryPSSTotal
+	a.swapBytesTotal += b.swapBytesTotal
+	a.gpuMemoryTotal += b.gpuMemoryTotal
+	a.readBytesTotal += b.readBytesTotal
+	a.writeBytesTotal += b.writeBytesTotal
+	a.readCallsTotal += b.readCallsTotal
+	a.writeCallsTotal += b.writeCallsTotal
+	a.threadsTotal += b.threadsTotal
+	a.fdsTotal += b.fdsTotal
+	a.minorFaultsTotal += b.minorFaultsTotal
+	a.majorFaultsTotal += b.majorFaultsTotal
+	a.cpuRate += b.cpuRate
+	a.voluntarySwitchesTotal += b.voluntarySwitchesTotal
+	a.involuntarySwitchesTotal += b.involuntarySwitchesTotal
+	if a.count == 0 || (b.count > 0 && b.oldestStartTicks < a.oldestStartTicks) {
+		a.oldestStartTicks = b.oldestStartTicks
+	}
+	a.count += b.count
+
+	a.blocked = mergeCounts(a.blocked, b.blocked)
+	a.states = mergeCounts(a.states, b.states)
+	a.scheds = mergeCounts(a.scheds, b.scheds)
+}
+
+func mergeCounts[K comparable](a map[K]uint64, b map[K]uint64) map[K]uint64 {
+	if len(b) == 0 {
+		return a
+	}
+	if a == nil {
+		a = make(map[K]uint64, len(b))
+	}
+	for key, count := range b {
+		a[key] += count
+	}
+	return a
+}
+
+// keptProcesses returns the process names to export separately, those reaching
+// a threshold and among the top ProcTop, or nil to export every name without an
+// "other" process
+func keptProcesses(procs map[procKey]ProcessAggregation) map[procKey]bool {
+	if ProcMinMemory == 0 && ProcMinCPU == 0 && ProcTop <= 0 {
+		return nil
+	}
+
+	significant := func(p ProcessAggregation) bool {
+		return (ProcMinMemory == 0 && ProcMinCPU == 0) ||
+			(ProcMinMemory > 0 && p.memoryBytesTotal >= ProcMinMemory) ||
+			(ProcMinCPU > 0 && p.cpuRate >= ProcMinCPU)
+	}
+
+	// a process actually named other is merged with the rest
+	var keys []procKey
+	for key, p := range procs {
+		if significant(p) && key != otherProcess {
+			keys = append(keys, key)
+		}
+	}
+
+	keep := make(map[procKey]bool, len(keys))
+	if ProcTop <= 0 || len(keys) <= ProcTop {
+		for _, key := range keys {
+			keep[key] = true
+		}
+		return keep
+	}
+
+	// ranked by the CPU used since the last scrape rather than since the processes
+	// started, so that a busy new process is not outranked by idle old ones
+	slices.SortFunc(keys, func(a, b procKey) int {
+		return cmp.Or(cmp.Compare(procs[b].cpuRate, procs[a].cpuRate), cmp.Compare(procs[b].cpuSecondsTotal, procs[a].cpuSecondsTotal))
+	})
+	for _, key := range keys[:ProcTop] {
+		keep[key] = true
+	}
+
+	slices.SortFunc(keys, func(a, b procKey) int {
+		return cmp.Compare(procs[b].memoryBytesTotal, procs[a].memoryBytesTotal)
+	})
+	for _, key := range keys[:ProcTop] {
+		keep[key] = true
+	}
+	return keep
+}
+
+// increase returns how much a counter increased from last to current, all of it
+// if it was reset in between
+func increase[T uint64 | float64](current T, last T) T {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// addIncrease adds to the counters of this aggregation how much those of another
+// increased since the last scrape
+func (a *ProcessAggregation) addIncrease(current ProcessAggregation, last ProcessAggregation) {
+	a.cpuSecondsTotal += increase(current.cpuSecondsTotal, last.cpuSecondsTotal)
+	a.userSecondsTotal += increase(current.userSecondsTotal, last.userSecondsTotal)
+	a.readBytesTotal += increase(current.readBytesTotal, last.readBytesTotal)
+	a.writeBytesTotal += increase(current.writeBytesTotal, last.writeBytesTotal)
+	a.readCallsTotal += increase(current.readCallsTotal, last.readCallsTotal)
+	a.writeCallsTotal += increase(current.writeCallsTotal, last.writeCallsTotal)
+	a.minorFaultsTotal += increase(current.minorFaultsTotal, last.minorFaultsTotal)
+	a.majorFaultsTotal += increase(current.majorFaultsTotal, last.majorFaultsTotal)
+	a.voluntarySwitchesTotal += increase(current.voluntarySwitchesTotal, last.voluntarySwitchesTotal)
+	a.involuntarySwitchesTotal += increase(current.involuntarySwitchesTotal, last.involuntarySwitchesTotal)
+}
+
+// collapse merges the process names of the unit that are not kept into the
+// "other" process. Its gauges, like memory, are the sum of those of the names
+// merged, while its counters accumulate what the names merged used since the last
+// scrape, so that they do not drop when names move out of it.
+func (e *entry) collapse(procs map[procKey]ProcessAggregation, keep map[procKey]bool) map[procKey]ProcessAggregation {
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+
+	results := make(map[procKey]ProcessAggregation, len(keep)+1)
+	var other ProcessAggregation
+	merged := false
+	for key, p := range procs {
+		if keep[key] {
+			results[key] = p
+			continue
+		}
+		other.merge(p)
+		e.other.addIncrease(p, e.last[key])
+		merged = true
+	}
+	e.last = procs
+
+	if merged {
+		other.cpuSecondsTotal, other.userSecondsTotal = e.other.cpuSecondsTotal, e.other.userSecondsTotal
+		other.readBytesTotal, other.writeBytesTotal = e.other.readBytesTotal, e.other.writeBytesTotal
+		other.readCallsTotal, other.writeCallsTotal = e.other.readCallsTotal, e.other.writeCallsTotal
+		other.minorFaultsTotal, other.majorFaultsTotal = e.other.minorFaultsTotal, e.other.majorFaultsTotal
+		other.voluntarySwitchesTotal, other.involuntarySwitchesTotal = e.other.voluntarySwitchesTotal, e.other.involuntarySwitchesTotal
+		results[otherProcess] = other
+	}
+	return results
+}
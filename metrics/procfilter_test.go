@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestKeptProcesses(t *testing.T) {
+	defer func(top int) { ProcTop = top }(ProcTop)
+	ProcTop = 1
+
+	procs := map[procKey]ProcessAggregation{
+		{name: "idle"}:   {cpuSecondsTotal: 1000, cpuRate: 0, memoryBytesTotal: 1 << 20},
+		{name: "busy"}:   {cpuSecondsTotal: 10, cpuRate: 4, memoryBytesTotal: 2 << 20},
+		{name: "large"}:  {cpuSecondsTotal: 5, cpuRate: 0.5, memoryBytesTotal: 8 << 30},
+		{name: "other"}:  {cpuSecondsTotal: 2000, cpuRate: 8, memoryBytesTotal: 16 << 30},
+		{name: "sleepy"}: {cpuSecondsTotal: 0, cpuRate: 0, memoryBytesTotal: 1 << 10},
+	}
+
+	keep := keptProcesses(procs)
+	var got []string
+	for key := range keep {
+		got = append(got, key.name)
+	}
+	slices.Sort(got)
+	if want := []string{"busy", "large"}; !slices.Equal(got, want) {
+		t.Errorf("keptProcesses() = %v, expected %v", got, want)
+	}
+
+	ProcTop = 0
+	if keep := keptProcesses(procs); keep != nil {
+		t.Errorf("keptProcesses() without a limit = %v, expected nil", keep)
+	}
+}
+
+func TestCollapseMonotonic(t *testing.T) {
+	a, b, c := procKey{name: "a"}, procKey{name: "b"}, procKey{name: "c"}
+	scrapes := []struct {
+		procs map[procKey]ProcessAggregation
+		keep  []procKey
+		other float64
+	}{
+		// a and b are merged, with all they used so far
+		{procs: map[procKey]ProcessAggregation{a: {cpuSecondsTotal: 100}, b: {cpuSecondsTotal: 10}, c: {cpuSecondsTotal: 1}}, keep: []procKey{c}, other: 110},
+		// a moves out, which must not take its total out of other
+		{procs: map[procKey]ProcessAggregation{a: {cpuSecondsTotal: 150}, b: {cpuSecondsTotal: 12}, c: {cpuSecondsTotal: 2}}, keep: []procKey{a}, other: 113},
+		// a moves back in, adding what it used since the last scrape
+		{procs: map[procKey]ProcessAggregation{a: {cpuSecondsTotal: 160}, b: {cpuSecondsTotal: 13}, c: {cpuSecondsTotal: 2}}, keep: []procKey{c}, other: 124},
+		// b exits, and a restarts, counting from 0 again
+		{procs: map[procKey]ProcessAggregation{a: {cpuSecondsTotal: 3}, c: {cpuSecondsTotal: 2}}, keep: []procKey{c}, other: 127},
+	}
+
+	e := newEntry()
+	for i, scrape := range scrapes {
+		keep := make(map[procKey]bool)
+		for _, key := range scrape.keep {
+			keep[key] = true
+		}
+
+		results := e.collapse(scrape.procs, keep)
+		if got := results[otherProcess].cpuSecondsTotal; got != scrape.other {
+			t.Errorf("scrape %d: other has %v CPU seconds, expected %v", i, got, scrape.other)
+		}
+		for _, key := range scrape.keep {
+			if got, want := results[key].cpuSecondsTotal, scrape.procs[key].cpuSecondsTotal; got != want {
+				t.Errorf("scrape %d: %s has %v CPU seconds, expected %v", i, key.name, got, want)
+			}
+		}
+		if want := len(scrape.keep) + 1; len(results) != want {
+			t.Errorf("scrape %d: collapse() kept %v, expected %d names", i, slices.Collect(maps.Keys(results)), want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ProcScriptInterpreters are patterns matching the command names of interpreters,
+// like 'python*', whose processes are further told apart by the script they run,
+// exported in the script label.
+var ProcScriptInterpreters []string
+
+// maximum length of a script name
+const maxScriptLength = 32
+
+// options of interpreters naming the script or module run in the next argument
+var scriptOptions = map[string]bool{"-m": true, "-jar": true}
+
+// options of interpreters whose value in the next argument is not the script
+var valueOptions = map[string]bool{"-cp": true, "-classpath": true, "-W": true, "-X": true}
+
+// options of interpreters running inline code instead of a script
+var inlineOptions = map[string]bool{"-c": true, "-e": true}
+
+var unsafeScriptChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func isInterpreter(comm string) bool {
+	for _, pattern := range ProcScriptInterpreters {
+		if ok, _ := path.Match(pattern, comm); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptName returns the base name of the script run by an interpreter as the
+// first argument that is not an option, sanitized and truncated to keep the
+// label short. Like 'train.py' for 'python -u /home/u0123456/train.py --epochs 3'.
+func scriptName(cmdline []string) string {
+	if len(cmdline) < 2 {
+		return ""
+	}
+
+	args := cmdline[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case scriptOptions[arg]:
+			if i+1 < len(args) {
+				return sanitizeScript(args[i+1])
+			}
+			return ""
+		case inlineOptions[arg]:
+			return ""
+		case valueOptions[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			return sanitizeScript(arg)
+		}
+	}
+	return ""
+}
+
+func sanitizeScript(arg string) string {
+	script := unsafeScriptChars.ReplaceAllString(path.Base(arg), "_")
+	if len(script) > maxScriptLength {
+		script = script[:maxScriptLength]
+	}
+	return script
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"log/slog"
+	"path"
+	"regexp"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sessionRe = regexp.MustCompile(`^session-(.+)\.scope$`)
+
+// collectSessions emits metrics for each login session scope of a user slice
+func (c *Collector) collectSessions(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, cg string, username string) {
+	children, err := h.Children(cg)
+	if err != nil {
+		slog.Debug("unable to list session scopes", "cgroup", cg, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		match := sessionRe.FindStringSubmatch(path.Base(child))
+		if match == nil {
+			continue
+		}
+		c.sessions.collect(ch, h, cg, child, username, match[1])
+	}
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"syscall"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SlurmCGroup is the cgroup containing the job cgroups created by slurmd, like
+// /system.slice/slurmstepd.scope on the unified hierarchy or /slurm on the legacy
+// hierarchy. Slurm jobs are not collected if empty.
+var SlurmCGroup = ""
+
+var (
+	slurmUIDRe  = regexp.MustCompile(`^uid_(\d+)$`)
+	slurmJobRe  = regexp.MustCompile(`^job_(\d+)$`)
+	slurmStepRe = regexp.MustCompile(`^step_(.+)$`)
+)
+
+// collectSlurm emits metrics for every job, and every step of a job, found
+// underneath the slurm cgroup, labeled by jobid and stepid.
+func (c *Collector) collectSlurm(ch chan<- prometheus.Metric, h hierarchy.Hierarchy) {
+	children, err := h.Children(SlurmCGroup)
+	if err != nil {
+		slog.Debug("unable to list slurm cgroup", "cgroup", SlurmCGroup, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		// the legacy hierarchy groups jobs by user
+		if match := slurmUIDRe.FindStringSubmatch(path.Base(child)); match != nil {
+			jobs, err := h.Children(child)
+			if err != nil {
+				slog.Debug("unable to list slurm jobs", "cgroup", child, "err", err)
+				continue
+			}
+			for _, job := range jobs {
+				c.collectSlurmJob(ch, h, job, match[1])
+			}
+			continue
+		}
+		c.collectSlurmJob(ch, h, child, "")
+	}
+}
+
+func (c *Collector) collectSlurmJob(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, job string, uid string) {
+	match := slurmJobRe.FindStringSubmatch(path.Base(job))
+	if match == nil {
+		return
+	}
+	jobid := match[1]
+
+	if uid == "" {
+		uid = slurmJobOwner(h, job)
+	}
+
+	username, err := hierarchy.LookupUID(uid)
+	if err != nil {
+		slog.Debug("unable to determine owner of slurm job", "jobid", jobid, "err", err)
+	}
+
+	c.slurmJobs.collect(ch, h, job, job, username, jobid, "")
+
+	steps, err := h.Children(job)
+	if err != nil {
+		slog.Debug("unable to list slurm job steps", "cgroup", job, "err", err)
+		return
+	}
+	for _, step := range steps {
+		if match := slurmStepRe.FindStringSubmatch(path.Base(step)); match != nil {
+			c.slurmJobs.collect(ch, h, job, step, username, jobid, match[1])
+		}
+	}
+}
+
+// slurmJobOwner returns the uid owning the processes of a job, as the unified
+// hierarchy does not include the uid in the path of the job cgroup.
+func slurmJobOwner(h hierarchy.Hierarchy, job string) string {
+	pids, err := h.Procs(job)
+	if err != nil {
+		return ""
+	}
+
+	for _, pid := range pids {
+		info, err := os.Stat(path.Join("/proc", strconv.FormatUint(pid, 10)))
+		if err != nil {
+			continue
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+			return strconv.FormatUint(uint64(stat.Uid), 10)
+		}
+	}
+	return ""
+}
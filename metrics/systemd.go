@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// unitProperties reads the properties specific to the type of the unit of a cgroup,
+// such as the properties of the Slice or Scope interface.
+func unitProperties(ctx context.Context, conn *systemd.Conn, cg string) (map[string]any, error) {
+	unit := path.Base(cg)
+	ext := strings.TrimPrefix(path.Ext(unit), ".")
+	if ext == "" {
+		ext = "slice"
+	}
+	return conn.GetUnitTypePropertiesContext(ctx, unit, strings.ToUpper(ext[:1])+ext[1:])
+}
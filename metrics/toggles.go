@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// metric groups that can be toggled on or off
+const (
+	UnitProps   = "unit-props"
+	CGroupStats = "cgroupfs-stats"
+	ProcCPU     = "proc-cpu"
+	ProcMemory  = "proc-memory"
+	Pressure    = "pressure"
+	Sessions    = "sessions"
+	UserUnits   = "user-units"
+	Containers  = "containers"
+	GPU         = "gpu"
+	FSIO        = "fs-io"
+	OOMD        = "oomd"
+	ProcIO      = "proc-io"
+	ProcThreads = "proc-threads"
+	ProcWchan   = "proc-wchan"
+	ProcFDs     = "proc-fds"
+	Controllers = "controllers"
+	ProcFaults  = "proc-faults"
+	ProcSwitch  = "proc-switches"
+	ProcStates  = "proc-states"
+	ProcAge     = "proc-age"
+	ProcSched   = "proc-sched"
+)
+
+var MetricGroups = []string{UnitProps, CGroupStats, ProcCPU, ProcMemory, Pressure, Sessions, UserUnits, Containers, GPU, FSIO, OOMD, ProcIO, ProcThreads, ProcWchan, ProcFDs, Controllers, ProcFaults, ProcSwitch, ProcStates, ProcAge, ProcSched}
+
+// metric groups that require reading the processes of a cgroup from /proc
+var procGroups = []string{ProcCPU, ProcMemory, ProcIO, ProcThreads, ProcWchan, ProcFDs, ProcFaults, ProcSwitch, ProcStates, ProcAge, ProcSched}
+
+var DefaultMetricGroups = []string{UnitProps, CGroupStats, ProcCPU, ProcMemory}
+
+// Toggles is the set of metric groups enabled for a cgroup.
+type Toggles map[string]bool
+
+// override enables and disables groups for the units matching its pattern
+type override struct {
+	pattern string
+	groups  Toggles
+}
+
+// anyProc reports whether any metric group requiring /proc is enabled
+func (t Toggles) anyProc() bool {
+	for _, group := range procGroups {
+		if t[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectionConfig determines which metric groups are collected for each cgroup.
+type CollectionConfig struct {
+	defaults  Toggles
+	overrides []override
+}
+
+// Collection is consulted by the collector for every cgroup it collects.
+var Collection, _ = NewCollectionConfig(DefaultMetricGroups, "")
+
+// NewCollectionConfig creates a collection configuration from the globally enabled
+// groups and a list of overrides of the form 'pattern=+group,-group;pattern=...'.
+// Patterns are matched against the unit name, and later overrides take precedence,
+// as do later groups of the same override.
+func NewCollectionConfig(enabled []string, overrides string) (*CollectionConfig, error) {
+	cc := &CollectionConfig{defaults: make(Toggles)}
+
+	for _, group := range enabled {
+		group = strings.TrimSpace(group)
+		if !slices.Contains(MetricGroups, group) {
+			return nil, fmt.Errorf("unknown metric group '%s'. Options include %v", group, MetricGroups)
+		}
+		cc.defaults[group] = true
+	}
+
+	for _, entry := range strings.Split(overrides, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		pattern, groups, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid collection override '%s', expected pattern=+group,-group", entry)
+		}
+
+		o := override{pattern: strings.TrimSpace(pattern), groups: make(Toggles)}
+		if _, err := path.Match(o.pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid collection override pattern '%s': %v", o.pattern, err)
+		}
+
+		for _, group := range strings.Split(groups, ",") {
+			group = strings.TrimSpace(group)
+			name, disable := strings.CutPrefix(group, "-")
+			if !disable {
+				name = strings.TrimPrefix(group, "+")
+			}
+			if !slices.Contains(MetricGroups, name) {
+				return nil, fmt.Errorf("unknown metric group '%s' in override '%s'", name, entry)
+			}
+			o.groups[name] = !disable
+		}
+		cc.overrides = append(cc.overrides, o)
+	}
+
+	return cc, nil
+}
+
+// For returns the metric groups enabled for the given cgroup.
+func (cc *CollectionConfig) For(cg string) Toggles {
+	toggles := make(Toggles, len(MetricGroups))
+	for group, enabled := range cc.defaults {
+		toggles[group] = enabled
+	}
+
+	unit := path.Base(cg)
+	for _, o := range cc.overrides {
+		if ok, _ := path.Match(o.pattern, unit); !ok {
+			continue
+		}
+		for group, enabled := range o.groups {
+			toggles[group] = enabled
+		}
+	}
+
+	return toggles
+}
+
+// Enabled returns whether a metric group is collected for any unit.
+func (cc *CollectionConfig) Enabled(group string) bool {
+	if cc.defaults[group] {
+		return true
+	}
+	for _, o := range cc.overrides {
+		if o.groups[group] {
+			return true
+		}
+	}
+	return false
+}
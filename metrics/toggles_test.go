@@ -0,0 +1,124 @@
+package metrics
+
+import "testing"
+
+func TestNewCollectionConfigErrors(t *testing.T) {
+	tests := []struct {
+		enabled   []string
+		overrides string
+	}{
+		{enabled: []string{"bogus"}},
+		{enabled: []string{ProcCPU, ""}},
+		{overrides: "user-*.slice=+bogus"},
+		{overrides: "user-*.slice"},
+		{overrides: "user-*.slice="},
+		{overrides: "user-*.slice=+gpu,"},
+		{overrides: "user-*.slice=+-gpu"},
+		{overrides: "user-*.slice=--gpu"},
+		{overrides: "user-[.slice=+gpu"},
+		{overrides: "user-1000.slice=+gpu;user-*.slice=-bogus"},
+	}
+
+	for _, test := range tests {
+		_, err := NewCollectionConfig(test.enabled, test.overrides)
+		if err == nil {
+			t.Errorf("NewCollectionConfig(%q, %q) succeeded, expected an error", test.enabled, test.overrides)
+		}
+	}
+}
+
+func TestCollectionConfigFor(t *testing.T) {
+	enabled := []string{UnitProps, ProcCPU}
+	tests := []struct {
+		overrides string
+		cg        string
+		want      Toggles
+	}{
+		{
+			cg:   "/user.slice/user-1000.slice",
+			want: Toggles{UnitProps: true, ProcCPU: true},
+		},
+		{
+			overrides: "user-1000.slice=+pressure,-proc-cpu",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, Pressure: true},
+		},
+		{
+			overrides: "user-1000.slice=+pressure,-proc-cpu",
+			cg:        "/user.slice/user-1001.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true},
+		},
+		{
+			// a group without a sign is enabled
+			overrides: " user-1000.slice = gpu , +fs-io ",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true, GPU: true, FSIO: true},
+		},
+		{
+			// later overrides take precedence
+			overrides: "user-*.slice=-proc-cpu;user-1000.slice=+proc-cpu",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true},
+		},
+		{
+			overrides: "user-1000.slice=+proc-cpu;user-*.slice=-proc-cpu",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true},
+		},
+		{
+			overrides: "user-*.slice=-proc-cpu;user-1000.slice=+proc-cpu",
+			cg:        "/user.slice/user-1001.slice",
+			want:      Toggles{UnitProps: true},
+		},
+		{
+			// as do later groups of the same override
+			overrides: "user-*.slice=-gpu,+gpu",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true, GPU: true},
+		},
+		{
+			overrides: "user-*.slice=+gpu,-gpu",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true},
+		},
+		{
+			overrides: "user-*.slice=+gpu;;",
+			cg:        "/user.slice/user-1000.slice",
+			want:      Toggles{UnitProps: true, ProcCPU: true, GPU: true},
+		},
+	}
+
+	for _, test := range tests {
+		cc, err := NewCollectionConfig(enabled, test.overrides)
+		if err != nil {
+			t.Fatalf("NewCollectionConfig(%q, %q): %v", enabled, test.overrides, err)
+		}
+
+		got := cc.For(test.cg)
+		for _, group := range MetricGroups {
+			if got[group] != test.want[group] {
+				t.Errorf("overrides %q: %s of %s is %v, expected %v", test.overrides, group, test.cg, got[group], test.want[group])
+			}
+		}
+	}
+}
+
+func TestCollectionConfigEnabled(t *testing.T) {
+	cc, err := NewCollectionConfig([]string{UnitProps, ProcCPU}, "user-1000.slice=+gpu,-proc-cpu;user-*.slice=+oomd,-oomd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		UnitProps: true,
+		ProcCPU:   true, // still collected for the other units
+		GPU:       true,
+		OOMD:      false,
+		Pressure:  false,
+	}
+	for group, want := range tests {
+		if got := cc.Enabled(group); got != want {
+			t.Errorf("Enabled(%s) = %v, expected %v", group, got, want)
+		}
+	}
+}
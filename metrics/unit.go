@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/authorizer"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/containerd/cgroups/v3"
+	"github.com/containerd/cgroups/v3/cgroup1"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// limits and accounting properties of units reported by the unit endpoint
+var unitLimitProperties = []string{
+	"CPUAccounting", "CPUQuotaPerSecUSec", "CPUWeight", "CPUShares",
+	"MemoryAccounting", "MemoryHigh", "MemoryMax", "MemorySwapMax", "MemoryLow", "MemoryMin",
+	"TasksAccounting", "TasksMax", "IOAccounting",
+	"IOReadBandwidthMax", "IOWriteBandwidthMax", "IOReadIOPSMax", "IOWriteIOPSMax",
+}
+
+type unitPressure struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+	IO     float64 `json:"io"`
+}
+
+type unitReport struct {
+	Unit             string         `json:"unit"`
+	Username         string         `json:"username"`
+	CPUUsageSeconds  float64        `json:"cpuUsageSeconds"`
+	CPUQuota         int64          `json:"cpuQuota"`
+	MemoryUsageBytes uint64         `json:"memoryUsageBytes"`
+	MemoryMax        float64        `json:"memoryMax"`
+	SwapMax          float64        `json:"swapMax"`
+	Processes        int            `json:"processes"`
+	Pressure         *unitPressure  `json:"pressure,omitempty"`
+	Descendants      *uint64        `json:"descendants,omitempty"`
+	DyingDescendants *uint64        `json:"dyingDescendants,omitempty"`
+	Controllers      []string       `json:"controllers,omitempty"`
+	SubtreeControl   []string       `json:"subtreeControl,omitempty"`
+	Properties       map[string]any `json:"properties,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+// UnitHandler returns the usage and limits of the unit named in the path as
+// collected by the warden, along with its limits as set in systemd, without
+// scraping the metrics of every unit. Only the units collected, those directly
+// underneath the root matching the unit patterns, can be read.
+func UnitHandler(root string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		unit := r.PathValue("name")
+		cg := path.Join(root, unit)
+		report := unitReport{Unit: unit}
+		status := http.StatusOK
+
+		defer func() {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(report)
+		}()
+
+		h := hierarchy.NewHierarchy(root)
+		err := hierarchy.ValidUnit(h, root, unit, UnitPatterns)
+		if err != nil {
+			report.Error = err.Error()
+			status = http.StatusNotFound
+			return
+		}
+
+		info, err := h.CGroupInfo(cg)
+		if err != nil {
+			report.Error = err.Error()
+			status = http.StatusBadRequest
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, cgroup1.ErrCgroupDeleted) {
+				status = http.StatusNotFound
+			}
+			return
+		}
+
+		if !authorizer.Collect(cg, info.Username) {
+			report.Error = "collection of unit not authorized"
+			status = http.StatusForbidden
+			return
+		}
+
+		report.Username = info.Username
+		report.CPUUsageSeconds = info.CPUUsage
+		report.CPUQuota = info.CPUQuota
+		report.MemoryUsageBytes = info.MemoryUsage
+		report.MemoryMax = negativeOneIfMax(info.MemoryMax)
+		report.SwapMax = negativeOneIfMax(info.SwapMax)
+		report.Controllers = info.Controllers
+		report.SubtreeControl = info.SubtreeControl
+
+		if cgroups.Mode() == cgroups.Unified {
+			report.Pressure = &unitPressure{CPU: info.CPUPressure, Memory: info.MemoryPressure, IO: info.IOPressure}
+			report.Descendants = &info.Descendants
+			report.DyingDescendants = &info.DyingDescendants
+		}
+
+		if pids, err := h.Procs(cg); err == nil {
+			report.Processes = len(pids)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		report.Properties, err = unitLimits(ctx, cg)
+		if err != nil {
+			slog.Debug("unable to read unit properties", "cgroup", cg, "err", err)
+		}
+	}
+}
+
+// unitLimits reads the limits and accounting properties of the unit from systemd
+func unitLimits(ctx context.Context, cg string) (map[string]any, error) {
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	props, err := unitProperties(ctx, conn, cg)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]any)
+	for _, name := range unitLimitProperties {
+		value, ok := props[name]
+		if !ok {
+			continue
+		}
+		if v, ok := value.(uint64); ok && v == math.MaxUint64 {
+			value = "infinity"
+		}
+		limits[name] = value
+	}
+	return limits, nil
+}
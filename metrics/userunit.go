@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maximum depth walked underneath user@UID.service, enough for the slices the
+// user manager creates (app.slice, session.slice, ...) and the units within them.
+const userUnitDepth = 2
+
+// collectUserUnits emits metrics for the units of the per-user systemd manager,
+// such as those started with 'systemd-run --user', labeled by their path
+// relative to the user@UID.service unit.
+func (c *Collector) collectUserUnits(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, cg string, username string) {
+	children, err := h.Children(cg)
+	if err != nil {
+		slog.Debug("unable to list user manager", "cgroup", cg, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		name := path.Base(child)
+		if strings.HasPrefix(name, "user@") && strings.HasSuffix(name, ".service") {
+			c.walkUserUnits(ch, h, cg, child, child, username, 1)
+		}
+	}
+}
+
+func (c *Collector) walkUserUnits(ch chan<- prometheus.Metric, h hierarchy.Hierarchy, unit string, manager string, cg string, username string, depth int) {
+	children, err := h.Children(cg)
+	if err != nil {
+		slog.Debug("unable to list user units", "cgroup", cg, "err", err)
+		return
+	}
+
+	for _, child := range children {
+		name := path.Base(child)
+		if !strings.HasSuffix(name, ".slice") && !strings.HasSuffix(name, ".service") && !strings.HasSuffix(name, ".scope") {
+			continue
+		}
+
+		c.userUnits.collect(ch, h, unit, child, username, strings.TrimPrefix(child, manager+"/"))
+
+		if depth < userUnitDepth && strings.HasSuffix(name, ".slice") {
+			c.walkUserUnits(ch, h, unit, manager, child, username, depth+1)
+		}
+	}
+}
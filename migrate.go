@@ -0,0 +1,67 @@
+//go:build !readonly
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/state"
+)
+
+// runMigrate implements the migrate subcommand, importing the limits already
+// set on units into the desired state.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	root := fs.String("root", envOr("CGROUP_WARDEN_ROOT_CGROUP", "/user.slice"), "import units underneath this cgroup")
+	file := fs.String("state", envOr("CGROUP_WARDEN_STATE_FILE", defaultStateFile), "path of the desired state file")
+	pattern := fs.String("pattern", "*", "only import units matching this glob pattern")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without writing the desired state")
+	force := fs.Bool("force", false, "overwrite conflicting properties in the desired state")
+	fs.Parse(args)
+
+	desired, err := state.Load(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	report, err := control.Migrate(context.Background(), *root, *pattern, desired, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UNIT\tPROPERTY\tVALUE\tSOURCE")
+	for _, p := range report.Imported {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", p.Unit, p.Property, p.Value, p.Source)
+	}
+	tw.Flush()
+
+	if len(report.Conflicts) > 0 {
+		fmt.Println()
+		tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CONFLICT\tPROPERTY\tCURRENT\tDESIRED")
+		for _, c := range report.Conflicts {
+			fmt.Fprintf(tw, "%s\t%s\t%v\t%v\n", c.Unit, c.Property, c.Current, c.Desired)
+		}
+		tw.Flush()
+	}
+
+	if *dryRun {
+		fmt.Printf("\ndry run, %d properties would be imported into %s\n", len(report.Imported), *file)
+		return 0
+	}
+
+	if err := desired.Save(*file); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("\nimported %d properties into %s, %d conflicts\n", len(report.Imported), *file, len(report.Conflicts))
+	return 0
+}
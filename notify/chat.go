@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Chat posts events to a Slack or Mattermost incoming webhook, in the channel of
+// the severity of the event. A channel given as a URL is a webhook of its own,
+// for Slack apps whose webhooks each post to a single channel.
+type Chat struct {
+	URL      string
+	Channels map[string]string
+	Retries  int
+	Client   *http.Client
+}
+
+// how many processes are listed in chat messages
+const chatProcesses = 3
+
+// NewChat returns a chat notifier posting to the webhook, with channels given
+// like "warning=#hpc-ops,critical=#hpc-oncall"
+func NewChat(webhook string, channels string, retries int, timeout time.Duration) (*Chat, error) {
+	if !isWebhookURL(webhook) {
+		return nil, fmt.Errorf("invalid webhook url '%s'", webhook)
+	}
+
+	c := &Chat{URL: webhook, Channels: make(map[string]string), Retries: retries, Client: &http.Client{Timeout: timeout}}
+	for _, entry := range strings.Split(channels, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		severity, channel, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(severity) == "" || strings.TrimSpace(channel) == "" {
+			return nil, fmt.Errorf("invalid channel '%s', expected severity=channel", entry)
+		}
+		c.Channels[strings.TrimSpace(severity)] = strings.TrimSpace(channel)
+	}
+	return c, nil
+}
+
+func isWebhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (c *Chat) Name() string {
+	return "chat"
+}
+
+// chatMessage is the payload of incoming webhooks common to Slack and Mattermost
+type chatMessage struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"`
+}
+
+func (c *Chat) Notify(ctx context.Context, e Event) error {
+	target := c.URL
+	msg := chatMessage{Text: chatText(e)}
+	if channel, ok := c.Channels[e.Severity]; ok {
+		if isWebhookURL(channel) {
+			target = channel
+		} else {
+			msg.Channel = channel
+		}
+	}
+	return PostJSON(ctx, c.Client, target, msg, c.Retries)
+}
+
+// chatText formats an event as a short markdown message
+func chatText(e Event) string {
+	icon := ":warning:"
+	switch {
+	case e.Kind == Resolved:
+		icon = ":white_check_mark:"
+	case e.Severity == "critical":
+		icon = ":rotating_light:"
+	case e.Kind == Action:
+		icon = ":hammer_and_wrench:"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s *%s* on `%s`", icon, e.Kind, hostname())
+	if e.Username != "" {
+		fmt.Fprintf(&b, " for `%s`", e.Username)
+	}
+	fmt.Fprintf(&b, ": %s", e.Summary)
+
+	if len(e.Properties) > 0 {
+		fmt.Fprintf(&b, "\nLimits: `%s`", strings.Join(formatProperties(e.Properties), " "))
+	}
+	for _, p := range e.Processes[:min(len(e.Processes), chatProcesses)] {
+		fmt.Fprintf(&b, "\n> `%d` %s, %.1f MiB", p.PID, truncate(p.commandLine(), 60), float64(p.Memory)/(1<<20))
+	}
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+// Email sends the enforcement actions taken on the units of a user to the user,
+// at an address given by a template like "{{.Username}}@example.com", or printed
+// by a command given the username.
+type Email struct {
+	Server  string
+	From    string
+	Auth    smtp.Auth
+	Address *template.Template
+	Command string
+}
+
+// NewEmail returns an email notifier sending through the SMTP server at the
+// address, authenticated if a username is given
+func NewEmail(server string, from string, address string, command string, username string, password string) (*Email, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server '%s', expected host:port", server)
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender '%s'", from)
+	}
+	if (address == "") == (command == "") {
+		return nil, errors.New("exactly one of an address template or an address command is required")
+	}
+
+	e := &Email{Server: server, From: from, Command: command}
+	if address != "" {
+		e.Address, err = template.New("address").Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address template: %w", err)
+		}
+	}
+	if username != "" {
+		e.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return e, nil
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Notify emails the user of the unit if the event is an action actually taken
+func (e *Email) Notify(ctx context.Context, ev Event) error {
+	if ev.Kind != Action || ev.DryRun || ev.Username == "" {
+		return nil
+	}
+
+	to, err := e.address(ctx, ev)
+	if err != nil {
+		return err
+	}
+
+	msg := emailMessage(e.From, to, ev)
+	return smtp.SendMail(e.Server, e.Auth, e.From, []string{to}, msg)
+}
+
+// address returns the address of the user of the unit
+func (e *Email) address(ctx context.Context, ev Event) (string, error) {
+	var address string
+	if e.Address != nil {
+		var buf bytes.Buffer
+		if err := e.Address.Execute(&buf, ev); err != nil {
+			return "", fmt.Errorf("unable to execute address template: %w", err)
+		}
+		address = buf.String()
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, e.Command, ev.Username).Output()
+		if err != nil {
+			return "", fmt.Errorf("unable to look up address of %s: %w", ev.Username, err)
+		}
+		address = string(out)
+	}
+
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s' for %s", strings.TrimSpace(address), ev.Username)
+	}
+	return parsed.Address, nil
+}
+
+// emailMessage formats an event as a plain text message explaining what happened
+// to the unit, the limits now applied, and the processes it was running
+func emailMessage(from string, to string, ev Event) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: Resource limits of %s on %s\r\n", ev.Username, hostname())
+	fmt.Fprintf(&buf, "Date: %s\r\n", ev.Time.Format(time.RFC1123Z))
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&buf, "Hello %s,\r\n\r\n", ev.Username)
+	fmt.Fprintf(&buf, "At %s, the warden took action on your processes on %s:\r\n\r\n", ev.Time.Format(time.DateTime), hostname())
+	fmt.Fprintf(&buf, "    %s\r\n\r\n", ev.Summary)
+
+	if len(ev.Properties) > 0 {
+		buf.WriteString("The limits now applied are:\r\n\r\n")
+		for _, line := range formatProperties(ev.Properties) {
+			fmt.Fprintf(&buf, "    %s\r\n", line)
+		}
+		buf.WriteString("\r\n")
+	}
+
+	if len(ev.Processes) > 0 {
+		buf.WriteString("Your processes using the most memory were:\r\n\r\n")
+		tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		fmt.Fprint(tw, "    PID\tMEMORY\tCPU TIME\tCOMMAND\r\n")
+		for _, p := range ev.Processes {
+			fmt.Fprintf(tw, "    %d\t%.1f MiB\t%s\t%s\r\n", p.PID, float64(p.Memory)/(1<<20), time.Duration(p.CPUSeconds*float64(time.Second)).Round(time.Second), truncate(p.commandLine(), 80))
+		}
+		tw.Flush()
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("Please reduce the resources used by your processes, or contact your administrators if you need more.\r\n")
+	return buf.Bytes()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
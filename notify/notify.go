@@ -0,0 +1,118 @@
+// Package notify delivers the violations detected, and the enforcement actions
+// taken, by the warden to external services.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/status"
+)
+
+// kinds of events, which are part of the payload of notifications
+const (
+	Violation = "violation"
+	Resolved  = "resolved"
+	Action    = "action"
+)
+
+// Event is a violation of a rule by a unit, or an action taken on a unit
+type Event struct {
+	Kind       string         `json:"kind"`
+	Unit       string         `json:"unit"`
+	Cgroup     string         `json:"cgroup"`
+	Username   string         `json:"username"`
+	Severity   string         `json:"severity"`
+	Summary    string         `json:"summary"`
+	Rule       string         `json:"rule,omitempty"`
+	Action     string         `json:"action,omitempty"`
+	Properties map[string]any `json:"properties,omitempty"`
+	Processes  []Process      `json:"processes,omitempty"`
+	DryRun     bool           `json:"dryRun,omitempty"`
+	Time       time.Time      `json:"time"`
+}
+
+// Notifier delivers events to a service
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, e Event) error
+}
+
+var (
+	notifiers []Notifier
+	mutex     sync.Mutex
+)
+
+// how long delivering an event to a notifier may take, including retries
+const deliveryTimeout = 5 * time.Minute
+
+// Register adds a notifier events are delivered to
+func Register(n Notifier) {
+	defer mutex.Unlock()
+	mutex.Lock()
+	notifiers = append(notifiers, n)
+}
+
+// Send delivers the event to every notifier in the background, unless the unit or
+// its user is silenced.
+func Send(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if s, ok := admin.Silenced(e.Cgroup, e.Username); ok {
+		slog.Debug("notification silenced", "unit", e.Unit, "kind", e.Kind, "silence", s.ID)
+		return
+	}
+
+	mutex.Lock()
+	targets := notifiers
+	mutex.Unlock()
+
+	for _, n := range targets {
+		go deliver(n, e)
+	}
+}
+
+// hostname returns the name of the node, as included in messages to users
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "this node"
+	}
+	return name
+}
+
+// formatProperties formats properties as sorted lines of a name and a value
+func formatProperties(properties map[string]any) []string {
+	lines := make([]string, 0, len(properties))
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		value := properties[name]
+		if v, ok := value.(float64); ok {
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		lines = append(lines, fmt.Sprintf("%s=%v", name, value))
+	}
+	return lines
+}
+
+func deliver(n Notifier, e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	err := n.Notify(ctx, e)
+	if err != nil {
+		slog.Warn("unable to deliver notification", "notifier", n.Name(), "unit", e.Unit, "kind", e.Kind, "err", err)
+		status.Report(status.Notify, e.Unit, err)
+		return
+	}
+	slog.Debug("delivered notification", "notifier", n.Name(), "unit", e.Unit, "kind", e.Kind)
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"cmp"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/prometheus/procfs"
+)
+
+// Process is a process of a unit, as included in notifications
+type Process struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	Command    string  `json:"command"`
+	CPUSeconds float64 `json:"cpuSeconds"`
+	Memory     int     `json:"memoryBytes"`
+}
+
+// commandLine returns the command line of the process, or its name if it has none
+func (p Process) commandLine() string {
+	if p.Command != "" {
+		return p.Command
+	}
+	return p.Name
+}
+
+// how many processes are included in notifications
+const maxProcesses = 10
+
+// UnitProcesses returns the processes of the unit using the most memory
+func UnitProcesses(cgroupRoot string, unit string) []Process {
+	h := hierarchy.NewHierarchy(cgroupRoot)
+	pids, err := h.Procs(path.Join(cgroupRoot, unit))
+	if err != nil {
+		return nil
+	}
+
+	fs, err := procfs.NewDefaultFS()
+	if err != nil {
+		return nil
+	}
+
+	var procs []Process
+	for _, pid := range pids {
+		proc, err := fs.Proc(int(pid))
+		if err != nil {
+			continue
+		}
+		stat, err := proc.Stat()
+		if err != nil {
+			continue
+		}
+
+		p := Process{PID: int(pid), Name: stat.Comm, CPUSeconds: stat.CPUTime(), Memory: stat.ResidentMemory()}
+		if args, err := proc.CmdLine(); err == nil && len(args) > 0 {
+			p.Command = strings.Join(args, " ")
+		}
+		procs = append(procs, p)
+	}
+
+	slices.SortFunc(procs, func(a, b Process) int {
+		return cmp.Compare(b.Memory, a.Memory)
+	})
+	return procs[:min(len(procs), maxProcesses)]
+}
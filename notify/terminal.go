@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Terminal writes the enforcement actions taken on the units of a user to the
+// terminals the user is logged in on, like write(1). Terminals the user disabled
+// messages on with mesg(1) are skipped.
+type Terminal struct{}
+
+func (t *Terminal) Name() string {
+	return "terminal"
+}
+
+// terminal devices users log in on
+var terminalGlobs = []string{"/dev/pts/[0-9]*", "/dev/tty[0-9]*"}
+
+func (t *Terminal) Notify(ctx context.Context, e Event) error {
+	if e.Kind != Action || e.DryRun || e.Username == "" {
+		return nil
+	}
+
+	u, err := user.Lookup(e.Username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	msg := terminalMessage(e)
+	var errs []error
+	for _, tty := range userTerminals(uint32(uid)) {
+		if err := writeTerminal(tty, msg); err != nil {
+			errs = append(errs, fmt.Errorf("unable to write to %s: %w", tty, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// userTerminals returns the terminals owned by the uid that accept messages
+func userTerminals(uid uint32) []string {
+	var ttys []string
+	for _, glob := range terminalGlobs {
+		matches, _ := filepath.Glob(glob)
+		for _, tty := range matches {
+			info, err := os.Stat(tty)
+			if err != nil {
+				continue
+			}
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || stat.Uid != uid {
+				continue
+			}
+			// mesg n removes write access for the group
+			if info.Mode().Perm()&0o020 == 0 {
+				continue
+			}
+			ttys = append(ttys, tty)
+		}
+	}
+	return ttys
+}
+
+func writeTerminal(tty string, msg string) error {
+	// a terminal that is not read from must not block the notification
+	f, err := os.OpenFile(tty, os.O_WRONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(msg)
+	return err
+}
+
+// terminalMessage formats an event like a message of wall(1)
+func terminalMessage(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r\n\a\aMessage from cgroup-warden@%s at %s ...\r\n", hostname(), e.Time.Format(time.TimeOnly))
+	fmt.Fprintf(&b, "%s\r\n", e.Summary)
+	if len(e.Properties) > 0 {
+		fmt.Fprintf(&b, "Limits now applied: %s\r\n", strings.Join(formatProperties(e.Properties), " "))
+	}
+	b.WriteString("EOF\r\n")
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// Webhook posts events as JSON to a URL, or as the output of a template given
+// the event, retrying failed deliveries with an exponential backoff.
+type Webhook struct {
+	URL      string
+	Template *template.Template
+	Retries  int
+	Client   *http.Client
+}
+
+// functions available to webhook templates
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+}
+
+// NewWebhook returns a webhook posting to the URL, with the body produced by the
+// template file if one is given
+func NewWebhook(webhook string, templateFile string, retries int, timeout time.Duration) (*Webhook, error) {
+	if !isWebhookURL(webhook) {
+		return nil, fmt.Errorf("invalid webhook url '%s'", webhook)
+	}
+	if retries < 0 {
+		return nil, errors.New("retries must not be negative")
+	}
+
+	w := &Webhook{URL: webhook, Retries: retries, Client: &http.Client{Timeout: timeout}}
+	if templateFile != "" {
+		var err error
+		w.Template, err = template.New(filepath.Base(templateFile)).Funcs(templateFuncs).ParseFiles(templateFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	if w.Template == nil {
+		return PostJSON(ctx, w.Client, w.URL, e, w.Retries)
+	}
+
+	var body bytes.Buffer
+	if err := w.Template.Execute(&body, e); err != nil {
+		return fmt.Errorf("unable to execute template: %w", err)
+	}
+	return postRetrying(ctx, w.Client, w.URL, body.Bytes(), w.Retries)
+}
+
+// PostJSON posts a value encoded as JSON to a URL, retrying failed deliveries up
+// to the given number of times with an exponential backoff
+func PostJSON(ctx context.Context, client *http.Client, target string, v any, retries int) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return postRetrying(ctx, client, target, body, retries)
+}
+
+// errPermanent wraps errors that retrying does not fix
+var errPermanent = errors.New("permanent failure")
+
+// postRetrying posts a JSON body to a URL, retrying up to the given number of times
+func postRetrying(ctx context.Context, client *http.Client, target string, body []byte, retries int) error {
+	backoff := time.Second
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = post(ctx, client, target, body)
+		if err == nil || errors.Is(err, errPermanent) || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func post(ctx context.Context, client *http.Client, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%s responded with %s", target, resp.Status)
+	default:
+		return fmt.Errorf("%w: %s responded with %s", errPermanent, target, resp.Status)
+	}
+}
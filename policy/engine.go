@@ -0,0 +1,379 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// Engine applies the limits of a policy to the units underneath a root cgroup
+type Engine struct {
+	root   string
+	policy *Policy
+
+	// report the limits that would be set instead of setting them
+	dryRun bool
+
+	// units the limits have been applied to, and those that are exempt
+	applied map[string]bool
+	exempt  map[string]bool
+	mutex   sync.Mutex
+
+	// names of the schedules active when the limits were last applied
+	schedules []string
+
+	// last cpu usage of the units evaluated, only used by the loop of Run
+	cpuSamples map[string]cpuSample
+}
+
+// NewEngine returns an engine for the policy, which only reports what it would
+// do if dryRun is set or the policy is a dry run
+func NewEngine(root string, p *Policy, dryRun bool) *Engine {
+	active.Store(p)
+	return &Engine{
+		root:       root,
+		policy:     p,
+		dryRun:     dryRun || p.DryRun,
+		applied:    make(map[string]bool),
+		exempt:     make(map[string]bool),
+		schedules:  p.ActiveSchedules(time.Now()),
+		cpuSamples: make(map[string]cpuSample),
+	}
+}
+
+// Run applies the limits to units as they appear, until the context is done.
+// New units are noticed through the signals of systemd, and by listing the units
+// every interval, which also catches any signal missed. The hooks of the policy
+// are run in the background.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if e.dryRun {
+		slog.Info("policy is a dry run, units will not be changed")
+	}
+	ctx = audit.WithActor(ctx, "policy")
+
+	if len(e.policy.Hooks) > 0 {
+		go e.watchEvents(ctx, eventInterval)
+	}
+
+	var events <-chan unitEvent
+	warned := false
+	for {
+		if events == nil {
+			var err error
+			events, err = watchUnits(ctx)
+			if err != nil && !warned {
+				slog.Warn("unable to watch for new units, listing them every interval instead", "interval", interval, "err", err)
+				warned = true
+			}
+		}
+
+		e.reconcile(ctx)
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break wait
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					break wait
+				}
+				e.handle(ctx, event)
+			}
+		}
+	}
+}
+
+// reconcile applies the limits to every unit underneath the root they have not
+// been applied to, evaluates the rules and penalties of the units they have, and
+// forgets the units that are gone. When a schedule starts or ends, the limits
+// are applied again to every unit, and when the pins of a unit change, to the
+// unit.
+func (e *Engine) reconcile(ctx context.Context) {
+	if units := pins.takeChanged(); len(units) > 0 {
+		e.mutex.Lock()
+		for _, unit := range units {
+			if !e.exempt[unit] {
+				delete(e.applied, unit)
+			}
+		}
+		e.mutex.Unlock()
+	}
+
+	if schedules := e.policy.ActiveSchedules(time.Now()); !slices.Equal(schedules, e.schedules) {
+		slog.Info("active limit schedules changed", "from", e.schedules, "to", schedules)
+		e.schedules = schedules
+
+		e.mutex.Lock()
+		for unit := range e.applied {
+			if !e.exempt[unit] {
+				delete(e.applied, unit)
+			}
+		}
+		e.mutex.Unlock()
+	}
+
+	h := hierarchy.NewHierarchy(e.root)
+	children, err := h.Children(e.root)
+	if err != nil {
+		slog.Warn("unable to list units", "root", e.root, "err", err)
+		status.Report(status.Remediation, e.root, err)
+		return
+	}
+
+	present := make(map[string]bool, len(children))
+	for _, cg := range children {
+		unit := path.Base(cg)
+		present[unit] = true
+		if !e.policy.Matches(unit) || e.tagExempt(unit) {
+			continue
+		}
+		if !e.isApplied(unit) {
+			e.apply(ctx, unit)
+		} else if (len(e.policy.Rules) > 0 || e.policy.Penalties != nil) && !e.isExempt(unit) {
+			e.evaluate(ctx, unit)
+		}
+	}
+
+	isPresent := func(cg string) bool {
+		return present[path.Base(cg)]
+	}
+	offenders.retain(isPresent)
+	violations.retain(isPresent)
+	enforced.retain(isPresent)
+	for cg := range e.cpuSamples {
+		if !isPresent(cg) {
+			delete(e.cpuSamples, cg)
+		}
+	}
+
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	for unit := range e.applied {
+		if !present[unit] {
+			delete(e.applied, unit)
+			delete(e.exempt, unit)
+		}
+	}
+}
+
+func (e *Engine) handle(ctx context.Context, event unitEvent) {
+	if !e.policy.Matches(event.unit) {
+		return
+	}
+
+	if event.removed {
+		e.mutex.Lock()
+		delete(e.applied, event.unit)
+		delete(e.exempt, event.unit)
+		e.mutex.Unlock()
+		return
+	}
+
+	if !e.isApplied(event.unit) && !e.tagExempt(event.unit) {
+		e.apply(ctx, event.unit)
+	}
+}
+
+func (e *Engine) isApplied(unit string) bool {
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	return e.applied[unit]
+}
+
+func (e *Engine) isExempt(unit string) bool {
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	return e.exempt[unit]
+}
+
+// tagExempt reports whether the unit has a tag exempting it from the policy. Its
+// limits are applied once the tag is removed, while limits applied before it was
+// tagged stay in place.
+func (e *Engine) tagExempt(unit string) bool {
+	tag, ok := e.policy.Exempt.tag(unit)
+	if ok {
+		slog.Debug("unit exempt from policy", "unit", unit, "reason", "tag "+tag)
+	}
+	return ok
+}
+
+// apply sets the limits of the policy effective for the unit at runtime, with the
+// pinned values of the unit replacing them
+func (e *Engine) apply(ctx context.Context, unit string) {
+	s := NewSubject(e.root, unit)
+	if reason, ok := e.policy.Exempted(s); ok {
+		slog.Debug("unit exempt from policy", "unit", unit, "reason", reason)
+		e.mutex.Lock()
+		e.exempt[unit] = true
+		e.mutex.Unlock()
+		e.markApplied(unit)
+		return
+	}
+
+	// the limits of the tier of a penalized unit replace those of the policy
+	cg := path.Join(e.root, unit)
+	if o, ok := offenders.get(cg); ok && o.tier > 0 {
+		if e.setTier(ctx, unit, &o, o.tier) {
+			e.markApplied(unit)
+		}
+		offenders.put(cg, o)
+		return
+	}
+
+	limits, _ := e.policy.Limits(s, time.Now())
+	limits, pinned := withPins(cg, limits)
+	if len(limits) == 0 {
+		// nothing to set, but the rules and penalties of the unit are evaluated
+		// once it is applied
+		e.markApplied(unit)
+		return
+	}
+
+	err := e.setProperties(ctx, unit, limits)
+	if err != nil {
+		slog.Warn("unable to apply policy limits", "unit", unit, "err", err)
+		status.Report(status.Remediation, unit, err)
+		return
+	}
+
+	slog.Info("applied policy limits", "unit", unit, "properties", len(limits), "dryRun", e.dryRun)
+	if !e.dryRun {
+		enforced.clear(cg, sourcePin)
+		enforced.set(cg, limits, sourcePolicy)
+		enforced.set(cg, pinned, sourcePin)
+	}
+	e.markApplied(unit)
+}
+
+// setProperties sets the properties of the unit at runtime, or only logs them in
+// a dry run
+func (e *Engine) setProperties(ctx context.Context, unit string, values map[string]any) error {
+	if e.dryRun {
+		slog.Info("dry run, not setting properties", "unit", unit, "properties", values)
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			audit.Record(ctx, audit.Entry{Action: "set", Unit: unit, Property: name, New: values[name], DryRun: true}, nil)
+		}
+		return nil
+	}
+
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	return control.SetUnitProperties(ctx, conn, e.root, unit, values, true)
+}
+
+func (e *Engine) markApplied(unit string) {
+	defer e.mutex.Unlock()
+	e.mutex.Lock()
+	e.applied[unit] = true
+}
+
+// notifyAction records an enforcement action taken on a unit by the engine, or
+// that would have been taken in a dry run, in the audit log and notifies of it
+func (e *Engine) notifyAction(ctx context.Context, unit string, action string, summary string, properties map[string]any) {
+	audit.Record(ctx, audit.Entry{Action: action, Unit: unit, Summary: summary, DryRun: e.dryRun}, nil)
+
+	cg := path.Join(e.root, unit)
+	username, _ := hierarchy.UnitUsername(cg)
+	if e.dryRun {
+		summary = "dry run: " + summary
+	}
+	notify.Send(notify.Event{
+		Kind:       notify.Action,
+		Unit:       unit,
+		Cgroup:     cg,
+		Username:   username,
+		Severity:   "warning",
+		Summary:    summary,
+		Action:     action,
+		Properties: properties,
+		Processes:  notify.UnitProcesses(e.root, unit),
+		DryRun:     e.dryRun,
+	})
+}
+
+// unitEvent is a unit loaded into or removed from systemd
+type unitEvent struct {
+	unit    string
+	removed bool
+}
+
+// watchUnits subscribes to the UnitNew and UnitRemoved signals of systemd. The
+// channel is closed if the connection to the bus is lost.
+func watchUnits(ctx context.Context) (<-chan unitEvent, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range []string{"UnitNew", "UnitRemoved"} {
+		err = conn.AddMatchSignalContext(ctx, dbus.WithMatchInterface("org.freedesktop.systemd1.Manager"), dbus.WithMatchMember(member))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// systemd only emits unit signals while a client is subscribed
+	err = conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1").CallWithContext(ctx, "org.freedesktop.systemd1.Manager.Subscribe", 0).Err
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	signals := make(chan *dbus.Signal, 64)
+	conn.Signal(signals)
+
+	events := make(chan unitEvent)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case signal, ok := <-signals:
+				if !ok || signal == nil {
+					return
+				}
+				if len(signal.Body) == 0 {
+					continue
+				}
+				unit, ok := signal.Body[0].(string)
+				if !ok {
+					continue
+				}
+				event := unitEvent{unit: unit, removed: signal.Name == "org.freedesktop.systemd1.Manager.UnitRemoved"}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestApplyPenaltiesOnlyPolicy(t *testing.T) {
+	file := path.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(file, []byte(`{
+		"units": ["user-*.slice"],
+		"penalties": {"cpu": 2, "tiers": [{"limits": {"CPUQuotaPerSecUSec": "100%"}}]}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine(t.TempDir(), p, true)
+	e.apply(context.Background(), "user-1000.slice")
+	if !e.isApplied("user-1000.slice") {
+		t.Fatal("unit of a policy without limits is not applied, so its penalties are never evaluated")
+	}
+}
@@ -0,0 +1,179 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+	"github.com/chpc-uofu/cgroup-warden/status"
+)
+
+// Hook reacts to a counter of memory.events of a unit increasing, like a process
+// being killed by the OOM killer, by posting the event to a webhook and setting
+// properties of the unit.
+type Hook struct {
+	Event   string         `json:"event"`
+	Webhook string         `json:"webhook"`
+	Limits  map[string]any `json:"limits"`
+}
+
+// counters of memory.events hooks can react to
+var memoryEvents = []string{"low", "high", "max", "oom", "oom_kill", "oom_group_kill"}
+
+// how often memory.events of the units is read
+const eventInterval = 10 * time.Second
+
+// client posting memory events to the webhooks of hooks
+var hookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (hook *Hook) validate() error {
+	if !slices.Contains(memoryEvents, hook.Event) {
+		return fmt.Errorf("invalid event '%s', expected one of %v", hook.Event, memoryEvents)
+	}
+	if hook.Webhook == "" && len(hook.Limits) == 0 {
+		return errors.New("hook has no webhook or limits")
+	}
+	if hook.Webhook != "" {
+		u, err := url.Parse(hook.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid webhook '%s'", hook.Webhook)
+		}
+	}
+	for name, value := range hook.Limits {
+		if err := control.ValidateProperty(name, value); err != nil {
+			return fmt.Errorf("invalid limit %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// MemoryEvent is an increase of a counter of memory.events of a unit, as posted
+// to webhooks
+type MemoryEvent struct {
+	Unit     string    `json:"unit"`
+	Cgroup   string    `json:"cgroup"`
+	Username string    `json:"username"`
+	Event    string    `json:"event"`
+	Count    uint64    `json:"count"`
+	Total    uint64    `json:"total"`
+	Time     time.Time `json:"time"`
+}
+
+// watchEvents reads memory.events of the units the policy applies to every
+// interval, until the context is done, and runs the hooks of every counter that
+// increased since it was last read.
+func (e *Engine) watchEvents(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := make(map[string]map[string]uint64)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h := hierarchy.NewHierarchy(e.root)
+		children, err := h.Children(e.root)
+		if err != nil {
+			slog.Debug("unable to list units", "root", e.root, "err", err)
+			continue
+		}
+
+		current := make(map[string]map[string]uint64, len(children))
+		for _, cg := range children {
+			unit := path.Base(cg)
+			if !e.policy.Matches(unit) {
+				continue
+			}
+
+			counters, err := h.MemoryEvents(cg)
+			if err != nil {
+				slog.Debug("unable to read memory events", "cgroup", cg, "err", err)
+				continue
+			}
+			current[cg] = counters
+
+			// the first reading of a unit is the baseline
+			last, ok := previous[cg]
+			if !ok {
+				continue
+			}
+			for _, event := range memoryEvents {
+				if counters[event] > last[event] {
+					e.memoryEvent(ctx, unit, cg, event, counters[event]-last[event], counters[event])
+				}
+			}
+		}
+		previous = current
+	}
+}
+
+func (e *Engine) memoryEvent(ctx context.Context, unit string, cg string, event string, count uint64, total uint64) {
+	username, _ := hierarchy.UnitUsername(cg)
+	slog.Warn("memory event", "unit", unit, "username", username, "event", event, "count", count, "total", total)
+
+	if e.isExempt(unit) || e.tagExempt(unit) {
+		return
+	}
+
+	ev := MemoryEvent{Unit: unit, Cgroup: cg, Username: username, Event: event, Count: count, Total: total, Time: time.Now()}
+	for _, hook := range e.policy.Hooks {
+		if hook.Event != event {
+			continue
+		}
+		if hook.Webhook != "" {
+			if err := notify.PostJSON(ctx, hookClient, hook.Webhook, ev, 0); err != nil {
+				slog.Warn("unable to post memory event to webhook", "unit", unit, "webhook", hook.Webhook, "err", err)
+				status.Report(status.Remediation, unit, err)
+			}
+		}
+		if len(hook.Limits) > 0 {
+			if pause, ok := admin.Paused(cg, username); ok {
+				slog.Info("not applying hook limits to paused unit", "unit", unit, "event", event, "pause", pause.ID)
+				continue
+			}
+			if silence, ok := admin.Silenced(cg, username); ok {
+				slog.Info("not applying hook limits to silenced unit", "unit", unit, "event", event, "silence", silence.ID)
+				continue
+			}
+			e.applyHookLimits(ctx, unit, event, hook.Limits)
+		}
+	}
+}
+
+// applyHookLimits sets the limits of a hook on the unit, except for the properties
+// pinned on the unit
+func (e *Engine) applyHookLimits(ctx context.Context, unit string, event string, limits map[string]any) {
+	limits = maps.Clone(limits)
+	for name := range pins.values(unit) {
+		delete(limits, name)
+	}
+	if len(limits) == 0 {
+		return
+	}
+
+	err := e.setProperties(ctx, unit, limits)
+	if err != nil {
+		slog.Warn("unable to apply hook limits", "unit", unit, "event", event, "err", err)
+		status.Report(status.Remediation, unit, err)
+		return
+	}
+	slog.Info("applied hook limits", "unit", unit, "event", event, "properties", len(limits), "dryRun", e.dryRun)
+	if !e.dryRun {
+		enforced.set(path.Join(e.root, unit), limits, sourceHook)
+	}
+	e.notifyAction(ctx, unit, "hook", fmt.Sprintf("changed limits of %s after %s memory event", unit, event), limits)
+}
@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync/atomic"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+)
+
+// highest uid of the system accounts of most distributions, whose units are
+// always exempt along with those of root
+const systemUIDMax = 999
+
+// Exempt names the units that limits and enforcement are never applied to, by
+// the username, uid or range of uids, or group of the user owning the unit, by
+// a regular expression matching the whole name of the unit, or by a tag of the
+// unit.
+type Exempt struct {
+	Users  []string `json:"users"`
+	UIDs   []string `json:"uids"`
+	Groups []string `json:"groups"`
+	Units  []string `json:"units"`
+	Tags   []string `json:"tags"`
+
+	uids  []uidRange
+	units []*regexp.Regexp
+}
+
+func (x *Exempt) validate() error {
+	x.uids = nil
+	for _, s := range x.UIDs {
+		r, err := parseUIDRange(s)
+		if err != nil {
+			return err
+		}
+		x.uids = append(x.uids, r)
+	}
+
+	x.units = nil
+	for _, expr := range x.Units {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid unit expression '%s': %w", expr, err)
+		}
+		x.units = append(x.units, re)
+	}
+	return nil
+}
+
+// reason returns why the subject is exempt, or an empty string if it is not
+func (x *Exempt) reason(s Subject) string {
+	if s.HasUID && s.UID <= systemUIDMax {
+		return "system account"
+	}
+	if s.Username != "" && slices.Contains(x.Users, s.Username) {
+		return "user " + s.Username
+	}
+	if s.HasUID {
+		for i, r := range x.uids {
+			if s.UID >= r.first && s.UID <= r.last {
+				return "uid " + x.UIDs[i]
+			}
+		}
+	}
+	for _, group := range s.Groups {
+		if slices.Contains(x.Groups, group) {
+			return "group " + group
+		}
+	}
+	for i, re := range x.units {
+		if re.MatchString(s.Unit) {
+			return "unit " + x.Units[i]
+		}
+	}
+	return ""
+}
+
+// tag returns the tag exempting the unit, if any. Unlike the other entries, which
+// are checked once as a unit appears, tags are checked every time, since they
+// are added and removed at runtime.
+func (x *Exempt) tag(unit string) (string, bool) {
+	for _, tag := range x.Tags {
+		if admin.Tagged(unit, tag) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// Exempted reports whether the unit of the subject is exempt from the policy,
+// along with the reason
+func (p *Policy) Exempted(s Subject) (string, bool) {
+	reason := p.Exempt.reason(s)
+	return reason, reason != ""
+}
+
+// Exemption is a single entry of the exempt list of the active policy
+type Exemption struct {
+	Kind  string
+	Value string
+}
+
+// policy the engine is running with, whose exemptions are exported
+var active atomic.Pointer[Policy]
+
+// Exemptions lists the exemptions of the active policy, starting with the
+// system accounts that are always exempt. There are none without a policy.
+func Exemptions() []Exemption {
+	p := active.Load()
+	if p == nil {
+		return nil
+	}
+
+	exemptions := []Exemption{{Kind: "uid", Value: fmt.Sprintf("0-%d", systemUIDMax)}}
+	for _, user := range p.Exempt.Users {
+		exemptions = append(exemptions, Exemption{Kind: "user", Value: user})
+	}
+	for _, uids := range p.Exempt.UIDs {
+		exemptions = append(exemptions, Exemption{Kind: "uid", Value: uids})
+	}
+	for _, group := range p.Exempt.Groups {
+		exemptions = append(exemptions, Exemption{Kind: "group", Value: group})
+	}
+	for _, unit := range p.Exempt.Units {
+		exemptions = append(exemptions, Exemption{Kind: "unit", Value: unit})
+	}
+	for _, tag := range p.Exempt.Tags {
+		exemptions = append(exemptions, Exemption{Kind: "tag", Value: tag})
+	}
+
+	// entries repeated in the file are listed once
+	seen := make(map[Exemption]bool, len(exemptions))
+	return slices.DeleteFunc(exemptions, func(x Exemption) bool {
+		repeated := seen[x]
+		seen[x] = true
+		return repeated
+	})
+}
@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/audit"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// ViolationsHandler returns the rules firing for every unit, and whether they
+// have been acknowledged.
+func ViolationsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		firing := Firing()
+		if firing == nil {
+			firing = []FiringViolation{}
+		}
+		writeJSON(w, http.StatusOK, firing)
+	}
+}
+
+type acknowledgeRequest struct {
+	Comment string `json:"comment"`
+}
+
+// AcknowledgeHandler acknowledges the rule firing for the unit given in the path,
+// with a comment. An acknowledged rule no longer escalates the penalties of the
+// unit until it is resolved.
+func AcknowledgeHandler(root string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request acknowledgeRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		unit, rule := r.PathValue("name"), r.PathValue("rule")
+		ack := Acknowledgement{Comment: request.Comment, Time: time.Now()}
+		err = violations.acknowledge(path.Join(root, unit), rule, ack)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		slog.Info("acknowledged violation", "unit", unit, "rule", rule, "comment", request.Comment)
+		audit.Record(r.Context(), audit.Entry{Action: "acknowledge", Unit: unit, Summary: fmt.Sprintf("acknowledged %s: %s", rule, request.Comment)}, nil)
+		writeJSON(w, http.StatusOK, ack)
+	}
+}
+
+// PinsHandler returns the pins of every unit.
+func PinsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all := Pins()
+		if all == nil {
+			all = []Pin{}
+		}
+		writeJSON(w, http.StatusOK, all)
+	}
+}
+
+type pinRequest struct {
+	Value    any    `json:"value"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// PinHandler pins the property given in the path of the unit to a value, set on
+// the unit right away, with a reason and optionally a duration like "24h" after
+// which the pin ends.
+func PinHandler(root string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request pinRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			slog.Warn("unable to decode json request", "err", err.Error())
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		unit, property := r.PathValue("name"), r.PathValue("property")
+		if request.Value == nil {
+			writeError(w, http.StatusBadRequest, errors.New("value is required"))
+			return
+		}
+		err = control.ValidateProperty(property, request.Value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", property, err))
+			return
+		}
+
+		p := Pin{Unit: unit, Property: property, Value: request.Value, Reason: request.Reason, CreatedAt: time.Now()}
+		if request.Duration != "" {
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil || duration <= 0 {
+				writeError(w, http.StatusBadRequest, errors.New("invalid duration, expected a positive duration like 24h"))
+				return
+			}
+			endsAt := p.CreatedAt.Add(duration)
+			p.EndsAt = &endsAt
+		}
+
+		err = setPinned(r.Context(), root, unit, map[string]any{property: request.Value})
+		if err != nil {
+			slog.Warn("unable to set pinned property", "unit", unit, "property", property, "err", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		pins.add(p)
+		slog.Info("pinned property", "unit", unit, "property", property, "value", request.Value, "reason", request.Reason)
+		audit.Record(r.Context(), audit.Entry{Action: "pin", Unit: unit, Property: property, New: request.Value, Summary: request.Reason}, nil)
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// UnpinHandler removes the pin of the property given in the path of the unit.
+// The property keeps the pinned value until the policy sets it again.
+func UnpinHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		unit, property := r.PathValue("name"), r.PathValue("property")
+		if !pins.remove(unit, property) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Info("unpinned property", "unit", unit, "property", property)
+		audit.Record(r.Context(), audit.Entry{Action: "unpin", Unit: unit, Property: property}, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func setPinned(ctx context.Context, root string, unit string, values map[string]any) error {
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return control.SetUnitProperties(ctx, conn, root, unit, values, true)
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"maps"
+	"sync"
+)
+
+// sources of the properties the engine sets on units
+const (
+	sourcePolicy  = "policy"
+	sourcePenalty = "penalty"
+	sourceHook    = "hook"
+	sourcePin     = "pin"
+)
+
+type limitStore struct {
+	data  map[string]map[string]string
+	mutex sync.Mutex
+}
+
+// source of each property the engine set on the units, by cgroup and property
+var enforced = &limitStore{data: make(map[string]map[string]string)}
+
+// set records that the properties were set on the unit from the source
+func (store *limitStore) set(cg string, values map[string]any, source string) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	limits, ok := store.data[cg]
+	if !ok {
+		limits = make(map[string]string)
+		store.data[cg] = limits
+	}
+	for name := range values {
+		limits[name] = source
+	}
+}
+
+// clear forgets the properties set on the unit from the source
+func (store *limitStore) clear(cg string, source string) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	maps.DeleteFunc(store.data[cg], func(_ string, s string) bool {
+		return s == source
+	})
+}
+
+// retain forgets the units for which present returns false
+func (store *limitStore) retain(present func(cg string) bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	for cg := range store.data {
+		if !present(cg) {
+			delete(store.data, cg)
+		}
+	}
+}
+
+// AppliedLimits returns the properties the policy engine set on the unit, with
+// where their value came from: the policy, a penalty tier, a hook or a pin
+func AppliedLimits(cg string) map[string]string {
+	defer enforced.mutex.Unlock()
+	enforced.mutex.Lock()
+	return maps.Clone(enforced.data[cg])
+}
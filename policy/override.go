@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// Override replaces some of the defaults for the units of certain users, given by
+// username, by uid or range of uids like "1000-1999", or by the name of a group
+// the user is a member of. An override applies if any of them match.
+type Override struct {
+	Users  []string       `json:"users"`
+	UIDs   []string       `json:"uids"`
+	Groups []string       `json:"groups"`
+	Limits map[string]any `json:"limits"`
+
+	uids []uidRange
+}
+
+type uidRange struct {
+	first int
+	last  int
+}
+
+func parseUIDRange(s string) (uidRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	low, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return uidRange{}, fmt.Errorf("invalid uid range '%s'", s)
+	}
+	high := low
+	if isRange {
+		high, err = strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || high < low {
+			return uidRange{}, fmt.Errorf("invalid uid range '%s'", s)
+		}
+	}
+	return uidRange{first: low, last: high}, nil
+}
+
+func (o *Override) validate() error {
+	if len(o.Users) == 0 && len(o.UIDs) == 0 && len(o.Groups) == 0 {
+		return errors.New("override does not name any users, uids or groups")
+	}
+
+	o.uids = nil
+	for _, s := range o.UIDs {
+		r, err := parseUIDRange(s)
+		if err != nil {
+			return err
+		}
+		o.uids = append(o.uids, r)
+	}
+
+	for name, value := range o.Limits {
+		if err := control.ValidateProperty(name, value); err != nil {
+			return fmt.Errorf("invalid limit %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (o *Override) matches(s Subject) bool {
+	if s.Username != "" && slices.Contains(o.Users, s.Username) {
+		return true
+	}
+	if s.HasUID {
+		for _, r := range o.uids {
+			if s.UID >= r.first && s.UID <= r.last {
+				return true
+			}
+		}
+	}
+	for _, group := range s.Groups {
+		if slices.Contains(o.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subject is the user owning a unit, as matched against overrides
+type Subject struct {
+	Unit     string
+	Username string
+	UID      int
+	HasUID   bool
+	Groups   []string
+}
+
+// NewSubject looks up the user owning a unit underneath root, and the groups the
+// user is a member of. Units that are not user slices have no user.
+func NewSubject(root string, unit string) Subject {
+	cg := path.Join(root, unit)
+	s := Subject{Unit: unit}
+	s.UID, s.HasUID = hierarchy.UnitUID(cg)
+	if !s.HasUID {
+		return s
+	}
+
+	s.Username, _ = hierarchy.UnitUsername(cg)
+	u, err := user.LookupId(strconv.Itoa(s.UID))
+	if err != nil {
+		return s
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return s
+	}
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			s.Groups = append(s.Groups, g.Name)
+		}
+	}
+	return s
+}
+
+// Limits returns the limits effective for the subject at a time, along with the
+// indexes of the overrides applied. The defaults are applied first, replaced by
+// those of the schedules active at the time, followed by every matching override
+// in the order of the policy file, so that later overrides win.
+func (p *Policy) Limits(s Subject, t time.Time) (map[string]any, []int) {
+	limits := p.scheduledDefaults(t)
+
+	var applied []int
+	for i := range p.Overrides {
+		if p.Overrides[i].matches(s) {
+			maps.Copy(limits, p.Overrides[i].Limits)
+			applied = append(applied, i)
+		}
+	}
+	return limits, applied
+}
@@ -0,0 +1,318 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/control"
+	"github.com/chpc-uofu/cgroup-warden/status"
+)
+
+// Penalties steps units whose usage exceeds the thresholds, or that violate any
+// of the rules named, through progressively stricter tiers of limits, one tier
+// each time the usage stays over the thresholds for the grace period, and back
+// down one tier for each cool-down without a violation.
+type Penalties struct {
+	// CPU usage in cores, averaged over an evaluation interval
+	CPU float64 `json:"cpu"`
+
+	// memory usage in bytes
+	Memory float64 `json:"memory"`
+
+	// names of rules whose violations also count as being over the thresholds
+	Rules []string `json:"rules"`
+
+	// number of consecutive evaluations usage must be over the thresholds to step up a tier
+	Grace int `json:"grace"`
+
+	// how long usage must stay below the thresholds to step down a tier
+	Cooldown string `json:"cooldown"`
+
+	Tiers []Tier `json:"tiers"`
+
+	cooldown time.Duration
+}
+
+// Tier is a set of limits replacing those of the policy while a unit is penalized.
+// Its thresholds, which default to those of the penalties, are the usage above
+// which the unit is escalated to the next tier.
+type Tier struct {
+	Name   string         `json:"name"`
+	Limits map[string]any `json:"limits"`
+	CPU    float64        `json:"cpu"`
+	Memory float64        `json:"memory"`
+}
+
+// cool-down used if none is given
+const defaultCooldown = time.Hour
+
+func (p *Penalties) validate() error {
+	if p.CPU < 0 || p.Memory < 0 {
+		return errors.New("thresholds must not be negative")
+	}
+	if p.CPU == 0 && p.Memory == 0 && len(p.Rules) == 0 {
+		return errors.New("penalties have no cpu or memory threshold, or rules")
+	}
+	if len(p.Tiers) == 0 {
+		return errors.New("penalties have no tiers")
+	}
+
+	if p.Grace < 0 {
+		return errors.New("grace must not be negative")
+	}
+	if p.Grace == 0 {
+		p.Grace = 1
+	}
+
+	p.cooldown = defaultCooldown
+	if p.Cooldown != "" {
+		d, err := time.ParseDuration(p.Cooldown)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid cooldown '%s'", p.Cooldown)
+		}
+		p.cooldown = d
+	}
+
+	for i := range p.Tiers {
+		t := &p.Tiers[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("penalty%d", i+1)
+		}
+		if len(t.Limits) == 0 {
+			return fmt.Errorf("tier %s has no limits", t.Name)
+		}
+		for name, value := range t.Limits {
+			if err := control.ValidateProperty(name, value); err != nil {
+				return fmt.Errorf("invalid limit %s of tier %s: %w", name, t.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// thresholds returns the usage above which a unit in the tier is escalated,
+// where tier 0 is a unit that is not penalized
+func (p *Penalties) thresholds(tier int) (cpu float64, memory float64) {
+	cpu, memory = p.CPU, p.Memory
+	if tier > 0 {
+		t := p.Tiers[tier-1]
+		if t.CPU > 0 {
+			cpu = t.CPU
+		}
+		if t.Memory > 0 {
+			memory = t.Memory
+		}
+	}
+	return cpu, memory
+}
+
+// TierName returns the name of a tier, or "normal" for a unit that is not penalized
+func (p *Penalties) TierName(tier int) string {
+	if tier <= 0 || tier > len(p.Tiers) {
+		return "normal"
+	}
+	return p.Tiers[tier-1].Name
+}
+
+// offender is the penalty state of a unit
+type offender struct {
+	tier int
+
+	// consecutive evaluations the unit has been over the thresholds
+	violations int
+
+	// when the unit last violated the thresholds or changed tier
+	since time.Time
+
+	// values the properties changed by tiers had before the unit was penalized
+	originals map[string]any
+}
+
+type offenderStore struct {
+	data  map[string]offender
+	mutex sync.Mutex
+}
+
+// penalty state of the units evaluated by the engine, by cgroup
+var offenders = &offenderStore{data: make(map[string]offender)}
+
+func (store *offenderStore) get(cg string) (offender, bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	o, ok := store.data[cg]
+	return o, ok
+}
+
+func (store *offenderStore) put(cg string, o offender) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	store.data[cg] = o
+}
+
+// retain forgets the units for which present returns false
+func (store *offenderStore) retain(present func(cg string) bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	for cg := range store.data {
+		if !present(cg) {
+			delete(store.data, cg)
+		}
+	}
+}
+
+// Penalty is the penalty state of a unit
+type Penalty struct {
+	Tier int
+	Name string
+
+	// how long until the unit steps down a tier if its usage stays below the
+	// thresholds, 0 if it is not penalized
+	Remaining time.Duration
+}
+
+// UnitPenalty returns the penalty state of the unit, if the unit is evaluated by
+// the penalties of the active policy
+func UnitPenalty(cg string) (Penalty, bool) {
+	p := active.Load()
+	if p == nil || p.Penalties == nil {
+		return Penalty{}, false
+	}
+	o, ok := offenders.get(cg)
+	if !ok {
+		return Penalty{}, false
+	}
+
+	penalty := Penalty{Tier: o.tier, Name: p.Penalties.TierName(o.tier)}
+	if o.tier > 0 {
+		penalty.Remaining = max(0, p.Penalties.cooldown-time.Since(o.since))
+	}
+	return penalty, true
+}
+
+// penalize checks the usage of the unit against the thresholds of its tier and
+// the rules of the penalties, escalating it on a violation and de-escalating it
+// after a cool-down
+func (e *Engine) penalize(ctx context.Context, unit string, u usage) {
+	penalties := e.policy.Penalties
+
+	now := time.Now()
+	o, ok := offenders.get(u.cg)
+	if !ok {
+		o = offender{since: now}
+	}
+
+	// a paused unit is not penalized until the pause ends
+	if pause, ok := admin.Paused(u.cg, u.username); ok {
+		if o.tier > 0 {
+			slog.Info("lifting penalty of paused unit", "unit", unit, "tier", penalties.TierName(o.tier), "pause", pause.ID)
+			e.setTier(ctx, unit, &o, 0)
+		}
+		o.violations = 0
+		o.since = now
+		offenders.put(u.cg, o)
+		return
+	}
+
+	cpu, memory := penalties.thresholds(o.tier)
+	violating := (cpu > 0 && u.cores > cpu) || (memory > 0 && u.memory > memory) || violations.firing(u.cg, penalties.Rules)
+
+	// a silenced unit is not escalated, though it still steps down a tier after
+	// the cool-down
+	if silence, ok := admin.Silenced(u.cg, u.username); ok && violating {
+		slog.Debug("not escalating silenced unit", "unit", unit, "tier", penalties.TierName(o.tier), "silence", silence.ID)
+		violating = false
+	}
+	if violating {
+		o.violations++
+	} else {
+		o.violations = 0
+	}
+
+	switch {
+	case violating && o.violations >= penalties.Grace && o.tier < len(penalties.Tiers):
+		slog.Info("unit exceeded penalty thresholds", "unit", unit, "cores", u.cores, "memory", u.memory, "tier", penalties.TierName(o.tier), "evaluations", o.violations)
+		o.since = now
+		o.violations = 0
+		e.setTier(ctx, unit, &o, o.tier+1)
+	case violating:
+		slog.Debug("unit over penalty thresholds within grace period", "unit", unit, "cores", u.cores, "memory", u.memory, "evaluations", o.violations)
+		o.since = now
+	case o.tier > 0 && now.Sub(o.since) >= penalties.cooldown:
+		o.since = now
+		e.setTier(ctx, unit, &o, o.tier-1)
+	}
+	offenders.put(u.cg, o)
+}
+
+// setTier applies the limits of a tier to the unit, on top of the limits of the
+// policy and below its pins. The values the properties of the tiers had before the unit was first
+// penalized are restored as it steps down, and once it is no longer penalized.
+// It reports whether the limits were applied.
+func (e *Engine) setTier(ctx context.Context, unit string, o *offender, tier int) bool {
+	penalties := e.policy.Penalties
+	limits, _ := e.policy.Limits(NewSubject(e.root, unit), time.Now())
+
+	if o.originals == nil {
+		o.originals = make(map[string]any)
+	}
+	if tier > 0 {
+		for name, value := range penalties.Tiers[tier-1].Limits {
+			if _, ok := o.originals[name]; ok {
+				continue
+			}
+			original, err := control.CurrentProperty(e.root, unit, name, value)
+			if err != nil {
+				slog.Warn("unable to read property before penalizing unit", "unit", unit, "property", name, "err", err)
+				status.Report(status.Remediation, unit, err)
+				return false
+			}
+			o.originals[name] = original
+		}
+	}
+
+	values := maps.Clone(o.originals)
+	maps.Copy(values, limits)
+	if tier > 0 {
+		maps.Copy(values, penalties.Tiers[tier-1].Limits)
+	}
+	cg := path.Join(e.root, unit)
+	values, pinned := withPins(cg, values)
+
+	err := e.setProperties(ctx, unit, values)
+	if err != nil {
+		slog.Warn("unable to apply penalty tier", "unit", unit, "tier", penalties.TierName(tier), "err", err)
+		status.Report(status.Remediation, unit, err)
+		return false
+	}
+
+	if !e.dryRun {
+		enforced.clear(cg, sourcePenalty)
+		enforced.clear(cg, sourcePin)
+		enforced.set(cg, limits, sourcePolicy)
+		if tier > 0 {
+			enforced.set(cg, penalties.Tiers[tier-1].Limits, sourcePenalty)
+		}
+		enforced.set(cg, pinned, sourcePin)
+	}
+
+	if tier != o.tier {
+		slog.Info("changed penalty tier", "unit", unit, "from", penalties.TierName(o.tier), "to", penalties.TierName(tier), "dryRun", e.dryRun)
+		summary := fmt.Sprintf("%s escalated from %s to %s", unit, penalties.TierName(o.tier), penalties.TierName(tier))
+		if tier < o.tier {
+			summary = fmt.Sprintf("%s de-escalated from %s to %s", unit, penalties.TierName(o.tier), penalties.TierName(tier))
+		}
+		e.notifyAction(ctx, unit, "penalty", summary, values)
+	}
+	o.tier = tier
+	if tier == 0 {
+		o.originals = nil
+	}
+	return true
+}
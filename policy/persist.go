@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+)
+
+// State is the penalty state and pins of the units kept across restarts, so that
+// a restart does not reset every penalized or pinned unit to the limits of the
+// policy
+type State struct {
+	Penalties map[string]PenaltyState `json:"penalties,omitempty"`
+	Pins      []Pin                   `json:"pins,omitempty"`
+}
+
+// PenaltyState is the saved penalty state of a unit
+type PenaltyState struct {
+	Tier       int            `json:"tier"`
+	Violations int            `json:"violations"`
+	Since      time.Time      `json:"since"`
+	Originals  map[string]any `json:"originals,omitempty"`
+}
+
+// SaveState returns the current penalty state and pins
+func SaveState() State {
+	s := State{Pins: Pins()}
+
+	defer offenders.mutex.Unlock()
+	offenders.mutex.Lock()
+
+	s.Penalties = make(map[string]PenaltyState, len(offenders.data))
+	for cg, o := range offenders.data {
+		s.Penalties[cg] = PenaltyState{Tier: o.tier, Violations: o.violations, Since: o.since, Originals: o.originals}
+	}
+	return s
+}
+
+// RestoreState restores the penalty state and pins saved before a restart, for the units
+// still underneath the root. It must be called before the engine starts, which
+// applies the limits of the restored tiers to the units as it finds them.
+func RestoreState(root string, s State) {
+	h := hierarchy.NewHierarchy(root)
+	children, err := h.Children(root)
+	if err != nil {
+		slog.Warn("unable to list units to restore penalty state", "root", root, "err", err)
+		return
+	}
+	present := make(map[string]bool, len(children))
+	for _, cg := range children {
+		present[path.Join(root, path.Base(cg))] = true
+	}
+
+	restored := 0
+	for cg, p := range s.Penalties {
+		if !present[cg] {
+			continue
+		}
+		offenders.put(cg, offender{tier: p.Tier, violations: p.Violations, since: p.Since, originals: p.Originals})
+		restored++
+	}
+
+	pinned := 0
+	for _, p := range s.Pins {
+		if !present[path.Join(root, p.Unit)] || p.expired(time.Now()) {
+			continue
+		}
+		pins.add(p)
+		pinned++
+	}
+	slog.Info("restored penalty state", "units", restored, "pins", pinned)
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"cmp"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pin is a limit set on a unit by an operator, which neither the limits of the
+// policy nor its penalties and hooks overwrite until the pin is removed or ends.
+type Pin struct {
+	Unit      string     `json:"unit"`
+	Property  string     `json:"property"`
+	Value     any        `json:"value"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+}
+
+func (p Pin) expired(now time.Time) bool {
+	return p.EndsAt != nil && !now.Before(*p.EndsAt)
+}
+
+type pinStore struct {
+	data map[string]map[string]Pin
+
+	// units whose pins changed since the engine last applied their limits
+	changed map[string]bool
+	mutex   sync.Mutex
+}
+
+// pins of the units, by unit and property
+var pins = &pinStore{data: make(map[string]map[string]Pin), changed: make(map[string]bool)}
+
+func (store *pinStore) add(p Pin) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	if store.data[p.Unit] == nil {
+		store.data[p.Unit] = make(map[string]Pin)
+	}
+	store.data[p.Unit][p.Property] = p
+	store.changed[p.Unit] = true
+}
+
+func (store *pinStore) remove(unit string, property string) bool {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	if _, ok := store.data[unit][property]; !ok {
+		return false
+	}
+	delete(store.data[unit], property)
+	if len(store.data[unit]) == 0 {
+		delete(store.data, unit)
+	}
+	store.changed[unit] = true
+	return true
+}
+
+// expire removes the pins that ended, which must be called with the lock held
+func (store *pinStore) expire(now time.Time) {
+	for unit, props := range store.data {
+		for property, p := range props {
+			if p.expired(now) {
+				delete(props, property)
+				store.changed[unit] = true
+			}
+		}
+		if len(props) == 0 {
+			delete(store.data, unit)
+		}
+	}
+}
+
+// values returns the pinned values of the properties of the unit
+func (store *pinStore) values(unit string) map[string]any {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	store.expire(time.Now())
+
+	values := make(map[string]any, len(store.data[unit]))
+	for property, p := range store.data[unit] {
+		values[property] = p.Value
+	}
+	return values
+}
+
+// takeChanged returns the units whose pins changed or ended since the last call
+func (store *pinStore) takeChanged() []string {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	store.expire(time.Now())
+
+	units := slices.Collect(maps.Keys(store.changed))
+	clear(store.changed)
+	return units
+}
+
+// Pins returns the pins of every unit, sorted by unit and property
+func Pins() []Pin {
+	defer pins.mutex.Unlock()
+	pins.mutex.Lock()
+	pins.expire(time.Now())
+
+	var all []Pin
+	for _, props := range pins.data {
+		for _, p := range props {
+			all = append(all, p)
+		}
+	}
+	slices.SortFunc(all, func(a, b Pin) int {
+		return cmp.Or(strings.Compare(a.Unit, b.Unit), strings.Compare(a.Property, b.Property))
+	})
+	return all
+}
+
+// withPins returns the values with the pinned values of the unit replacing them,
+// and the pinned values
+func withPins(cg string, values map[string]any) (map[string]any, map[string]any) {
+	pinned := pins.values(path.Base(cg))
+	if len(pinned) == 0 {
+		return values, pinned
+	}
+	values = maps.Clone(values)
+	if values == nil {
+		values = make(map[string]any, len(pinned))
+	}
+	maps.Copy(values, pinned)
+	return values, pinned
+}
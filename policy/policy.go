@@ -0,0 +1,145 @@
+// Package policy applies the limits of a policy file to units as they appear.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+)
+
+// Policy is the limits applied to units, read from a JSON file like
+//
+//	{
+//	  "units": ["user-*.slice"],
+//	  "defaults": {"MemoryMax": 8589934592, "CPUQuotaPerSecUSec": "400%", "TasksMax": 4096},
+//	  "overrides": [{"groups": ["faculty"], "limits": {"MemoryMax": 17179869184}}],
+//	  "exempt": {"users": ["admin"], "units": ["user-1[0-9]{3}\\.slice"]}
+//	}
+type Policy struct {
+	// patterns of the names of the units the policy applies to
+	Units []string `json:"units"`
+
+	// properties set on every new unit, in the same form as the values of control requests
+	Defaults map[string]any `json:"defaults"`
+
+	// limits replacing the defaults during windows of time
+	Schedules []Schedule `json:"schedules"`
+
+	// limits replacing the defaults for some users
+	Overrides []Override `json:"overrides"`
+
+	// units never touched by the policy
+	Exempt Exempt `json:"exempt"`
+
+	// thresholds on the usage of units reported as violations
+	Rules []Rule `json:"rules"`
+
+	// stricter limits for units that repeatedly use too much
+	Penalties *Penalties `json:"penalties"`
+
+	// actions run when a unit hits its memory limits
+	Hooks []Hook `json:"hooks"`
+
+	// evaluate the policy and report what it would do without changing any unit
+	DryRun bool `json:"dryRun"`
+}
+
+// units the policy applies to if none are given
+var defaultUnits = []string{"user-*.slice"}
+
+// Load reads and validates a policy file
+func Load(file string) (*Policy, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", file, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	if len(p.Units) == 0 {
+		p.Units = defaultUnits
+	}
+
+	for _, pattern := range p.Units {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid unit pattern '%s'", pattern)
+		}
+	}
+
+	for name, value := range p.Defaults {
+		if err := control.ValidateProperty(name, value); err != nil {
+			return fmt.Errorf("invalid default %s: %w", name, err)
+		}
+	}
+
+	for i := range p.Schedules {
+		if p.Schedules[i].Name == "" {
+			p.Schedules[i].Name = fmt.Sprintf("schedule%d", i+1)
+		}
+		if err := p.Schedules[i].validate(p.Defaults); err != nil {
+			return fmt.Errorf("schedule %s: %w", p.Schedules[i].Name, err)
+		}
+	}
+
+	for i := range p.Overrides {
+		if err := p.Overrides[i].validate(); err != nil {
+			return fmt.Errorf("override %d: %w", i+1, err)
+		}
+	}
+
+	if err := p.Exempt.validate(); err != nil {
+		return fmt.Errorf("exempt: %w", err)
+	}
+
+	names := make(map[string]bool, len(p.Rules))
+	for i := range p.Rules {
+		if err := p.Rules[i].validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if names[p.Rules[i].Name] {
+			return fmt.Errorf("duplicate rule %s", p.Rules[i].Name)
+		}
+		names[p.Rules[i].Name] = true
+	}
+
+	if p.Penalties != nil {
+		if err := p.Penalties.validate(); err != nil {
+			return fmt.Errorf("penalties: %w", err)
+		}
+		for _, name := range p.Penalties.Rules {
+			if !names[name] {
+				return fmt.Errorf("penalties: unknown rule %s", name)
+			}
+		}
+	}
+
+	for i := range p.Hooks {
+		if err := p.Hooks[i].validate(); err != nil {
+			return fmt.Errorf("hook %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the policy applies to the unit
+func (p *Policy) Matches(unit string) bool {
+	for _, pattern := range p.Units {
+		if ok, _ := path.Match(pattern, unit); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/control"
+)
+
+// Schedule replaces some of the defaults during a window of time, like looser
+// limits at night, on the given days of the week or dates. A window whose end is
+// before its start ends on the following day.
+type Schedule struct {
+	Name   string         `json:"name"`
+	Days   []string       `json:"days"`
+	Dates  []string       `json:"dates"`
+	Start  string         `json:"start"`
+	End    string         `json:"end"`
+	Limits map[string]any `json:"limits"`
+
+	days  map[time.Weekday]bool
+	dates map[string]bool
+	start int
+	end   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock parses a time of day like "20:30" into minutes since midnight
+func parseClock(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || !ok || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day '%s'", s)
+	}
+	return h*60 + m, nil
+}
+
+func (sc *Schedule) validate(defaults map[string]any) error {
+	if len(sc.Limits) == 0 {
+		return errors.New("schedule has no limits")
+	}
+
+	sc.days = make(map[time.Weekday]bool)
+	for _, day := range sc.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("invalid day '%s', expected one of mon, tue, wed, thu, fri, sat or sun", day)
+		}
+		sc.days[weekday] = true
+	}
+
+	sc.dates = make(map[string]bool)
+	for _, date := range sc.Dates {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", date)
+		}
+		sc.dates[date] = true
+	}
+
+	var err error
+	if sc.start, err = parseClock(cmp.Or(sc.Start, "00:00")); err != nil {
+		return err
+	}
+	if sc.end, err = parseClock(cmp.Or(sc.End, "24:00")); err != nil {
+		return err
+	}
+
+	for name, value := range sc.Limits {
+		// the defaults are restored when the window ends
+		if _, ok := defaults[name]; !ok {
+			return fmt.Errorf("limit %s is not one of the defaults", name)
+		}
+		if err := control.ValidateProperty(name, value); err != nil {
+			return fmt.Errorf("invalid limit %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (sc *Schedule) onDay(t time.Time) bool {
+	if len(sc.days) == 0 && len(sc.dates) == 0 {
+		return true
+	}
+	return sc.days[t.Weekday()] || sc.dates[t.Format(time.DateOnly)]
+}
+
+// active reports whether the window of the schedule contains t
+func (sc *Schedule) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if sc.start <= sc.end {
+		return sc.onDay(t) && minute >= sc.start && minute < sc.end
+	}
+	return (sc.onDay(t) && minute >= sc.start) || (sc.onDay(t.AddDate(0, 0, -1)) && minute < sc.end)
+}
+
+// ActiveSchedules returns the names of the schedules active at t, in the order of
+// the policy file
+func (p *Policy) ActiveSchedules(t time.Time) []string {
+	var names []string
+	for i := range p.Schedules {
+		if p.Schedules[i].active(t) {
+			names = append(names, p.Schedules[i].Name)
+		}
+	}
+	return names
+}
+
+// scheduledDefaults returns the defaults effective at t, replaced by the limits of
+// every schedule active at t in the order of the policy file
+func (p *Policy) scheduledDefaults(t time.Time) map[string]any {
+	defaults := maps.Clone(p.Defaults)
+	if defaults == nil {
+		defaults = make(map[string]any)
+	}
+	for i := range p.Schedules {
+		if p.Schedules[i].active(t) {
+			maps.Copy(defaults, p.Schedules[i].Limits)
+		}
+	}
+	return defaults
+}
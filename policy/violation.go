@@ -0,0 +1,432 @@
+package policy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/admin"
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/notify"
+)
+
+// Rule is a threshold on the usage of a unit, either absolute, or a fraction of
+// the limit of the unit, that is a violation once the usage stays above it for
+// a duration, like memory usage above 90% of MemoryMax for five minutes.
+type Rule struct {
+	Name string `json:"name"`
+
+	// "cpu" in cores, or "memory" or "swap" in bytes
+	Metric string `json:"metric"`
+
+	// usage above which the rule is violated
+	Above float64 `json:"above"`
+
+	// fraction of the CPU quota, MemoryMax or swap limit of the unit above which
+	// the rule is violated, for units that have the limit
+	Limit float64 `json:"limit"`
+
+	// how long the usage must stay above the threshold
+	For string `json:"for"`
+
+	Severity string `json:"severity"`
+
+	// tags of the units the rule applies to, all units if none are given, and
+	// tags of the units it never applies to
+	Tags     []string `json:"tags"`
+	SkipTags []string `json:"skipTags"`
+
+	duration time.Duration
+}
+
+var ruleMetrics = []string{"cpu", "memory", "swap"}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("rule has no name")
+	}
+	if !slices.Contains(ruleMetrics, r.Metric) {
+		return fmt.Errorf("invalid metric '%s', expected one of %v", r.Metric, ruleMetrics)
+	}
+	if (r.Above > 0) == (r.Limit > 0) {
+		return errors.New("rule must have exactly one of above or limit")
+	}
+	if r.Above < 0 || r.Limit < 0 {
+		return errors.New("thresholds must not be negative")
+	}
+
+	r.duration = 0
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid duration '%s'", r.For)
+		}
+		r.duration = d
+	}
+
+	r.Severity = cmp.Or(r.Severity, "warning")
+	return nil
+}
+
+// appliesTo reports whether the rule applies to the unit given its tags, which
+// are checked at every evaluation since they change at runtime
+func (r *Rule) appliesTo(unit string) bool {
+	tagged := func(tag string) bool {
+		return admin.Tagged(unit, tag)
+	}
+	if slices.ContainsFunc(r.SkipTags, tagged) {
+		return false
+	}
+	return len(r.Tags) == 0 || slices.ContainsFunc(r.Tags, tagged)
+}
+
+// threshold returns the usage above which the unit violates the rule, or false
+// if the rule is relative to a limit the unit does not have
+func (r *Rule) threshold(u usage) (float64, bool) {
+	if r.Above > 0 {
+		return r.Above, true
+	}
+	limit := u.limit(r.Metric)
+	return r.Limit * limit, limit > 0
+}
+
+// usage is a sample of the usage and limits of a unit, where a limit of 0 means
+// the unit has none
+type usage struct {
+	cg       string
+	username string
+
+	// cores used since the previous sample
+	cores  float64
+	memory float64
+	swap   float64
+
+	cpuLimit    float64
+	memoryLimit float64
+	swapLimit   float64
+}
+
+func (u usage) value(metric string) float64 {
+	switch metric {
+	case "cpu":
+		return u.cores
+	case "memory":
+		return u.memory
+	default:
+		return u.swap
+	}
+}
+
+func (u usage) limit(metric string) float64 {
+	switch metric {
+	case "cpu":
+		return u.cpuLimit
+	case "memory":
+		return u.memoryLimit
+	default:
+		return u.swapLimit
+	}
+}
+
+// cpuSample is the cpu usage of a unit in seconds at a time
+type cpuSample struct {
+	usage float64
+	time  time.Time
+}
+
+// sample reads the usage and limits of the unit. The cpu usage is a rate, so
+// nothing is returned for the first sample of a unit.
+func (e *Engine) sample(unit string) (usage, bool) {
+	cg := path.Join(e.root, unit)
+	h := hierarchy.NewHierarchy(e.root)
+	info, err := h.CGroupInfo(cg)
+	if err != nil {
+		slog.Debug("unable to sample unit", "unit", unit, "err", err)
+		return usage{}, false
+	}
+
+	now := time.Now()
+	previous, ok := e.cpuSamples[cg]
+	e.cpuSamples[cg] = cpuSample{usage: info.CPUUsage, time: now}
+	if !ok {
+		return usage{}, false
+	}
+
+	u := usage{
+		cg:       cg,
+		username: info.Username,
+		cores:    (info.CPUUsage - previous.usage) / now.Sub(previous.time).Seconds(),
+		memory:   float64(info.MemoryUsage),
+		swap:     float64(info.SwapUsage),
+	}
+	if info.CPUQuota > 0 {
+		u.cpuLimit = float64(info.CPUQuota) / hierarchy.USPerS
+	}
+	if info.MemoryMax > 0 && info.MemoryMax < hierarchy.MaxCGroupMemoryLimit {
+		u.memoryLimit = float64(info.MemoryMax)
+	}
+	if info.SwapMax > 0 && info.SwapMax < hierarchy.MaxCGroupMemoryLimit {
+		u.swapLimit = float64(info.SwapMax)
+	}
+	return u, true
+}
+
+// Violation is a unit whose usage stayed over the threshold of a rule for its
+// duration, reported when it starts firing and once it is resolved.
+type Violation struct {
+	Unit      string    `json:"unit"`
+	Cgroup    string    `json:"cgroup"`
+	Username  string    `json:"username"`
+	Rule      string    `json:"rule"`
+	Severity  string    `json:"severity"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	Resolved  bool      `json:"resolved"`
+	Time      time.Time `json:"time"`
+}
+
+// ruleState is the state of a rule for a unit over the threshold
+type ruleState struct {
+	since  time.Time
+	firing bool
+
+	// acknowledged by an operator, until the violation is resolved
+	acknowledged *Acknowledgement
+}
+
+// Acknowledgement is an operator acknowledging a violation, which then no longer
+// escalates the penalties of the unit
+type Acknowledgement struct {
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+type violationStore struct {
+	data  map[string]map[string]*ruleState
+	mutex sync.Mutex
+}
+
+// state of the rules of the units evaluated by the engine, by cgroup and rule
+var violations = &violationStore{data: make(map[string]map[string]*ruleState)}
+
+// update records whether the unit is over the threshold of the rule, returning
+// the state of the rule and whether it changed between firing and resolved
+func (store *violationStore) update(cg string, rule *Rule, over bool, now time.Time) (ruleState, bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+
+	state, ok := store.data[cg][rule.Name]
+	if !over {
+		if !ok {
+			return ruleState{}, false
+		}
+		delete(store.data[cg], rule.Name)
+		if len(store.data[cg]) == 0 {
+			delete(store.data, cg)
+		}
+		return *state, state.firing
+	}
+
+	if !ok {
+		if store.data[cg] == nil {
+			store.data[cg] = make(map[string]*ruleState)
+		}
+		state = &ruleState{since: now}
+		store.data[cg][rule.Name] = state
+	}
+	if !state.firing && now.Sub(state.since) >= rule.duration {
+		state.firing = true
+		return *state, true
+	}
+	return *state, false
+}
+
+// firing reports whether any of the rules is firing for the unit, and has not
+// been acknowledged
+func (store *violationStore) firing(cg string, rules []string) bool {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	for _, name := range rules {
+		if state, ok := store.data[cg][name]; ok && state.firing && state.acknowledged == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// acknowledge marks the rule firing for the unit as acknowledged
+func (store *violationStore) acknowledge(cg string, rule string, ack Acknowledgement) error {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	state, ok := store.data[cg][rule]
+	if !ok || !state.firing {
+		return fmt.Errorf("rule %s is not firing for %s", rule, path.Base(cg))
+	}
+	state.acknowledged = &ack
+	return nil
+}
+
+// retain forgets the units for which present returns false
+func (store *violationStore) retain(present func(cg string) bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+	for cg := range store.data {
+		if !present(cg) {
+			delete(store.data, cg)
+		}
+	}
+}
+
+// FiringViolation is a rule firing for a unit, as listed for operators
+type FiringViolation struct {
+	Unit         string           `json:"unit"`
+	Cgroup       string           `json:"cgroup"`
+	Rule         string           `json:"rule"`
+	Severity     string           `json:"severity"`
+	Since        time.Time        `json:"since"`
+	Acknowledged *Acknowledgement `json:"acknowledged,omitempty"`
+}
+
+// Firing returns the rules firing for every unit, sorted by unit and rule
+func Firing() []FiringViolation {
+	p := active.Load()
+	if p == nil {
+		return nil
+	}
+
+	defer violations.mutex.Unlock()
+	violations.mutex.Lock()
+	var firing []FiringViolation
+	for _, cg := range slices.Sorted(maps.Keys(violations.data)) {
+		for _, rule := range p.Rules {
+			if state, ok := violations.data[cg][rule.Name]; ok && state.firing {
+				firing = append(firing, FiringViolation{
+					Unit:         path.Base(cg),
+					Cgroup:       cg,
+					Rule:         rule.Name,
+					Severity:     rule.Severity,
+					Since:        state.since,
+					Acknowledged: state.acknowledged,
+				})
+			}
+		}
+	}
+	return firing
+}
+
+// Acknowledged reports whether the rule firing for the unit was acknowledged
+func Acknowledged(cg string, rule string) bool {
+	defer violations.mutex.Unlock()
+	violations.mutex.Lock()
+	state, ok := violations.data[cg][rule]
+	return ok && state.acknowledged != nil
+}
+
+// FiringRules returns the rules firing for the unit with their severities, sorted
+// by name
+func FiringRules(cg string) []Rule {
+	p := active.Load()
+	if p == nil {
+		return nil
+	}
+
+	violations.mutex.Lock()
+	states := violations.data[cg]
+	var rules []Rule
+	for _, rule := range p.Rules {
+		if state, ok := states[rule.Name]; ok && state.firing {
+			rules = append(rules, rule)
+		}
+	}
+	violations.mutex.Unlock()
+
+	slices.SortFunc(rules, func(a, b Rule) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return rules
+}
+
+// event returns the notification of the violation
+func (v Violation) event() notify.Event {
+	e := notify.Event{
+		Kind:     notify.Violation,
+		Unit:     v.Unit,
+		Cgroup:   v.Cgroup,
+		Username: v.Username,
+		Severity: v.Severity,
+		Rule:     v.Rule,
+		Time:     v.Time,
+		Summary:  fmt.Sprintf("%s usage of %s is %s, over %s since %s", v.Metric, v.Unit, formatUsage(v.Metric, v.Value), formatUsage(v.Metric, v.Threshold), v.Since.Format(time.Kitchen)),
+	}
+	if v.Resolved {
+		e.Kind = notify.Resolved
+		e.Summary = fmt.Sprintf("%s usage of %s is back below %s", v.Metric, v.Unit, formatUsage(v.Metric, v.Threshold))
+	}
+	return e
+}
+
+// formatUsage formats cpu usage as cores, and memory and swap usage as GiB
+func formatUsage(metric string, value float64) string {
+	if metric == "cpu" {
+		return fmt.Sprintf("%.2f cores", value)
+	}
+	return fmt.Sprintf("%.2f GiB", value/(1<<30))
+}
+
+// evaluate samples the usage of the unit, checks it against the rules of the
+// policy, and then against the penalties
+func (e *Engine) evaluate(ctx context.Context, unit string) {
+	u, ok := e.sample(unit)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	for i := range e.policy.Rules {
+		rule := &e.policy.Rules[i]
+		threshold, ok := rule.threshold(u)
+		value := u.value(rule.Metric)
+		// a violation of a rule that no longer applies to the unit is resolved
+		over := ok && value > threshold && rule.appliesTo(unit)
+
+		state, changed := violations.update(u.cg, rule, over, now)
+		if !changed {
+			continue
+		}
+
+		v := Violation{
+			Unit:      unit,
+			Cgroup:    u.cg,
+			Username:  u.username,
+			Rule:      rule.Name,
+			Severity:  rule.Severity,
+			Metric:    rule.Metric,
+			Value:     value,
+			Threshold: threshold,
+			Since:     state.since,
+			Resolved:  !over,
+			Time:      now,
+		}
+
+		if over {
+			slog.Warn("unit violated rule", "unit", unit, "rule", rule.Name, "severity", rule.Severity, "value", value, "threshold", threshold, "since", state.since)
+		} else {
+			slog.Info("violation resolved", "unit", unit, "rule", rule.Name)
+		}
+		notify.Send(v.event())
+	}
+
+	if e.policy.Penalties != nil {
+		e.penalize(ctx, unit, u)
+	}
+}
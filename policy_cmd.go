@@ -0,0 +1,96 @@
+//go:build !readonly
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/hierarchy"
+	"github.com/chpc-uofu/cgroup-warden/policy"
+)
+
+// runPolicy implements the policy subcommand, reporting the limits a policy file
+// would apply to the units currently on the node without changing them.
+func runPolicy(args []string) int {
+	fs := flag.NewFlagSet("policy", flag.ExitOnError)
+	root := fs.String("root", envOr("CGROUP_WARDEN_ROOT_CGROUP", "/user.slice"), "report units underneath this cgroup")
+	file := fs.String("file", os.Getenv("CGROUP_WARDEN_POLICY_FILE"), "path of the policy file")
+	username := fs.String("user", "", "only report the units of this user")
+	at := fs.String("at", "", "report the limits at this time, like \"2006-01-02 15:04\", instead of now")
+	fs.Parse(args)
+
+	now := time.Now()
+	if *at != "" {
+		t, err := time.ParseInLocation("2006-01-02 15:04", *at, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid time '%s'\n", *at)
+			return 1
+		}
+		now = t
+	}
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "a policy file is required")
+		return 1
+	}
+
+	p, err := policy.Load(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	h := hierarchy.NewHierarchy(*root)
+	children, err := h.Children(*root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if schedules := p.ActiveSchedules(now); len(schedules) > 0 {
+		fmt.Printf("active schedules: %s\n\n", strings.Join(schedules, ", "))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UNIT\tUSER\tOVERRIDES\tLIMITS")
+	for _, cg := range children {
+		unit := path.Base(cg)
+		if !p.Matches(unit) {
+			continue
+		}
+
+		s := policy.NewSubject(*root, unit)
+		if *username != "" && s.Username != *username {
+			continue
+		}
+
+		if reason, ok := p.Exempted(s); ok {
+			fmt.Fprintf(tw, "%s\t%s\t\texempt (%s)\n", unit, s.Username, reason)
+			continue
+		}
+
+		limits, applied := p.Limits(s, now)
+		overrides := make([]string, 0, len(applied))
+		for _, i := range applied {
+			overrides = append(overrides, fmt.Sprint(i+1))
+		}
+
+		names := slices.Sorted(maps.Keys(limits))
+		values := make([]string, 0, len(names))
+		for _, name := range names {
+			values = append(values, fmt.Sprintf("%s=%v", name, limits[name]))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", unit, s.Username, strings.Join(overrides, ","), strings.Join(values, " "))
+	}
+	tw.Flush()
+	return 0
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPProf serves the pprof profiles of the warden under /debug/pprof, to
+// requests with a token granted debug:read even in insecure mode, since the
+// profiles expose the internals of the process and a CPU profile costs a scrape
+// or more of CPU time.
+func registerPProf(mux *http.ServeMux, conf *Config, tokens *tokenStore) {
+	debug := func(handler http.HandlerFunc) http.Handler {
+		return allowNetworks(authorize(handler, tokens, scopeDebugRead), conf.MetricsAllowedNetworks)
+	}
+
+	mux.Handle("GET /debug/pprof/", debug(pprof.Index))
+	mux.Handle("GET /debug/pprof/cmdline", debug(pprof.Cmdline))
+	mux.Handle("GET /debug/pprof/profile", debug(pprof.Profile))
+	mux.Handle("GET /debug/pprof/symbol", debug(pprof.Symbol))
+	mux.Handle("POST /debug/pprof/symbol", debug(pprof.Symbol))
+	mux.Handle("GET /debug/pprof/trace", debug(pprof.Trace))
+	slog.Info("serving pprof profiles", "path", "/debug/pprof/")
+}
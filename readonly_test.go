@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const module = "github.com/chpc-uofu/cgroup-warden"
+
+// packages able to change units or warden state, which read-only builds leave out
+var enforcementPackages = []string{"admin", "control", "notify", "policy"}
+
+func deps(t *testing.T, args ...string) []string {
+	out, err := exec.Command("go", append([]string{"list", "-deps"}, append(args, ".")...)...).Output()
+	if err != nil {
+		t.Fatalf("go list: %v", err)
+	}
+	return strings.Fields(string(out))
+}
+
+func TestReadOnlyBuildLeavesOutEnforcement(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	all := deps(t)
+	readOnly := deps(t, "-tags", "readonly")
+	for _, pkg := range enforcementPackages {
+		if !slices.Contains(all, module+"/"+pkg) {
+			t.Errorf("%s is not a dependency of the enforcing build", pkg)
+		}
+		if slices.Contains(readOnly, module+"/"+pkg) {
+			t.Errorf("%s is a dependency of the read-only build", pkg)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package state stores the properties the warden wants applied to units on disk.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+)
+
+// Property is the desired value of a unit property, in the same form as the
+// value of a control request.
+type Property struct {
+	Value   any    `json:"value"`
+	Runtime bool   `json:"runtime"`
+	Source  string `json:"source,omitempty"`
+}
+
+// DesiredState maps unit names to the desired value of their properties.
+type DesiredState struct {
+	Units map[string]map[string]Property `json:"units"`
+	mutex sync.Mutex
+}
+
+func New() *DesiredState {
+	return &DesiredState{Units: make(map[string]map[string]Property)}
+}
+
+// ReadJSON decodes a file into v, leaving v unchanged if the file does not exist.
+func ReadJSON(file string, v any) error {
+	buf, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	return nil
+}
+
+// WriteJSON atomically writes v to a file, readable only by the warden.
+func WriteJSON(file string, v any) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0o700); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Load reads the desired state from a file, returning an empty state if the file does not exist.
+func Load(file string) (*DesiredState, error) {
+	d := New()
+	if err := ReadJSON(file, d); err != nil {
+		return nil, err
+	}
+	if d.Units == nil {
+		d.Units = make(map[string]map[string]Property)
+	}
+	return d, nil
+}
+
+// Save atomically writes the desired state to a file.
+func (d *DesiredState) Save(file string) error {
+	defer d.mutex.Unlock()
+	d.mutex.Lock()
+	return WriteJSON(file, d)
+}
+
+func (d *DesiredState) Get(unit string, name string) (Property, bool) {
+	defer d.mutex.Unlock()
+	d.mutex.Lock()
+	p, ok := d.Units[unit][name]
+	return p, ok
+}
+
+func (d *DesiredState) Set(unit string, name string, p Property) {
+	defer d.mutex.Unlock()
+	d.mutex.Lock()
+	props, ok := d.Units[unit]
+	if !ok {
+		props = make(map[string]Property)
+		d.Units[unit] = props
+	}
+	props[name] = p
+}
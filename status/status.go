@@ -0,0 +1,158 @@
+// Package status classifies the errors of collection and enforcement into a
+// stable taxonomy, counted per component and exposed through the status API.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// kinds of errors, which are part of the api and should not be renamed
+const (
+	DBusTimeout     = "dbus-timeout"
+	Permission      = "permission"
+	MissingProperty = "missing-property"
+	ProcGone        = "proc-gone"
+	PolicyConflict  = "policy-conflict"
+	Other           = "other"
+)
+
+// components reporting errors
+const (
+	Collect     = "collect"
+	Control     = "control"
+	Remediation = "remediation"
+	Notify      = "notify"
+	Audit       = "audit"
+)
+
+// ErrPolicyConflict is wrapped by errors caused by conflicting policies or requests.
+var ErrPolicyConflict = errors.New("policy conflict")
+
+// Classify returns the kind of an error
+func Classify(err error) string {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		switch dbusErr.Name {
+		case "org.freedesktop.DBus.Error.NoReply", "org.freedesktop.DBus.Error.Timeout", "org.freedesktop.DBus.Error.TimedOut":
+			return DBusTimeout
+		case "org.freedesktop.DBus.Error.AccessDenied", "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired":
+			return Permission
+		// systemd reports setting an unknown property as invalid arguments
+		case "org.freedesktop.DBus.Error.UnknownProperty", "org.freedesktop.DBus.Error.PropertyReadOnly", "org.freedesktop.DBus.Error.InvalidArgs":
+			return MissingProperty
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrPolicyConflict):
+		return PolicyConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return DBusTimeout
+	case errors.Is(err, fs.ErrPermission):
+		return Permission
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, syscall.ESRCH):
+		return ProcGone
+	}
+	return Other
+}
+
+type errorKey struct {
+	component string
+	kind      string
+}
+
+// ErrorSummary describes the errors of one kind reported by a component
+type ErrorSummary struct {
+	Component string    `json:"component"`
+	Kind      string    `json:"kind"`
+	Count     uint64    `json:"count"`
+	LastError string    `json:"last_error"`
+	LastUnit  string    `json:"last_unit,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type errorStore struct {
+	data  map[errorKey]*ErrorSummary
+	mutex sync.Mutex
+}
+
+var store = &errorStore{data: make(map[errorKey]*ErrorSummary)}
+
+// Errors counts the reported errors, to be registered alongside the cgroup metrics.
+var Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cgroup_warden",
+	Name:      "errors",
+	Help:      "Number of errors of each kind encountered by each component of the warden",
+}, []string{"component", "kind"})
+
+// Report records an error of a component, returning its kind. The unit may be
+// empty for errors not specific to a unit.
+func Report(component string, unit string, err error) string {
+	kind := Classify(err)
+	Errors.WithLabelValues(component, kind).Inc()
+
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+
+	key := errorKey{component, kind}
+	summary, ok := store.data[key]
+	if !ok {
+		summary = &ErrorSummary{Component: component, Kind: kind}
+		store.data[key] = summary
+	}
+	summary.Count++
+	summary.LastError = err.Error()
+	summary.LastUnit = unit
+	summary.LastSeen = time.Now()
+	return kind
+}
+
+// Summaries returns a summary of the reported errors, ordered by component and kind.
+func Summaries() []ErrorSummary {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+
+	summaries := make([]ErrorSummary, 0, len(store.data))
+	for _, summary := range store.data {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Component != summaries[j].Component {
+			return summaries[i].Component < summaries[j].Component
+		}
+		return summaries[i].Kind < summaries[j].Kind
+	})
+	return summaries
+}
+
+type statusResponse struct {
+	Version string         `json:"version"`
+	Started time.Time      `json:"started"`
+	Errors  []ErrorSummary `json:"errors"`
+}
+
+var started = time.Now()
+
+// Handler returns the version of the warden and a summary of its errors.
+func Handler(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := statusResponse{
+			Version: version,
+			Started: started,
+			Errors:  Summaries(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+// notifyReady tells systemd the warden is serving requests, for services of
+// Type=notify, doing nothing otherwise
+func notifyReady() {
+	sent, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+	if err != nil {
+		slog.Warn("unable to notify systemd of readiness", "err", err)
+		return
+	}
+	if sent {
+		slog.Debug("notified systemd of readiness")
+	}
+}
+
+// startWatchdog pings the systemd watchdog of the service if it has WatchdogSec
+// set, twice per interval as long as systemd still answers over dbus, so that a
+// hung dbus connection gets the service restarted.
+func startWatchdog(ctx context.Context) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		slog.Warn("invalid watchdog settings", "err", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	slog.Info("pinging systemd watchdog", "interval", interval)
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			err := checkSystemd(ctx, interval/2)
+			if err != nil {
+				slog.Warn("systemd is not answering, not pinging the watchdog", "err", err)
+			} else {
+				daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkSystemd asks systemd for its state over dbus within the timeout
+func checkSystemd(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.SystemStateContext(ctx)
+	return err
+}
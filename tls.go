@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certificateLoader serves the certificate and key files of the listener,
+// loading them again once either file changes, so that a renewed certificate is
+// served without restarting the warden.
+type certificateLoader struct {
+	certFile string
+	keyFile  string
+
+	certificate *tls.Certificate
+	modified    time.Time
+	mutex       sync.Mutex
+}
+
+// newTLSConfig returns the TLS configuration of the server for the certificate
+// and key files, or nil if neither is given. With a CA file, clients must present
+// a certificate signed by one of its CAs, and with names, one of the names of the
+// certificate must also be in the list.
+func newTLSConfig(certFile string, keyFile string, clientCAFile string, clientNames []string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	loader := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	_, err := loader.load()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.getCertificate,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	buf, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(clientNames) > 0 {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyClientName(cs, clientNames)
+		}
+	}
+	return config, nil
+}
+
+// verifyClientName checks that the common name or one of the subject alternative
+// names of the client certificate is allowed
+func verifyClientName(cs tls.ConnectionState, allowed []string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("client certificate required")
+	}
+	cert := cs.PeerCertificates[0]
+	for _, name := range certificateNames(cert) {
+		if slices.Contains(allowed, name) {
+			return nil
+		}
+	}
+	slog.Warn("refused client certificate", "subject", cert.Subject.String())
+	return fmt.Errorf("client certificate %s is not allowed", cert.Subject)
+}
+
+// certificateNames returns the common name and subject alternative names of a
+// certificate
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// load loads the key pair if either file changed since it was last loaded
+func (l *certificateLoader) load() (*tls.Certificate, error) {
+	defer l.mutex.Unlock()
+	l.mutex.Lock()
+
+	modified, err := lastModified(l.certFile, l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if l.certificate != nil && !modified.After(l.modified) {
+		return l.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if l.certificate != nil {
+		slog.Info("loaded renewed certificate", "certificate", l.certFile)
+	}
+	l.certificate, l.modified = &certificate, modified
+	return l.certificate, nil
+}
+
+// getCertificate returns the certificate for a handshake, the one loaded last if
+// the files cannot be loaded again, like in the middle of being replaced.
+func (l *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate, err := l.load()
+	if err != nil {
+		slog.Warn("unable to load certificate, serving the previous one", "certificate", l.certFile, "err", err)
+		l.mutex.Lock()
+		certificate = l.certificate
+		l.mutex.Unlock()
+	}
+	return certificate, nil
+}
+
+func lastModified(files ...string) (time.Time, error) {
+	var modified time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
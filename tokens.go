@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopes granted to tokens
+const (
+	scopeMetricsRead = "metrics:read"
+	scopeUnitRead    = "unit:read"
+	scopeUnitWrite   = "unit:write"
+	scopeUnitKill    = "unit:kill"
+	scopeDebugRead   = "debug:read"
+)
+
+var scopes = []string{scopeMetricsRead, scopeUnitRead, scopeUnitWrite, scopeUnitKill, scopeDebugRead}
+
+// token is a bearer token of the token file, named for the audit log
+type token struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+}
+
+type tokenFile struct {
+	Tokens []token `json:"tokens"`
+}
+
+// tokenStore holds the tokens allowed to make requests, loading the token file
+// again once it changes. The bearer token of the configuration is granted every
+// scope, and JWTs the scopes of their claims.
+type tokenStore struct {
+	file   string
+	static string
+	web    *webUsers
+	jwt    *jwtVerifier
+
+	tokens   []token
+	modified time.Time
+	mutex    sync.Mutex
+}
+
+func newTokenStore(file string, static string, webConfigFile string) (*tokenStore, error) {
+	store := &tokenStore{file: file, static: static}
+	if webConfigFile != "" {
+		store.web = &webUsers{file: webConfigFile}
+	}
+	if file == "" {
+		return store, nil
+	}
+	return store, store.reload()
+}
+
+// scoped reports whether the tokens come from a token file or are JWTs
+func (store *tokenStore) scoped() bool {
+	return store.file != "" || store.jwt != nil
+}
+
+// configured reports whether any token can authenticate, scoped or not
+func (store *tokenStore) configured() bool {
+	return store.static != "" || store.scoped()
+}
+
+// reload loads the token file if it changed since it was last loaded, which must
+// be called with the lock held once the store is created
+func (store *tokenStore) reload() error {
+	info, err := os.Stat(store.file)
+	if err != nil {
+		return err
+	}
+	if store.tokens != nil && !info.ModTime().After(store.modified) {
+		return nil
+	}
+
+	tokens, err := readTokens(store.file)
+	if err != nil {
+		return err
+	}
+	if store.tokens != nil {
+		slog.Info("loaded tokens", "file", store.file, "tokens", len(tokens))
+	}
+	store.tokens, store.modified = tokens, info.ModTime()
+	return nil
+}
+
+func readTokens(file string) ([]token, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var f tokenFile
+	err = json.Unmarshal(buf, &f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+
+	names := make(map[string]bool, len(f.Tokens))
+	for i, t := range f.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("token %d of %s needs a name and a token", i+1, file)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("token %s of %s is given twice", t.Name, file)
+		}
+		names[t.Name] = true
+		for _, scope := range t.Scopes {
+			if !slices.Contains(scopes, scope) {
+				return nil, fmt.Errorf("invalid scope '%s' of token %s, expected one of %v", scope, t.Name, scopes)
+			}
+		}
+	}
+	return append([]token{}, f.Tokens...), nil
+}
+
+// lookup returns the token matching the bearer token of a request, or the token
+// of a valid JWT. A token file that cannot be loaded again keeps the tokens
+// loaded last.
+func (store *tokenStore) lookup(bearer string) (token, bool) {
+	if t, ok := store.lookupToken(bearer); ok {
+		return t, true
+	}
+	if store.jwt == nil || strings.Count(bearer, ".") != 2 {
+		return token{}, false
+	}
+
+	t, err := store.jwt.verify(bearer)
+	if err != nil {
+		slog.Warn("refused jwt", "err", err)
+		return token{}, false
+	}
+	return t, true
+}
+
+func (store *tokenStore) lookupToken(bearer string) (token, bool) {
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+
+	if store.file != "" {
+		if err := store.reload(); err != nil {
+			slog.Warn("unable to load tokens, keeping the previous ones", "file", store.file, "err", err)
+		}
+	}
+
+	if store.static != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(store.static)) == 1 {
+		return token{Name: "token", Token: store.static, Scopes: scopes}, true
+	}
+	for _, t := range store.tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return token{}, false
+}
+
+// lookupUser returns the token of a basic auth user of the web configuration
+// file, which has the scopes of the token of the same name in the token file, or
+// every scope without a token file
+func (store *tokenStore) lookupUser(user string) (token, bool) {
+	if store.web == nil || !store.web.has(user) {
+		return token{}, false
+	}
+
+	defer store.mutex.Unlock()
+	store.mutex.Lock()
+
+	if store.file == "" {
+		return token{Name: user, Scopes: scopes}, true
+	}
+	if err := store.reload(); err != nil {
+		slog.Warn("unable to load tokens, keeping the previous ones", "file", store.file, "err", err)
+	}
+	for _, t := range store.tokens {
+		if t.Name == user {
+			return t, true
+		}
+	}
+	return token{Name: user}, true
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chpc-uofu/cgroup-warden/metrics"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// environment passed to the new process of an upgrade
+const (
+	listenFDEnv        = "CGROUP_WARDEN_LISTEN_FD"
+	controlListenFDEnv = "CGROUP_WARDEN_CONTROL_LISTEN_FD"
+	handoverFileEnv    = "CGROUP_WARDEN_HANDOVER_FILE"
+)
+
+// name of the socket passed by systemd for the control api, the
+// FileDescriptorName of its socket unit
+const controlSocketName = "control"
+
+// time given to in-flight requests of the old process to complete after an upgrade
+const drainTimeout = 30 * time.Second
+
+// listen returns the listener of the server, and of the control api if it has an
+// address of its own, inherited from the previous process if it was started by an
+// upgrade, or passed by systemd if the service is socket activated. The socket
+// named "control" is the one of the control api.
+func listen(address string, controlAddress string) (net.Listener, net.Listener, error) {
+	activated, err := activation.ListenersWithNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	var sockets, controlSockets []net.Listener
+	for name, listeners := range activated {
+		if name == controlSocketName {
+			controlSockets = append(controlSockets, listeners...)
+		} else {
+			sockets = append(sockets, listeners...)
+		}
+	}
+
+	ln, err := listenOn(address, listenFDEnv, sockets)
+	if err != nil || controlAddress == "" {
+		return ln, nil, err
+	}
+	control, err := listenOn(controlAddress, controlListenFDEnv, controlSockets)
+	if err != nil {
+		ln.Close()
+		return nil, nil, fmt.Errorf("control api: %w", err)
+	}
+	return ln, control, nil
+}
+
+// listenOn returns the listener inherited through the environment variable, the
+// first of the sockets passed by systemd, or a new listener on the address
+func listenOn(address string, fdEnv string, sockets []net.Listener) (net.Listener, error) {
+	fd, ok := os.LookupEnv(fdEnv)
+	if !ok {
+		for _, ln := range sockets {
+			if ln != nil {
+				slog.Info("using socket passed by systemd", "address", ln.Addr().String())
+				return ln, nil
+			}
+		}
+		return net.Listen("tcp", address)
+	}
+	os.Unsetenv(fdEnv)
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid inherited listener '%s'", fd)
+	}
+
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// restoreHandover restores the counters handed over by the previous process.
+func restoreHandover() {
+	file, ok := os.LookupEnv(handoverFileEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(handoverFileEnv)
+	defer os.Remove(file)
+
+	err := metrics.RestoreCounters(file)
+	if err != nil {
+		slog.Warn("unable to restore counters of the previous process", "file", file, "err", err)
+		return
+	}
+	slog.Info("restored counters of the previous process")
+}
+
+// upgradeOnSignal starts the warden binary again on SIGUSR2, handing over the
+// listeners and the counters, then shuts the servers down once the in-flight
+// requests completed. The listener of the control api is handed over second if
+// there is one, and the returned channel is closed when the servers are drained.
+func upgradeOnSignal(servers []*http.Server, listeners []net.Listener) <-chan struct{} {
+	drained := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+
+	go func() {
+		for range signals {
+			pid, err := upgrade(listeners)
+			if err != nil {
+				slog.Error("unable to upgrade", "err", err)
+				continue
+			}
+			slog.Info("upgraded, draining requests", "pid", pid)
+
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			for _, server := range servers {
+				err = server.Shutdown(ctx)
+				if err != nil {
+					slog.Warn("unable to drain requests", "err", err)
+				}
+			}
+			cancel()
+			close(drained)
+			return
+		}
+	}()
+	return drained
+}
+
+func upgrade(listeners []net.Listener) (int, error) {
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range listeners {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return 0, errors.New("listener cannot be handed over")
+		}
+
+		f, err := tcp.File()
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+	}
+
+	// the path of a replaced binary still refers to the new one
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	handover := path.Join(os.TempDir(), fmt.Sprintf("cgroup-warden-handover-%d.json", os.Getpid()))
+	err = metrics.SaveCounters(handover)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	// the watchdog is pinged by whichever process is the main process of the
+	// service, which the new process becomes
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, "WATCHDOG_PID=")
+	})
+	cmd.Env = append(env, listenFDEnv+"=3", handoverFileEnv+"="+handover)
+	if len(files) > 1 {
+		cmd.Env = append(cmd.Env, controlListenFDEnv+"=4")
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		os.Remove(handover)
+		return 0, err
+	}
+
+	// tell systemd the new process is now the main process of the service
+	_, err = daemon.SdNotify(false, fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+	if err != nil {
+		slog.Warn("unable to notify systemd of the new main process", "err", err)
+	}
+
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// buildCommit returns the commit the binary was built from, falling back to
+// the vcs information embedded by the go toolchain if not set with -ldflags.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return "unknown"
+}
+
+func versionString() string {
+	return fmt.Sprintf("cgroup-warden version %s (commit %s, %s)", version, buildCommit(), runtime.Version())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig is the part of an exporter-toolkit web configuration file read by
+// the warden itself, next to the toolkit serving the listener with it
+type webConfig struct {
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+	} `yaml:"tls_server_config"`
+	Users map[string]string `yaml:"basic_auth_users"`
+}
+
+func readWebConfig(file string) (webConfig, error) {
+	var c webConfig
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return c, err
+	}
+	err = yaml.Unmarshal(buf, &c)
+	return c, err
+}
+
+// webUsers holds the basic auth users of the web configuration file, loading it
+// again once it changes like the toolkit does
+type webUsers struct {
+	file string
+
+	users    map[string]string
+	modified time.Time
+	mutex    sync.Mutex
+}
+
+// has reports whether the user is one of the basic auth users, whose password
+// the toolkit verifies before a request reaches the handlers
+func (wu *webUsers) has(user string) bool {
+	defer wu.mutex.Unlock()
+	wu.mutex.Lock()
+
+	info, err := os.Stat(wu.file)
+	if err == nil && info.ModTime().After(wu.modified) {
+		c, err := readWebConfig(wu.file)
+		if err == nil {
+			wu.users, wu.modified = c.Users, info.ModTime()
+		}
+	}
+	_, ok := wu.users[user]
+	return ok
+}